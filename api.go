@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiDefaultLimit is how many clips a page holds when the request doesn't say
+	apiDefaultLimit = 20
+	// apiMaxLimit bounds how many clips a single page can hold
+	apiMaxLimit = 100
+	// apiMaxResults bounds how deep pagination goes, as every page walks the whole window again
+	apiMaxResults = 1000
+)
+
+// apiClipsResponse is the body of the clip endpoints, paginated like Twitch's API
+type apiClipsResponse struct {
+	Data       []clipRecord `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor,omitempty"`
+	} `json:"pagination"`
+}
+
+// apiPage is the page of clips a request asks for
+type apiPage struct {
+	offset int
+	limit  int
+	// search identifies the search the page belongs to, so a cursor only pages through the search it came from
+	search string
+}
+
+// apiErrorResponse is the body of every failed API request
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// APIHandler serves the HTTP JSON API under /v1: clip searches, top clips and broadcaster lookups. Requests
+// need one of its keys and each key is charged its requests' estimated Twitch cost against an hourly budget.
+type APIHandler struct {
+	twitch TwitchAPI
+	keys   []string
+	limits *RateLimiter
+	now    func() time.Time
+}
+
+// NewAPIHandler returns an APIHandler answering with t that accepts keys, each with an hourly budget of Twitch
+// requests, unlimited if 0
+func NewAPIHandler(t TwitchAPI, keys []string, budget int) *APIHandler {
+	return &APIHandler{
+		twitch: t,
+		keys:   keys,
+		limits: NewRateLimiter(0, 0, budget, 0),
+		now:    time.Now,
+	}
+}
+
+// ParseAPIKeys splits a comma separated list of API keys, skipping empty ones
+func ParseAPIKeys(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (api *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := apiRoute(r.URL.Path)
+	var status int
+	if route == "/openapi.json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(openAPISpec))
+		status = http.StatusOK
+	} else {
+		status = api.serve(w, r, route)
+	}
+	apiRequestsTotal.Inc(route, strconv.Itoa(status))
+}
+
+// apiRoute returns the route template a request path matches, or "other", so metrics are labelled by route
+// instead of by whatever path was requested
+func apiRoute(path string) string {
+	endpoint := strings.TrimPrefix(path, "/v1")
+	switch endpoint {
+	case "/clips/search", "/clips/top", "/openapi.json":
+		return endpoint
+	}
+	if login := strings.TrimPrefix(endpoint, "/broadcasters/"); login != endpoint && login != "" && !strings.Contains(login, "/") {
+		return "/broadcasters/{login}"
+	}
+	return "other"
+}
+
+// serve answers a request to route, returning the status it answered with
+func (api *APIHandler) serve(w http.ResponseWriter, r *http.Request, route string) int {
+	if route == "other" {
+		return writeAPIError(w, http.StatusNotFound, "no such endpoint")
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		return writeAPIError(w, http.StatusMethodNotAllowed, "only GET is supported")
+	}
+	keyID, ok := api.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return writeAPIError(w, http.StatusUnauthorized, "a valid API key is required")
+	}
+
+	if route == "/broadcasters/{login}" {
+		login := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1"), "/broadcasters/")
+		if status, ok := api.charge(w, keyID, 1); !ok {
+			return status
+		}
+		broadcasters, err := api.twitch.GetBroadcastersByName([]string{login})
+		if err != nil {
+			return writeTwitchError(w, err)
+		}
+		return writeAPIJSON(w, broadcasters[0])
+	}
+
+	command, page, err := parseAPIRequest(r, strings.TrimPrefix(route, "/clips/"))
+	if err != nil {
+		return writeAPIError(w, http.StatusBadRequest, err.Error())
+	}
+	if status, ok := api.charge(w, keyID, EstimateCost(command, command.StartedAt, api.now())); !ok {
+		return status
+	}
+
+	broadcasters, err := api.twitch.GetBroadcastersByName([]string{command.Broadcaster})
+	if err != nil {
+		return writeTwitchError(w, err)
+	}
+	targetClip := Clip{
+		BroadcasterID: broadcasters[0].ID,
+		Title:         command.Title,
+		CreatorName:   command.Creator,
+		StartedAt:     command.StartedAt,
+		EndedAt:       command.EndedAt,
+	}
+
+	var clips []Clip
+	var indexed bool
+	if command.SubCommand == "" {
+		clips, indexed = api.twitch.SearchClips(targetClip)
+	}
+	switch {
+	case indexed && targetClip.Title != "":
+		// Rank searches by relevance when the index can, like the bot does
+	case command.SubCommand == "" && targetClip.Title != "" && targetClip.CreatorName != "":
+		// A title and a creator point to a specific clip, so answer with the one the bot would find
+		clip, err := api.twitch.FindBestClip(targetClip)
+		if err != nil {
+			return writeTwitchError(w, err)
+		}
+		if clip != targetClip {
+			clips = []Clip{clip}
+		}
+	default:
+		// One more than the page, to know whether there's a next one
+		clips, err = api.twitch.FindMostPopularClips(targetClip, matchMany(matchTitle, matchCreator), page.offset+page.limit+1)
+		if err != nil {
+			return writeTwitchError(w, err)
+		}
+	}
+
+	response := apiClipsResponse{Data: []clipRecord{}}
+	for i := page.offset; i < len(clips) && i < page.offset+page.limit; i++ {
+		response.Data = append(response.Data, newClipRecord(clips[i]))
+	}
+	if next := page.offset + page.limit; next < len(clips) && next < apiMaxResults {
+		response.Pagination.Cursor = encodeAPICursor(next, page.search)
+	}
+	return writeAPIJSON(w, response)
+}
+
+// authenticate returns a non secret ID of the request's API key, passed as a bearer token or in X-API-Key,
+// reporting whether it's one of the handler's keys
+func (api *APIHandler) authenticate(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		return "", false
+	}
+
+	found := false
+	for _, k := range api.keys {
+		// Compare every key in constant time, so response times don't leak how much of a key was right
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			found = true
+		}
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4]), found
+}
+
+// charge charges a request's cost to its key, answering with a 429 and reporting false if the key is out of budget
+func (api *APIHandler) charge(w http.ResponseWriter, keyID string, cost int) (int, bool) {
+	wait, err := api.limits.Allow("", keyID, cost)
+	if err == ErrOverBudget {
+		return writeAPIError(w, http.StatusBadRequest, "the search covers too long a period, narrow it down with from and to"), false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return writeAPIError(w, http.StatusTooManyRequests, "rate limited, try again in "+wait.Round(time.Second).String()), false
+	}
+	return 0, true
+}
+
+// parseAPIRequest builds the command of a search or top request, along with the page it asks for. The search can
+// be given in the bot's command syntax with q, and the other parameters take precedence over it.
+func parseAPIRequest(r *http.Request, name string) (Command, apiPage, error) {
+	query := r.URL.Query()
+	config := DefaultGuildConfig()
+	if name == "search" {
+		name = ""
+	}
+
+	command := Command{SubCommand: name}
+	if q := query.Get("q"); q != "" {
+		text := config.Prefix + " " + q
+		if name == "top" {
+			text = config.Prefix + " top " + q
+		}
+		var err error
+		if command, err = ParseGuildCommand(text, config); err != nil {
+			return Command{}, apiPage{}, err
+		}
+		if command.SubCommand != name {
+			return Command{}, apiPage{}, errors.New("api: unexpected \"" + command.Name() + "\" subcommand in q")
+		}
+	}
+
+	if broadcaster := query.Get("broadcaster"); broadcaster != "" {
+		command.Broadcaster = broadcaster
+	}
+	if title := query.Get("title"); title != "" {
+		command.Title = title
+	}
+	if creator := query.Get("creator"); creator != "" {
+		command.Creator = creator
+	}
+	for _, date := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &command.StartedAt}, {"to", &command.EndedAt}} {
+		value := query.Get(date.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", value); err != nil {
+				return Command{}, apiPage{}, errors.New("api: " + date.name + " must be a date like 2006-01-02 or a RFC 3339 time")
+			}
+		}
+		*date.t = t
+	}
+	if command.Broadcaster == "" {
+		return Command{}, apiPage{}, errors.New("api: broadcaster is required")
+	}
+	if command.StartedAt.IsZero() {
+		command.StartedAt = config.PeriodStart()
+	}
+	if !command.EndedAt.IsZero() && command.StartedAt.After(command.EndedAt) {
+		return Command{}, apiPage{}, errors.New("api: from must be before to")
+	}
+
+	page := apiPage{limit: apiDefaultLimit, search: apiSearchKey(name, query)}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if page.limit, err = strconv.Atoi(value); err != nil || page.limit < 1 || page.limit > apiMaxLimit {
+			return Command{}, apiPage{}, errors.New("api: limit must be a number between 1 and " + strconv.Itoa(apiMaxLimit))
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		if page.offset, err = decodeAPICursor(cursor, page.search); err != nil {
+			return Command{}, apiPage{}, err
+		}
+	}
+	if page.offset >= apiMaxResults {
+		return Command{}, apiPage{}, errors.New("api: pagination is limited to the first " + strconv.Itoa(apiMaxResults) + " clips")
+	}
+	if page.offset+page.limit > apiMaxResults {
+		page.limit = apiMaxResults - page.offset
+	}
+	return command, page, nil
+}
+
+// apiSearchKey identifies the search of a request by its endpoint and parameters, leaving out the ones that only
+// choose the page
+func apiSearchKey(name string, query url.Values) string {
+	search := url.Values{}
+	for key, values := range query {
+		if key != "cursor" && key != "limit" {
+			search[key] = values
+		}
+	}
+	sum := sha256.Sum256([]byte(name + "?" + search.Encode()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeAPICursor returns an opaque cursor for the page of a search starting at offset
+func encodeAPICursor(offset int, search string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset) + ":" + search))
+}
+
+// decodeAPICursor returns the offset of a cursor, failing if it came from a different search
+func decodeAPICursor(cursor string, search string) (int, error) {
+	invalid := errors.New("api: invalid cursor")
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	parts := strings.Split(string(decoded), ":")
+	if err != nil || len(parts) != 3 || parts[0] != "offset" {
+		return 0, invalid
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || offset < 0 {
+		return 0, invalid
+	}
+	if parts[2] != search {
+		return 0, errors.New("api: the cursor belongs to another search")
+	}
+	return offset, nil
+}
+
+func writeAPIJSON(w http.ResponseWriter, body interface{}) int {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+	return http.StatusOK
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) int {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{Error: message})
+	return status
+}
+
+// writeTwitchError answers for a failed Twitch request, telling missing broadcasters apart from Twitch failing
+func writeTwitchError(w http.ResponseWriter, err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrNoBroadcasters):
+		return writeAPIError(w, http.StatusNotFound, "no such broadcaster")
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return writeAPIError(w, http.StatusGatewayTimeout, "Twitch took too long to answer")
+	}
+	Log.Warn("API request to Twitch failed", "err", err)
+	return writeAPIError(w, http.StatusBadGateway, "Twitch couldn't answer the request")
+}
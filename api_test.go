@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAPIHandler(t *testing.T, budget int) *APIHandler {
+	twitch := newCLITwitchAPI(t, cliHandler)
+	twitch.AccessToken = "my-test-token"
+	return NewAPIHandler(*twitch, []string{"key-one", "key-two"}, budget)
+}
+
+func apiRequest(api *APIHandler, path string, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIHandlerAuthentication(t *testing.T) {
+	api := newTestAPIHandler(t, 0)
+
+	if rec := apiRequest(api, "/v1/broadcasters/streamer", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 without a key, got %d", rec.Code)
+	}
+	if rec := apiRequest(api, "/v1/broadcasters/streamer", "wrong-key"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 for a wrong key, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/broadcasters/streamer", nil)
+	req.Header.Set("X-API-Key", "key-two")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a 200 with X-API-Key, got %d: %s", rec.Code, rec.Body)
+	}
+	var broadcaster Broadcaster
+	json.NewDecoder(rec.Body).Decode(&broadcaster)
+	if broadcaster.ID != "1" {
+		t.Errorf("Expected broadcaster 1, got %+v", broadcaster)
+	}
+
+	if rec := apiRequest(api, "/v1/openapi.json", ""); rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("Expected the OpenAPI description without a key, got %d", rec.Code)
+	}
+}
+
+func TestAPIHandlerClips(t *testing.T) {
+	api := newTestAPIHandler(t, 0)
+
+	rec := apiRequest(api, "/v1/clips/top?broadcaster=streamer&limit=2", "key-one")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var page apiClipsResponse
+	json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.Data) != 2 || page.Data[0].ID != "b" || page.Data[1].ID != "c" || page.Pagination.Cursor == "" {
+		t.Fatalf("Unexpected first page %+v", page)
+	}
+
+	rec = apiRequest(api, "/v1/clips/top?broadcaster=streamer&limit=2&cursor="+page.Pagination.Cursor, "key-one")
+	page = apiClipsResponse{}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.Data) != 1 || page.Data[0].ID != "a" || page.Pagination.Cursor != "" {
+		t.Errorf("Unexpected last page %+v", page)
+	}
+
+	rec = apiRequest(api, `/v1/clips/search?q=streamer+"funny"`, "key-one")
+	page = apiClipsResponse{}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.Data) != 2 || page.Data[0].ID != "b" {
+		t.Errorf("Unexpected search results %+v", page)
+	}
+
+	// Both a and c match, but a title and a creator point to a specific clip, the first found like the bot's
+	rec = apiRequest(api, "/v1/clips/search?broadcaster=streamer&title=a&creator=a", "key-one")
+	page = apiClipsResponse{}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.Data) != 1 || page.Data[0].ID != "a" || page.Pagination.Cursor != "" {
+		t.Errorf("Expected only the clip the bot would find, got %+v", page)
+	}
+
+	rec = apiRequest(api, "/v1/clips/search?broadcaster=nobody", "key-one")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a missing broadcaster, got %d", rec.Code)
+	}
+}
+
+func TestAPIHandlerBadRequests(t *testing.T) {
+	api := newTestAPIHandler(t, 0)
+
+	for _, path := range []string{
+		"/v1/clips/search",
+		"/v1/clips/search?broadcaster=streamer&limit=1000",
+		"/v1/clips/search?broadcaster=streamer&cursor=nonsense",
+		"/v1/clips/search?broadcaster=streamer&from=yesterday",
+		"/v1/clips/search?q=stats+streamer",
+		"/v1/clips/search?broadcaster=streamer&from=2020-02-01&to=2020-01-01",
+		"/v1/clips/search?q=streamer%202020-02-31",
+		"/v1/clips/search?q=streamer%2099999999999999999999d",
+		"/v1/clips/search?broadcaster=streamer&cursor=" + encodeAPICursor(2, apiSearchKey("search", url.Values{"broadcaster": {"other"}})),
+	} {
+		if rec := apiRequest(api, path, "key-one"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for %s, got %d", path, rec.Code)
+		}
+	}
+	if rec := apiRequest(api, "/v1/clips/nothing", "key-one"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 for an unknown endpoint, got %d", rec.Code)
+	}
+}
+
+func TestAPIHandlerRateLimit(t *testing.T) {
+	api := newTestAPIHandler(t, 10)
+	now := time.Now()
+	api.now = func() time.Time { return now }
+	api.limits.now = api.now
+
+	// A week costs 8 requests, so the second search doesn't fit in the budget of 10
+	if rec := apiRequest(api, "/v1/clips/top?broadcaster=streamer", "key-one"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := apiRequest(api, "/v1/clips/top?broadcaster=streamer", "key-one")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 429 with Retry-After, got %d", rec.Code)
+	}
+	// Budgets are per key
+	if rec := apiRequest(api, "/v1/clips/top?broadcaster=streamer", "key-two"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a 200 for another key, got %d", rec.Code)
+	}
+
+	if rec := apiRequest(api, "/v1/clips/top?broadcaster=streamer&from=2019-01-01", "key-two"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for a search bigger than the budget, got %d", rec.Code)
+	}
+}
+
+func TestAPIHandlerRequestMetrics(t *testing.T) {
+	api := newTestAPIHandler(t, 0)
+
+	login := apiRequestsTotal.Value("/broadcasters/{login}", "401")
+	other := apiRequestsTotal.Value("other", "404")
+	apiRequest(api, "/v1/broadcasters/streamer", "")
+	apiRequest(api, "/v1/broadcasters/someone-else", "")
+	apiRequest(api, "/v1/random-path-1", "")
+	apiRequest(api, "/v1/broadcasters/", "")
+
+	if got := apiRequestsTotal.Value("/broadcasters/{login}", "401") - login; got != 2 {
+		t.Errorf("Broadcaster requests should share their route label, expected 2 got %v", got)
+	}
+	if got := apiRequestsTotal.Value("other", "404") - other; got != 2 {
+		t.Errorf("Unknown routes should be labelled other, expected 2 got %v", got)
+	}
+	if got := apiRequestsTotal.Value("/v1/random-path-1", "404"); got != 0 {
+		t.Errorf("Requested paths should not be used as labels, got %v", got)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys := ParseAPIKeys(" key-one,,key-two ,")
+	if strings.Join(keys, "|") != "key-one|key-two" {
+		t.Errorf("Expected key-one and key-two, got %q", keys)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Now()
+	cache := NewTTLCache(time.Minute, 0)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("key", "value")
+	value, ok := cache.Get("key")
+	if !ok || value.(string) != "value" {
+		t.Errorf("Value not properly cached: expected \"value\" got %v", value)
+	}
+
+	if _, ok := cache.Get("missing"); ok {
+		t.Errorf("Got a value for a key that was never set")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if value, ok := cache.Get("key"); ok {
+		t.Errorf("Value should have expired, got %v", value)
+	}
+}
+
+func TestTTLCacheSetWithTTL(t *testing.T) {
+	now := time.Now()
+	cache := NewTTLCache(time.Minute, 0)
+	cache.now = func() time.Time { return now }
+
+	cache.SetWithTTL("short", "value", time.Second)
+	cache.SetWithTTL("long", "value", time.Hour)
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.Get("short"); ok {
+		t.Errorf("Value with a short TTL should have expired")
+	}
+	if _, ok := cache.Get("long"); !ok {
+		t.Errorf("Value with a long TTL should not have expired")
+	}
+}
+
+func TestTTLCacheEviction(t *testing.T) {
+	cache := NewTTLCache(time.Minute, 2)
+
+	cache.Set("first", 1)
+	cache.Set("second", 2)
+	cache.Get("first")
+	cache.Set("third", 3)
+
+	if _, ok := cache.Get("second"); ok {
+		t.Errorf("Least recently used value should have been evicted")
+	}
+	if _, ok := cache.Get("first"); !ok {
+		t.Errorf("Recently used value should not have been evicted")
+	}
+	if _, ok := cache.Get("third"); !ok {
+		t.Errorf("Newest value should not have been evicted")
+	}
+
+	stats := cache.Stats()
+	expected := CacheStats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2}
+	if stats != expected {
+		t.Errorf("Cache stats not properly counted: expected %v got %v", expected, stats)
+	}
+}
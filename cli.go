@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// cliCommands are the commands that run a single search from the terminal instead of starting the bot
+var cliCommands = map[string]bool{
+	"search": true,
+	"top":    true,
+}
+
+// Exit codes of the CLI, so scripts can tell why a search failed
+const (
+	exitOK       = 0
+	exitNotFound = 1
+	exitUsage    = 2
+	exitAuth     = 3
+	exitNetwork  = 4
+	exitError    = 5
+)
+
+const cliUsage = `Usage: clips search [flags] streamer ["title"] [creator] [start_date] [end_date]
+   Or: clips top [flags] streamer ["title"] [creator] [start_date] [end_date]
+Arguments are the same as the bot's "!clips" and "!clips top" commands. The shell removes quotes, so arguments
+with spaces are taken as the title, or set it with -title. Credentials are read like the bot's, from flags,
+CLIPS_* environment variables or a -config file.
+Exit codes: 0 found, 1 nothing found, 2 invalid usage, 3 credentials rejected, 4 network error, 5 other errors.
+Flags:
+`
+
+// runCLI runs the CLI command called name with args, writing clips to stdout and errors to stderr, and returns
+// the process's exit code
+func runCLI(name string, args []string, stdout io.Writer, stderr io.Writer, getenv func(string) string) int {
+	fs := flag.NewFlagSet("clips "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	registerFlags(fs)
+	format := fs.String("format", "table", "Output format: table, json (one clip per line) or csv")
+	title := fs.String("title", "", "Title of the clips to look for")
+	creator := fs.String("creator", "", "Name of the user who created the clips")
+	from := fs.String("from", "", "Look for clips created from this date onwards, as YYYY-MM-DD")
+	to := fs.String("to", "", "Look for clips created before this date, as YYYY-MM-DD")
+	top := fs.Int("top", 0, "Number of clips listed by top, the default top of the bot if 0")
+	fs.Usage = func() {
+		io.WriteString(stderr, cliUsage)
+		fs.PrintDefaults()
+	}
+
+	// Flags may come before or after the search's arguments
+	var words []string
+	for rest := args; ; rest = fs.Args()[1:] {
+		if err := fs.Parse(rest); err != nil {
+			if err == flag.ErrHelp {
+				return exitOK
+			}
+			return exitUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		words = append(words, fs.Arg(0))
+	}
+
+	if err := loadConfig(fs, getenv); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	if ClientID == "" || ClientSecret == "" {
+		fmt.Fprintln(stderr, "config: missing client-id or client-secret, set them with flags, "+configEnvPrefix+"* environment variables or a config file")
+		return exitUsage
+	}
+	level, err := ParseLevel(LogLevel)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	Log.Configure(stderr, level, LogFormat)
+	Log.Redact(ClientID, ClientSecret)
+
+	command, err := parseCLICommand(name, words)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	if *title != "" {
+		command.Title = *title
+	}
+	if *creator != "" {
+		command.Creator = *creator
+	}
+	for _, date := range []struct {
+		value string
+		t     *time.Time
+	}{{*from, &command.StartedAt}, {*to, &command.EndedAt}} {
+		if date.value == "" {
+			continue
+		}
+		if *date.t, err = time.Parse("2006-01-02", date.value); err != nil {
+			fmt.Fprintln(stderr, "cli: dates must be formatted as YYYY-MM-DD, got \""+date.value+"\"")
+			return exitUsage
+		}
+	}
+	if *top < 0 {
+		fmt.Fprintln(stderr, "cli: top can't be negative")
+		return exitUsage
+	}
+	if *top > 0 {
+		command.Top = *top
+	}
+	if !isClipFormat(*format) {
+		fmt.Fprintln(stderr, "cli: format must be one of "+strings.Join(clipFormats, ", "))
+		return exitUsage
+	}
+
+	// runCLISearch sets the access token, so its errors are reported like the search's
+	twitch, _ := NewTwitchAPI(ClientID, ClientSecret, false)
+	return runCLISearch(&twitch, command, *format, stdout, stderr)
+}
+
+// parseCLICommand parses the arguments of the CLI command called name as the bot's command of the same name
+func parseCLICommand(name string, words []string) (Command, error) {
+	config := DefaultGuildConfig()
+	text := config.Prefix
+	if name == "top" {
+		text += " top"
+	}
+	for _, word := range words {
+		if strings.ContainsAny(word, " \t") {
+			word = "\"" + word + "\""
+		}
+		text += " " + word
+	}
+
+	command, err := ParseGuildCommand(text, config)
+	if err != nil {
+		return Command{}, err
+	}
+	if command.Name() != name {
+		return Command{}, errors.New("cli: unexpected \"" + command.Name() + "\" subcommand, only searches can be run from the terminal")
+	}
+	if command.Broadcaster == "" {
+		return Command{}, errors.New("cli: need at least the name of a streamer to look for clips")
+	}
+	if command.StartedAt.IsZero() {
+		command.StartedAt = config.PeriodStart()
+	}
+	return command, nil
+}
+
+// runCLISearch runs a search or top command against Twitch and writes the clips found to stdout
+func runCLISearch(t *TwitchAPI, command Command, format string, stdout io.Writer, stderr io.Writer) int {
+	if err := t.SetAuthToken(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return cliExitCode(err)
+	}
+
+	broadcasters, err := t.GetBroadcastersByName([]string{command.Broadcaster})
+	if err != nil {
+		if err == ErrNoBroadcasters {
+			fmt.Fprintln(stderr, "cli: couldn't find a streamer named \""+command.Broadcaster+"\"")
+		} else {
+			fmt.Fprintln(stderr, err)
+		}
+		return cliExitCode(err)
+	}
+	targetClip := Clip{
+		BroadcasterID: broadcasters[0].ID,
+		Title:         command.Title,
+		CreatorName:   command.Creator,
+		StartedAt:     command.StartedAt,
+		EndedAt:       command.EndedAt,
+	}
+
+	var clips []Clip
+	if command.SubCommand == "top" {
+		clips, err = t.FindMostPopularClips(targetClip, matchMany(matchTitle, matchCreator), command.Top)
+	} else {
+		var clip Clip
+		clip, err = t.FindBestClip(targetClip)
+		if clip != targetClip {
+			clips = []Clip{clip}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return cliExitCode(err)
+	}
+	if len(clips) == 0 {
+		fmt.Fprintln(stderr, "cli: no clips match the search")
+		return exitNotFound
+	}
+
+	if err := WriteClips(stdout, format, clips); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// cliExitCode returns the exit code for a failed search
+func cliExitCode(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrNoBroadcasters):
+		return exitNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return exitAuth
+	case errors.As(err, &netErr):
+		return exitNetwork
+	}
+	return exitError
+}
+
+func isClipFormat(format string) bool {
+	for _, f := range clipFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
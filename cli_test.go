@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCLITwitchAPI(t *testing.T, handler http.HandlerFunc) *TwitchAPI {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+	twitch.AuthURL = *mockURL
+	twitch.AuthURL.Path = "/oauth2/token"
+	return &twitch
+}
+
+func cliHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth2/token":
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "my-test-token"})
+	case "/helix/users":
+		if r.URL.Query().Get("login") != "streamer" {
+			json.NewEncoder(w).Encode(BroadcasterResponse{})
+			return
+		}
+		json.NewEncoder(w).Encode(BroadcasterResponse{Data: []Broadcaster{{ID: "1"}}})
+	case "/helix/clips":
+		json.NewEncoder(w).Encode(ClipsResponse{Data: []Clip{
+			{ID: "a", Title: "Big play", CreatorName: "alice", ViewCount: 10, URL: "https://clips.twitch.tv/a"},
+			{ID: "b", Title: "Funny moment", CreatorName: "bob", ViewCount: 30, URL: "https://clips.twitch.tv/b"},
+			{ID: "c", Title: "Funny fail", CreatorName: "carol", ViewCount: 20, URL: "https://clips.twitch.tv/c"},
+		}})
+	}
+}
+
+func TestParseCLICommand(t *testing.T) {
+	command, err := parseCLICommand("search", []string{"streamer", "funny moment", "bob", "2020-01-01"})
+	if err != nil {
+		t.Fatalf("Got an error from parseCLICommand: %s", err)
+	}
+	if command.Broadcaster != "streamer" || command.Title != "funny moment" || command.Creator != "bob" {
+		t.Errorf("parseCLICommand parsed %+v", command)
+	}
+	if !command.StartedAt.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("StartedAt not parsed, got %v", command.StartedAt)
+	}
+
+	command, err = parseCLICommand("top", []string{"streamer"})
+	if err != nil {
+		t.Fatalf("Got an error from parseCLICommand: %s", err)
+	}
+	if command.SubCommand != "top" || command.Top != DefaultGuildConfig().Top || command.StartedAt.IsZero() {
+		t.Errorf("parseCLICommand parsed %+v", command)
+	}
+
+	for _, words := range [][]string{{}, {"stats", "streamer"}, {"config", "get"}} {
+		if _, err := parseCLICommand("search", words); err == nil {
+			t.Errorf("Expected an error from parseCLICommand for %q", words)
+		}
+	}
+}
+
+func TestRunCLISearch(t *testing.T) {
+	twitch := newCLITwitchAPI(t, cliHandler)
+	var stdout, stderr bytes.Buffer
+
+	command := Command{Broadcaster: "streamer", Title: "funny", StartedAt: time.Now().AddDate(0, 0, -7)}
+	if code := runCLISearch(twitch, command, "csv", &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "b,Funny moment,") {
+		t.Errorf("Expected the most popular matching clip, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	command = Command{SubCommand: "top", Broadcaster: "streamer", Top: 2, StartedAt: time.Now().AddDate(0, 0, -7)}
+	if code := runCLISearch(twitch, command, "json", &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	var ids []string
+	decoder := json.NewDecoder(&stdout)
+	for decoder.More() {
+		var record clipRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Couldn't decode JSON line: %s", err)
+		}
+		ids = append(ids, record.ID)
+	}
+	if strings.Join(ids, ",") != "b,c" {
+		t.Errorf("Expected the top 2 clips b,c, got %v", ids)
+	}
+}
+
+func TestRunCLISearchExitCodes(t *testing.T) {
+	unauthorized := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		command Command
+		code    int
+	}{
+		{"no clips", cliHandler, Command{Broadcaster: "streamer", Title: "nothing like it"}, exitNotFound},
+		{"no streamer", cliHandler, Command{Broadcaster: "nobody"}, exitNotFound},
+		{"credentials rejected", unauthorized, Command{Broadcaster: "streamer"}, exitAuth},
+	}
+	for _, test := range tests {
+		twitch := newCLITwitchAPI(t, test.handler)
+		test.command.StartedAt = time.Now().AddDate(0, 0, -7)
+		var stdout, stderr bytes.Buffer
+		if code := runCLISearch(twitch, test.command, "table", &stdout, &stderr); code != test.code {
+			t.Errorf("%s: expected exit code %d, got %d: %s", test.name, test.code, code, stderr.String())
+		}
+		if stdout.Len() > 0 {
+			t.Errorf("%s: expected no output, got %q", test.name, stdout.String())
+		}
+	}
+
+	twitch := newCLITwitchAPI(t, cliHandler)
+	twitch.AuthURL = url.URL{Scheme: "http", Host: "127.0.0.1:1", Path: "/oauth2/token"}
+	var stdout, stderr bytes.Buffer
+	if code := runCLISearch(twitch, Command{Broadcaster: "streamer"}, "table", &stdout, &stderr); code != exitNetwork {
+		t.Errorf("Expected exit code %d for a network error, got %d: %s", exitNetwork, code, stderr.String())
+	}
+}
+
+func TestRunCLIUsage(t *testing.T) {
+	t.Cleanup(func() { Log.Configure(os.Stderr, LevelInfo, "logfmt") })
+	getenv := func(name string) string {
+		return map[string]string{"CLIPS_CLIENT_ID": "client-id", "CLIPS_CLIENT_SECRET": "client-secret"}[name]
+	}
+
+	tests := []struct {
+		args   []string
+		getenv func(string) string
+	}{
+		{[]string{"streamer"}, func(string) string { return "" }},
+		{[]string{}, getenv},
+		{[]string{"-format", "xml", "streamer"}, getenv},
+		{[]string{"streamer", "-from", "yesterday"}, getenv},
+		{[]string{"-no-such-flag", "streamer"}, getenv},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := runCLI("search", test.args, &stdout, &stderr, test.getenv); code != exitUsage {
+			t.Errorf("Expected exit code %d for %q, got %d", exitUsage, test.args, code)
+		}
+		if stderr.Len() == 0 {
+			t.Errorf("Expected an error message for %q", test.args)
+		}
+	}
+}
+
+func TestWriteClips(t *testing.T) {
+	clips := []Clip{{ID: "a", Title: "Tabs\tand\nnewlines", CreatorName: "alice", ViewCount: 10, URL: "https://clips.twitch.tv/a", StartedAt: time.Now()}}
+
+	var table bytes.Buffer
+	if err := WriteClips(&table, "table", clips); err != nil {
+		t.Fatalf("Got an error from WriteClips: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "Tabs and newlines") {
+		t.Errorf("Unexpected table %q", table.String())
+	}
+
+	var jsonLines bytes.Buffer
+	WriteClips(&jsonLines, "json", clips)
+	if strings.Contains(jsonLines.String(), "StartedAt") || !strings.Contains(jsonLines.String(), `"view_count":10`) {
+		t.Errorf("Unexpected JSON %q", jsonLines.String())
+	}
+
+	var csvLines bytes.Buffer
+	WriteClips(&csvLines, "csv", clips)
+	if !strings.HasPrefix(csvLines.String(), strings.Join(clipColumns, ",")+"\n") {
+		t.Errorf("Expected a CSV header, got %q", csvLines.String())
+	}
+
+	if err := WriteClips(&table, "xml", clips); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"log"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,24 +10,109 @@ import (
 
 // Command represents a clips bot command
 type Command struct {
+	Action      string
+	Args        []string
 	Broadcaster string
+	Channels    []string
+	ClipID      string
 	Creator     string
+	Options     map[string]string
+	Roles       []string
 	StartedAt   time.Time
 	SubCommand  string
 	EndedAt     time.Time
 	Title       string
 	Top         int
+	VideoID     string
+}
+
+// Name returns the name of the command's subcommand, "search" for the main search
+func (c Command) Name() string {
+	if c.SubCommand == "" {
+		return "search"
+	}
+	return c.SubCommand
+}
+
+// subCommands are the subcommands that take no value of their own
+var subCommands = map[string]bool{
+	"help":    true,
+	"stats":   true,
+	"watch":   true,
+	"unwatch": true,
+	"watches": true,
+	"digest":  true,
+	"live":    true,
+}
+
+// rawSubCommands are the subcommands that get their arguments unparsed
+var rawSubCommands = map[string]bool{
+	"config":      true,
+	"permissions": true,
+}
+
+// subCommandActions are the actions that can follow a subcommand, like "add" in "!clips digest add"
+var subCommandActions = map[string]map[string]bool{
+	"digest": {"add": true, "list": true, "remove": true},
+	"live":   {"add": true, "list": true, "remove": true},
+}
+
+// optionNames are the names of the options that can be passed as name:value
+var optionNames = map[string]bool{
+	"min-views": true,
+	"every":     true,
+	"day":       true,
+	"at":        true,
+	"tz":        true,
+	"top":       true,
+	"export":    true,
 }
 
 // ParseCommand parses a Discord message string to a Command
 func ParseCommand(args string) (Command, error) {
-	if !strings.HasPrefix(args, "!clips") {
-		return Command{}, errors.New("command: invalid must start with \"!clips\"")
+	return ParseGuildCommand(args, DefaultGuildConfig())
+}
+
+// ParseGuildCommand parses a Discord message string to a Command using the prefix and defaults of a guild
+func ParseGuildCommand(args string, config GuildConfig) (Command, error) {
+	if !strings.HasPrefix(args, config.Prefix) {
+		return Command{}, errors.New("command: invalid must start with \"" + config.Prefix + "\"")
 	}
 	command := Command{}
-	args = removeSubStrings(args, []string{"!clips"})
+	args = removeSubStrings(args, []string{config.Prefix})
+
+	if fields := strings.Fields(args); len(fields) > 0 && rawSubCommands[fields[0]] {
+		// Setting values like "30d" would be parsed as dates, so keep the raw arguments
+		command.SubCommand = fields[0]
+		if len(fields) > 1 {
+			command.Action = fields[1]
+		}
+		if len(fields) > 2 {
+			command.Args = fields[2:]
+		}
+		return command, nil
+	}
+
+	if fields := strings.Fields(args); len(fields) > 0 && fields[0] == "clip" {
+		// Clip slugs may look like dates or titles, so skip the rest of the parsing
+		command.SubCommand = "clip"
+		if len(fields) > 1 {
+			command.ClipID = ParseClipID(fields[1])
+		}
+		return command, nil
+	}
+	if fields := strings.Fields(args); len(fields) > 0 && fields[0] == "vod" {
+		command.SubCommand = "vod"
+		if len(fields) > 1 {
+			command.VideoID = ParseVideoID(fields[1])
+		}
+		return command, nil
+	}
 
-	start, end, stringDates := parseDates(args)
+	start, end, stringDates, err := parseDates(args)
+	if err != nil {
+		return Command{}, err
+	}
 	if len(stringDates) > 0 {
 		args = removeSubStrings(args, stringDates)
 		command.StartedAt = start
@@ -37,7 +121,10 @@ func ParseCommand(args string) (Command, error) {
 		}
 	}
 
-	start, end, simpleStringDate := parseSimpleDate(args)
+	start, end, simpleStringDate, err := parseSimpleDate(args)
+	if err != nil {
+		return Command{}, err
+	}
 	if simpleStringDate != "" {
 		args = removeSubStrings(args, []string{simpleStringDate})
 		command.StartedAt = start
@@ -50,22 +137,28 @@ func ParseCommand(args string) (Command, error) {
 		command.Title = title[1]
 	}
 
-	words := strings.Fields(args)
-	log.Printf("Parsing args: %s", words)
+	words, options := parseOptions(strings.Fields(args))
+	command.Options = options
+	words, command.Roles, command.Channels = parseMentions(words)
+	Log.Debug("Parsing args", "words", strings.Join(words, " "))
 	if len(words) > 0 {
 		switch potentialSubCommand := words[0]; {
-		case potentialSubCommand == "help":
+		case subCommands[potentialSubCommand]:
 			command.SubCommand = potentialSubCommand
 			words = words[1:]
+			if len(words) > 0 && subCommandActions[potentialSubCommand][words[0]] {
+				command.Action = words[0]
+				words = words[1:]
+			}
 		case strings.HasPrefix(potentialSubCommand, "top"):
 			command.SubCommand = "top"
 			n := potentialSubCommand[3:]
 			if n == "" {
-				command.Top = 10 // Default is top 10
+				command.Top = config.Top
 			} else {
 				top, err := strconv.Atoi(n)
-				if err != nil {
-					log.Printf("strconv error, : %s", err)
+				if err != nil || top < 1 {
+					return Command{}, errors.New("command: top must be followed by a number of clips, like \"top5\"")
 				}
 
 				command.Top = top
@@ -87,6 +180,85 @@ func ParseCommand(args string) (Command, error) {
 	return command, nil
 }
 
+var clipLinkRegex = regexp.MustCompile(`(?:https?://)?(?:www\.|m\.)?(?:clips\.twitch\.tv/(?:embed\?clip=)?|twitch\.tv/[A-Za-z0-9_]+/clip/)([A-Za-z0-9_-]+)`)
+var clipSlugRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseClipID extracts a clip ID from a Twitch clip URL or a bare clip slug
+func ParseClipID(arg string) string {
+	if matched := clipLinkRegex.FindStringSubmatch(arg); len(matched) > 0 {
+		return matched[1]
+	}
+	if clipSlugRegex.MatchString(arg) {
+		return arg
+	}
+	return ""
+}
+
+var videoLinkRegex = regexp.MustCompile(`twitch\.tv/videos/(\d+)`)
+var videoIDRegex = regexp.MustCompile(`^\d+$`)
+
+// ParseVideoID extracts a video ID from a Twitch video URL or a bare video ID
+func ParseVideoID(arg string) string {
+	if matched := videoLinkRegex.FindStringSubmatch(arg); len(matched) > 0 {
+		return matched[1]
+	}
+	if videoIDRegex.MatchString(arg) {
+		return arg
+	}
+	return ""
+}
+
+// FindClipLinks returns the IDs of all Twitch clips linked in a message
+func FindClipLinks(content string) []string {
+	var clipIDs []string
+	for _, matched := range clipLinkRegex.FindAllStringSubmatch(content, -1) {
+		clipIDs = append(clipIDs, matched[1])
+	}
+	return clipIDs
+}
+
+// IntOption returns the value of the option called name as an int, or defaultValue if it wasn't passed
+func (c Command) IntOption(name string, defaultValue int) (int, error) {
+	value, ok := c.Options[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseOptions splits words into the name:value options they contain and the remaining words
+func parseOptions(words []string) ([]string, map[string]string) {
+	options := make(map[string]string)
+	var remaining []string
+	for _, word := range words {
+		separator := strings.Index(word, ":")
+		if separator > 0 && optionNames[word[:separator]] {
+			options[word[:separator]] = word[separator+1:]
+		} else {
+			remaining = append(remaining, word)
+		}
+	}
+	return remaining, options
+}
+
+var roleMentionRegex = regexp.MustCompile(`^<@&(\d+)>$`)
+var channelMentionRegex = regexp.MustCompile(`^<#(\d+)>$`)
+
+// parseMentions splits words into the IDs of the Discord roles and channels they mention and the remaining words
+func parseMentions(words []string) ([]string, []string, []string) {
+	var remaining, roles, channels []string
+	for _, word := range words {
+		if matched := roleMentionRegex.FindStringSubmatch(word); len(matched) > 0 {
+			roles = append(roles, matched[1])
+		} else if matched := channelMentionRegex.FindStringSubmatch(word); len(matched) > 0 {
+			channels = append(channels, matched[1])
+		} else {
+			remaining = append(remaining, word)
+		}
+	}
+	return remaining, roles, channels
+}
+
 func removeSubStrings(target string, toRemove []string) string {
 	for _, remove := range toRemove {
 		start := strings.Index(target, remove)
@@ -96,58 +268,63 @@ func removeSubStrings(target string, toRemove []string) string {
 	return target
 }
 
-func parseDates(args string) (time.Time, time.Time, []string) {
-	regex, err := regexp.Compile(`[12][0-9]{3}-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])`)
-	if err != nil {
-		log.Fatal("regex error: ", err)
-	}
-	matched := regex.FindAllString(args, 2)
+// maxSimpleDateYears bounds how many years back simple dates like "30d" go. Twitch clips don't go back that far,
+// and larger numbers would overflow.
+const maxSimpleDateYears = 10
+
+var dateRegex = regexp.MustCompile(`[12][0-9]{3}-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])`)
+var simpleDateRegex = regexp.MustCompile(`(?P<Number>\d+)(?P<Unit>d|m|y)`)
+
+func parseDates(args string) (time.Time, time.Time, []string, error) {
+	matched := dateRegex.FindAllString(args, 2)
 	if len(matched) == 0 {
-		return time.Time{}, time.Time{}, []string{}
+		return time.Time{}, time.Time{}, []string{}, nil
 	}
 
 	start, err := time.Parse("2006-01-02", matched[0])
 	if err != nil {
-		log.Fatal("time error: ", err)
+		return time.Time{}, time.Time{}, nil, errors.New("command: " + matched[0] + " isn't a valid date")
 	}
 
 	end := time.Time{}
 	if len(matched) > 1 {
 		end, err = time.Parse("2006-01-02", matched[1])
 		if err != nil {
-			log.Fatal("time error: ", err)
+			return time.Time{}, time.Time{}, nil, errors.New("command: " + matched[1] + " isn't a valid date")
 		}
 	}
 
-	return start, end, matched
+	return start, end, matched, nil
 }
 
-func parseSimpleDate(args string) (time.Time, time.Time, string) {
-	regex, err := regexp.Compile(`(?P<Number>\d+)(?P<Unit>d|m|y)`)
-	if err != nil {
-		log.Fatal("regex error: ", err)
-	}
-	matched := regex.FindStringSubmatch(args)
+func parseSimpleDate(args string) (time.Time, time.Time, string, error) {
+	matched := simpleDateRegex.FindStringSubmatch(args)
 	if len(matched) == 0 {
-		return time.Time{}, time.Time{}, ""
+		return time.Time{}, time.Time{}, "", nil
 	}
 
 	now := time.Now()
 	currentDate := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, now.Location()) // Twitch ignores everything after minute
+	tooFar := errors.New("command: " + matched[0] + " goes back too far, dates can go back up to " + strconv.Itoa(maxSimpleDateYears) + " years")
+	// Bound the number before adding it, as adding enough years wraps around
 	value, err := strconv.Atoi(matched[1])
-	if err != nil {
-		log.Fatal("strconv error: ", err)
+	if err != nil || value > maxSimpleDateYears*366 {
+		return time.Time{}, time.Time{}, "", tooFar
 	}
 
+	var start time.Time
 	switch matched[2] {
 	case "d":
-		return currentDate.AddDate(0, 0, -value), currentDate, matched[0]
+		start = currentDate.AddDate(0, 0, -value)
 	case "m":
-		return currentDate.AddDate(0, -value, 0), currentDate, matched[0]
+		start = currentDate.AddDate(0, -value, 0)
 	case "y":
-		return currentDate.AddDate(-value, 0, 0), currentDate, matched[0]
+		start = currentDate.AddDate(-value, 0, 0)
+	}
+	if start.Before(currentDate.AddDate(-maxSimpleDateYears, 0, 0)) {
+		return time.Time{}, time.Time{}, "", tooFar
 	}
-	return time.Time{}, time.Time{}, ""
+	return start, currentDate, matched[0], nil
 }
 
 func splitQuote(r rune) bool {
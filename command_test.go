@@ -158,6 +158,14 @@ func TestParseCommandSubCommandTop(t *testing.T) {
 	}
 }
 
+func TestParseCommandSubCommandTopInvalid(t *testing.T) {
+	for _, inputCommand := range []string{"!clips top-5 Streamer", "!clips top0 Streamer", "!clips topfive Streamer"} {
+		if result, err := ParseCommand(inputCommand); err == nil {
+			t.Errorf("Should have returned an error for %q, got: %+v", inputCommand, result)
+		}
+	}
+}
+
 func TestParseInvalidCommand(t *testing.T) {
 	inputCommand := "This is not a valid clips command even if !clips is in it"
 	result, err := ParseCommand(inputCommand)
@@ -166,6 +174,24 @@ func TestParseInvalidCommand(t *testing.T) {
 	}
 }
 
+func TestParseCommandInvalidDates(t *testing.T) {
+	for _, inputCommand := range []string{
+		"!clips Streamer 2020-02-31",
+		"!clips Streamer 2020-01-01 2020-02-30",
+		"!clips Streamer 99999999999999999999d",
+		"!clips Streamer 11y",
+		"!clips Streamer 3700d",
+	} {
+		if result, err := ParseCommand(inputCommand); err == nil {
+			t.Errorf("Should have returned an error for %q, got: %+v", inputCommand, result)
+		}
+	}
+
+	if _, err := ParseCommand("!clips Streamer 10y"); err != nil {
+		t.Errorf("Dates up to 10 years back should parse, got %s", err)
+	}
+}
+
 func TestParseCommandOnlyTitle(t *testing.T) {
 	inputCommand := "!clips Streamer \"Super funny clip!\""
 	result, err := ParseCommand(inputCommand)
@@ -315,3 +341,229 @@ func TestParseCommandSimpleYear(t *testing.T) {
 		t.Errorf("EndedAt not properly parsed: expected \"%s\" got %s", currentDate, result.EndedAt)
 	}
 }
+
+func TestParseCommandSubCommandStats(t *testing.T) {
+	inputCommand := "!clips stats Streamer 1m"
+	result, err := ParseCommand(inputCommand)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.Broadcaster != "Streamer" {
+		t.Errorf("Broadcaster not properly parsed: expected \"Streamer\" got %s", result.Broadcaster)
+	}
+	if result.SubCommand != "stats" {
+		t.Errorf("SubCommand not properly parsed: expected \"stats\" got %s", result.SubCommand)
+	}
+	now := time.Now()
+	currentDate := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, now.Location())
+	if result.StartedAt != currentDate.AddDate(0, -1, 0) {
+		t.Errorf("StartedAt not properly parsed: expected \"%s\" got %s", currentDate.AddDate(0, -1, 0), result.StartedAt)
+	}
+}
+
+func TestParseCommandSubCommandClip(t *testing.T) {
+	inputCommand := "!clips clip https://clips.twitch.tv/FunnyClip-2020d"
+	result, err := ParseCommand(inputCommand)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "clip" {
+		t.Errorf("SubCommand not properly parsed: expected \"clip\" got %s", result.SubCommand)
+	}
+	if result.ClipID != "FunnyClip-2020d" {
+		t.Errorf("ClipID not properly parsed: expected \"FunnyClip-2020d\" got %s", result.ClipID)
+	}
+	if !result.StartedAt.IsZero() {
+		t.Errorf("StartedAt not properly parsed: expected \"%s\" got %s", time.Time{}, result.StartedAt)
+	}
+}
+
+func TestParseClipID(t *testing.T) {
+	inputs := map[string]string{
+		"https://clips.twitch.tv/FunnyClip":                      "FunnyClip",
+		"clips.twitch.tv/FunnyClip?tt_medium=clips_api":          "FunnyClip",
+		"https://clips.twitch.tv/embed?clip=FunnyClip":           "FunnyClip",
+		"https://www.twitch.tv/streamer/clip/FunnyClip-a1B2_c3D": "FunnyClip-a1B2_c3D",
+		"twitch.tv/streamer/clip/FunnyClip":                      "FunnyClip",
+		"FunnyClip":                                              "FunnyClip",
+		"https://www.twitch.tv/streamer":                         "",
+	}
+
+	for input, expected := range inputs {
+		if clipID := ParseClipID(input); clipID != expected {
+			t.Errorf("Clip ID not properly parsed from %s: expected \"%s\" got %s", input, expected, clipID)
+		}
+	}
+}
+
+func TestFindClipLinks(t *testing.T) {
+	content := "lol https://clips.twitch.tv/FunnyClip and twitch.tv/streamer/clip/OtherClip also https://www.twitch.tv/streamer"
+	clipIDs := FindClipLinks(content)
+
+	if len(clipIDs) != 2 || clipIDs[0] != "FunnyClip" || clipIDs[1] != "OtherClip" {
+		t.Errorf("Clip links not properly found: expected [FunnyClip OtherClip] got %v", clipIDs)
+	}
+	if clipIDs := FindClipLinks("!clips top5 Streamer"); len(clipIDs) != 0 {
+		t.Errorf("Clip links found in message without links: %v", clipIDs)
+	}
+}
+
+func TestParseCommandSubCommandVod(t *testing.T) {
+	inputCommand := "!clips vod https://www.twitch.tv/videos/123456789"
+	result, err := ParseCommand(inputCommand)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "vod" {
+		t.Errorf("SubCommand not properly parsed: expected \"vod\" got %s", result.SubCommand)
+	}
+	if result.VideoID != "123456789" {
+		t.Errorf("VideoID not properly parsed: expected \"123456789\" got %s", result.VideoID)
+	}
+}
+
+func TestParseVideoID(t *testing.T) {
+	inputs := map[string]string{
+		"https://www.twitch.tv/videos/123456789":      "123456789",
+		"twitch.tv/videos/123456789?t=1h2m3s":         "123456789",
+		"123456789":                                   "123456789",
+		"https://www.twitch.tv/streamer/clip/NotAVod": "",
+	}
+
+	for input, expected := range inputs {
+		if videoID := ParseVideoID(input); videoID != expected {
+			t.Errorf("Video ID not properly parsed from %s: expected \"%s\" got %s", input, expected, videoID)
+		}
+	}
+}
+
+func TestParseCommandOptions(t *testing.T) {
+	inputCommand := "!clips watch Streamer min-views:100"
+	result, err := ParseCommand(inputCommand)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "watch" {
+		t.Errorf("SubCommand not properly parsed: expected \"watch\" got %s", result.SubCommand)
+	}
+	if result.Broadcaster != "Streamer" {
+		t.Errorf("Broadcaster not properly parsed: expected \"Streamer\" got %s", result.Broadcaster)
+	}
+	if result.Creator != "" {
+		t.Errorf("Creator not properly parsed: expected \"\" got %s", result.Creator)
+	}
+	minViews, err := result.IntOption("min-views", 0)
+	if err != nil || minViews != 100 {
+		t.Errorf("min-views option not properly parsed: expected 100 got %d", minViews)
+	}
+	if top, _ := result.IntOption("top", 10); top != 10 {
+		t.Errorf("Missing option should return the default: expected 10 got %d", top)
+	}
+}
+
+func TestParseCommandExportOption(t *testing.T) {
+	result, err := ParseCommand("!clips top Streamer \"funny\" 2020-01-01 export:csv")
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "top" || result.Broadcaster != "Streamer" || result.Title != "funny" {
+		t.Errorf("Command not properly parsed: got %+v", result)
+	}
+	if result.Options["export"] != "csv" {
+		t.Errorf("export option not properly parsed: expected \"csv\" got %s", result.Options["export"])
+	}
+}
+
+func TestParseCommandSubCommandAction(t *testing.T) {
+	inputCommand := "!clips digest add Streamer every:month day:1 at:18:00 tz:Europe/Madrid top:5"
+	result, err := ParseCommand(inputCommand)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "digest" {
+		t.Errorf("SubCommand not properly parsed: expected \"digest\" got %s", result.SubCommand)
+	}
+	if result.Action != "add" {
+		t.Errorf("Action not properly parsed: expected \"add\" got %s", result.Action)
+	}
+	if result.Broadcaster != "Streamer" {
+		t.Errorf("Broadcaster not properly parsed: expected \"Streamer\" got %s", result.Broadcaster)
+	}
+	if result.Options["at"] != "18:00" || result.Options["tz"] != "Europe/Madrid" || result.Options["top"] != "5" {
+		t.Errorf("Options not properly parsed: got %v", result.Options)
+	}
+	if !result.StartedAt.IsZero() {
+		t.Errorf("StartedAt not properly parsed: expected \"%s\" got %s", time.Time{}, result.StartedAt)
+	}
+}
+
+func TestParseCommandMentions(t *testing.T) {
+	inputCommand := "!clips live add Streamer <@&1234> <#5678>"
+	result, err := ParseCommand(inputCommand)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "live" || result.Action != "add" {
+		t.Errorf("SubCommand not properly parsed: expected \"live add\" got %s %s", result.SubCommand, result.Action)
+	}
+	if result.Broadcaster != "Streamer" {
+		t.Errorf("Broadcaster not properly parsed: expected \"Streamer\" got %s", result.Broadcaster)
+	}
+	if result.Creator != "" {
+		t.Errorf("Creator not properly parsed: expected \"\" got %s", result.Creator)
+	}
+	if len(result.Roles) != 1 || result.Roles[0] != "1234" {
+		t.Errorf("Roles not properly parsed: expected [1234] got %v", result.Roles)
+	}
+	if len(result.Channels) != 1 || result.Channels[0] != "5678" {
+		t.Errorf("Channels not properly parsed: expected [5678] got %v", result.Channels)
+	}
+}
+
+func TestParseGuildCommand(t *testing.T) {
+	config := DefaultGuildConfig()
+	config.Prefix = "?c"
+	config.Top = 3
+
+	result, err := ParseGuildCommand("?c top Streamer", config)
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+	if result.Top != 3 {
+		t.Errorf("Top not properly parsed: expected 3 got %d", result.Top)
+	}
+	if result.Broadcaster != "Streamer" {
+		t.Errorf("Broadcaster not properly parsed: expected \"Streamer\" got %s", result.Broadcaster)
+	}
+
+	if _, err := ParseGuildCommand("!clips top Streamer", config); err == nil {
+		t.Errorf("Commands with another prefix should be invalid")
+	}
+}
+
+func TestParseCommandSubCommandConfig(t *testing.T) {
+	result, err := ParseCommand("!clips config set period 30d")
+	if err != nil {
+		t.Errorf("Got an error while parsing test command: %s", err)
+	}
+
+	if result.SubCommand != "config" {
+		t.Errorf("SubCommand not properly parsed: expected \"config\" got %s", result.SubCommand)
+	}
+	if result.Action != "set" {
+		t.Errorf("Action not properly parsed: expected \"set\" got %s", result.Action)
+	}
+	if len(result.Args) != 2 || result.Args[0] != "period" || result.Args[1] != "30d" {
+		t.Errorf("Args not properly parsed: expected [period 30d] got %v", result.Args)
+	}
+	if !result.StartedAt.IsZero() {
+		t.Errorf("StartedAt not properly parsed: expected \"%s\" got %s", time.Time{}, result.StartedAt)
+	}
+}
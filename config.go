@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configEnvPrefix prefixes the environment variables that set the bot's flags, like CLIPS_CLIENT_ID for -client-id
+const configEnvPrefix = "CLIPS_"
+
+// shortFlags are the one letter flags kept from before the long ones, mapped to the flag they're short for
+var shortFlags = map[string]string{
+	"t": "token",
+	"c": "client-id",
+	"s": "client-secret",
+	"d": "data-dir",
+}
+
+// loadConfig fills in the flags of fs that weren't set on the command line, from a CLIPS_<NAME> environment
+// variable or else from the config file named by the config flag. Any flag can also be read from a file named
+// by CLIPS_<NAME>_FILE or by a <name>-file key in the config file, like Docker and Kubernetes mount secrets.
+func loadConfig(fs *flag.FlagSet, getenv func(string) string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		if long, ok := shortFlags[f.Name]; ok {
+			set[long] = true
+		}
+		set[f.Name] = true
+	})
+
+	sources := []func(name string) (string, bool, error){
+		func(name string) (string, bool, error) {
+			value := getenv(configEnvName(name))
+			return value, value != "", nil
+		},
+		func(name string) (string, bool, error) {
+			if path := getenv(configEnvName(name) + "_FILE"); path != "" {
+				value, err := readSecretFile(path)
+				return value, true, err
+			}
+			return "", false, nil
+		},
+	}
+
+	if configFlag := fs.Lookup("config"); configFlag != nil {
+		path := configFlag.Value.String()
+		if !set["config"] && getenv(configEnvName("config")) != "" {
+			path = getenv(configEnvName("config"))
+		}
+		if path != "" {
+			values, err := readConfigFile(path)
+			if err != nil {
+				return err
+			}
+			for key := range values {
+				name := strings.TrimSuffix(key, "-file")
+				if fs.Lookup(name) == nil || shortFlags[name] != "" || name == "config" {
+					return errors.New("config: unknown setting \"" + key + "\" in " + path)
+				}
+			}
+			sources = append(sources,
+				func(name string) (string, bool, error) {
+					value, ok := values[name]
+					return value, ok, nil
+				},
+				func(name string) (string, bool, error) {
+					if path, ok := values[name+"-file"]; ok {
+						value, err := readSecretFile(path)
+						return value, true, err
+					}
+					return "", false, nil
+				},
+			)
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || shortFlags[f.Name] != "" || f.Name == "config" {
+			return
+		}
+		for _, source := range sources {
+			value, ok, sourceErr := source(f.Name)
+			if sourceErr != nil {
+				err = sourceErr
+				return
+			}
+			if !ok {
+				continue
+			}
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = errors.New("config: invalid value for " + f.Name + ": " + setErr.Error())
+			}
+			return
+		}
+	})
+	return err
+}
+
+// configEnvName returns the environment variable for a flag, like CLIPS_CLIENT_ID for client-id
+func configEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// readSecretFile reads a secret mounted as a file, ignoring the trailing newline most tools write
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.New("config: couldn't read secret file: " + err.Error())
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readConfigFile reads a config file of "name = value" lines, one per flag. It isn't TOML or YAML: there are no
+// sections, lists or nested values. Values can be double quoted with Go's escapes, and lines starting with # are
+// comments.
+func readConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("config: couldn't open config file: " + err.Error())
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator < 1 {
+			return nil, errors.New("config: " + path + " line " + strconv.Itoa(n) + ": expected name = value")
+		}
+		key, value := strings.TrimSpace(line[:separator]), strings.TrimSpace(line[separator+1:])
+		if strings.HasPrefix(value, "\"") {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, errors.New("config: " + path + " line " + strconv.Itoa(n) + ": invalid quoted value")
+			}
+			value = unquoted
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// validateConfig checks the settings the bot can't run without, before connecting anywhere
+func validateConfig() error {
+	var missing []string
+	if Token == "" {
+		missing = append(missing, "token")
+	}
+	if ClientID == "" {
+		missing = append(missing, "client-id")
+	}
+	if ClientSecret == "" {
+		missing = append(missing, "client-secret")
+	}
+	if len(missing) > 0 {
+		return errors.New("config: missing " + strings.Join(missing, ", ") + ", set them with flags, " + configEnvPrefix + "* environment variables or a config file")
+	}
+
+	if EventSubCallback != "" && (HTTPAddr == "" || len(EventSubSecret) < 10) {
+		return errors.New("config: EventSub requires the -http server and an -eventsub-secret of at least 10 characters")
+	}
+	if APIKeys != "" && HTTPAddr == "" {
+		return errors.New("config: the API keys require the -http server")
+	}
+	if Workers < 1 || QueueSize < 1 || GuildQueueSize < 1 {
+		return errors.New("config: workers, queue-size and guild-queue-size must be at least 1")
+	}
+	if _, err := ParseLevel(LogLevel); err != nil {
+		return err
+	}
+	if LogFormat != "logfmt" && LogFormat != "json" {
+		return errors.New("config: log-format must be logfmt or json")
+	}
+	if UserBudget < 0 || GuildBudget < 0 || APIBudget < 0 || FeedBudget < 0 {
+		return errors.New("config: user-budget, guild-budget, api-budget and feed-budget can't be negative")
+	}
+	if ShardCount < 0 {
+		return errors.New("config: shard-count can't be negative")
+	}
+	if ShardIDs != "" && ShardCount == 0 {
+		return errors.New("config: shard-ids requires a shard-count, so every process agrees on it")
+	}
+	if ShutdownGrace < 0 {
+		return errors.New("config: shutdown-grace can't be negative")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testFlagSet() (*flag.FlagSet, map[string]*string, *time.Duration) {
+	fs := flag.NewFlagSet("clips", flag.ContinueOnError)
+	values := map[string]*string{
+		"config":        fs.String("config", "", ""),
+		"token":         new(string),
+		"client-id":     fs.String("client-id", "", ""),
+		"client-secret": fs.String("client-secret", "", ""),
+		"http":          fs.String("http", "", ""),
+	}
+	fs.StringVar(values["token"], "token", "", "")
+	fs.StringVar(values["token"], "t", "", "")
+	cooldown := fs.Duration("user-cooldown", 5*time.Second, "")
+	return fs, values, cooldown
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-config")
+	defer os.RemoveAll(dir)
+
+	secretPath := filepath.Join(dir, "client-secret")
+	ioutil.WriteFile(secretPath, []byte("file-secret\n"), 0600)
+	configPath := filepath.Join(dir, "clips.conf")
+	ioutil.WriteFile(configPath, []byte(`# Settings of the bot
+token = "file-token"
+client-id = file-client-id
+client-secret-file = "`+secretPath+`"
+http = ":8080"
+user-cooldown = 10s
+`), 0600)
+
+	fs, values, cooldown := testFlagSet()
+	fs.Parse([]string{"-t", "flag-token"})
+	env := map[string]string{
+		"CLIPS_CONFIG":    configPath,
+		"CLIPS_CLIENT_ID": "env-client-id",
+		"CLIPS_HTTP":      "",
+	}
+	if err := loadConfig(fs, func(name string) string { return env[name] }); err != nil {
+		t.Fatalf("Got an error loading the config: %s", err)
+	}
+
+	expected := map[string]string{
+		"token":         "flag-token",
+		"client-id":     "env-client-id",
+		"client-secret": "file-secret",
+		"http":          ":8080",
+	}
+	for name, value := range expected {
+		if *values[name] != value {
+			t.Errorf("%s not properly loaded: expected %s got %s", name, value, *values[name])
+		}
+	}
+	if *cooldown != 10*time.Second {
+		t.Errorf("user-cooldown not properly loaded: expected 10s got %s", *cooldown)
+	}
+}
+
+func TestLoadConfigSecretFileEnv(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-config")
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "token")
+	ioutil.WriteFile(tokenPath, []byte("secret-token\n"), 0600)
+
+	fs, values, _ := testFlagSet()
+	fs.Parse(nil)
+	env := map[string]string{"CLIPS_TOKEN_FILE": tokenPath}
+	if err := loadConfig(fs, func(name string) string { return env[name] }); err != nil {
+		t.Fatalf("Got an error loading the config: %s", err)
+	}
+	if *values["token"] != "secret-token" {
+		t.Errorf("token not properly loaded from its secret file: expected secret-token got %s", *values["token"])
+	}
+
+	fs, _, _ = testFlagSet()
+	fs.Parse(nil)
+	env["CLIPS_TOKEN_FILE"] = filepath.Join(dir, "missing")
+	if err := loadConfig(fs, func(name string) string { return env[name] }); err == nil {
+		t.Errorf("A missing secret file should be an error")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-config")
+	defer os.RemoveAll(dir)
+
+	configs := map[string]string{
+		"unknown setting": "tokn = typo\n",
+		"short flag":      "t = token\n",
+		"invalid line":    "token\n",
+		"invalid value":   "user-cooldown = forever\n",
+	}
+	for name, content := range configs {
+		configPath := filepath.Join(dir, "clips.conf")
+		ioutil.WriteFile(configPath, []byte(content), 0600)
+
+		fs, _, _ := testFlagSet()
+		fs.Parse([]string{"-config", configPath})
+		if err := loadConfig(fs, func(string) string { return "" }); err == nil {
+			t.Errorf("Loading a config with an %s should fail", name)
+		}
+	}
+}
+
+func TestValidateConfigLogging(t *testing.T) {
+	defer func(level string, format string) { LogLevel, LogFormat = level, format }(LogLevel, LogFormat)
+	defer func(token string, clientID string, clientSecret string) {
+		Token, ClientID, ClientSecret = token, clientID, clientSecret
+	}(Token, ClientID, ClientSecret)
+	defer func(workers int, queueSize int, guildQueueSize int) {
+		Workers, QueueSize, GuildQueueSize = workers, queueSize, guildQueueSize
+	}(Workers, QueueSize, GuildQueueSize)
+	Token, ClientID, ClientSecret = "token", "client-id", "client-secret"
+	Workers, QueueSize, GuildQueueSize = 1, 1, 1
+
+	LogLevel, LogFormat = "debug", "json"
+	if err := validateConfig(); err != nil {
+		t.Errorf("Expected a valid config, got %s", err)
+	}
+	LogLevel, LogFormat = "verbose", "json"
+	if err := validateConfig(); err == nil {
+		t.Errorf("Expected an unknown log level to be rejected")
+	}
+	LogLevel, LogFormat = "info", "text"
+	if err := validateConfig(); err == nil {
+		t.Errorf("Expected an unknown log format to be rejected")
+	}
+}
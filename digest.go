@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestInterval is how often digests are checked for being due
+const digestInterval = time.Minute
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Schedule is a cron-like recurring time, either a day of the week or a day of the month at a time of day
+type Schedule struct {
+	Every    string       `json:"every"`
+	Weekday  time.Weekday `json:"weekday"`
+	MonthDay int          `json:"month_day"`
+	Hour     int          `json:"hour"`
+	Minute   int          `json:"minute"`
+	Timezone string       `json:"timezone"`
+}
+
+// ParseSchedule builds a Schedule from the every, day, at and tz command options.
+// It defaults to every Monday at 00:00 in defaultTimezone.
+func ParseSchedule(options map[string]string, defaultTimezone string) (Schedule, error) {
+	schedule := Schedule{Every: "week", Weekday: time.Monday, MonthDay: 1, Timezone: defaultTimezone}
+
+	if every, ok := options["every"]; ok {
+		if every != "week" && every != "month" {
+			return Schedule{}, errors.New("digest: every must be \"week\" or \"month\"")
+		}
+		schedule.Every = every
+	}
+
+	if day, ok := options["day"]; ok {
+		if schedule.Every == "week" {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return Schedule{}, errors.New("digest: day must be a day of the week like \"mon\"")
+			}
+			schedule.Weekday = weekday
+		} else {
+			monthDay, err := strconv.Atoi(day)
+			if err != nil || monthDay < 1 || monthDay > 31 {
+				return Schedule{}, errors.New("digest: day must be a day of the month between 1 and 31")
+			}
+			schedule.MonthDay = monthDay
+		}
+	}
+
+	if at, ok := options["at"]; ok {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return Schedule{}, errors.New("digest: at must be a time of day like \"18:00\"")
+		}
+		schedule.Hour, schedule.Minute = t.Hour(), t.Minute()
+	}
+
+	if tz, ok := options["tz"]; ok {
+		schedule.Timezone = tz
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return Schedule{}, errors.New("digest: unknown timezone \"" + schedule.Timezone + "\"")
+	}
+
+	return schedule, nil
+}
+
+// Next returns the first time in the schedule strictly after after
+func (s Schedule) Next(after time.Time) time.Time {
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := after.In(location)
+
+	if s.Every == "month" {
+		for month := local.Month(); ; month++ {
+			next := time.Date(local.Year(), month, clampMonthDay(local.Year(), month, s.MonthDay), s.Hour, s.Minute, 0, 0, location)
+			if next.After(after) {
+				return next
+			}
+		}
+	}
+
+	days := (int(s.Weekday) - int(local.Weekday()) + 7) % 7
+	next := time.Date(local.Year(), local.Month(), local.Day()+days, s.Hour, s.Minute, 0, 0, location)
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// Period returns the window of clips covered by a digest posted at t
+func (s Schedule) Period(t time.Time) (time.Time, time.Time) {
+	if s.Every == "month" {
+		return t.AddDate(0, -1, 0), t
+	}
+	return t.AddDate(0, 0, -7), t
+}
+
+func (s Schedule) String() string {
+	at := time.Date(0, 1, 1, s.Hour, s.Minute, 0, 0, time.UTC).Format("15:04")
+	if s.Every == "month" {
+		return "every month on day " + strconv.Itoa(s.MonthDay) + " at " + at + " " + s.Timezone
+	}
+	return "every " + s.Weekday.String() + " at " + at + " " + s.Timezone
+}
+
+// clampMonthDay returns day, or the last day of the month if the month is shorter
+func clampMonthDay(year int, month time.Month, day int) int {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > lastDay {
+		return lastDay
+	}
+	return day
+}
+
+// Digest is a scheduled post of the top clips of a broadcaster in a Discord channel
+type Digest struct {
+	ID              string    `json:"id"`
+	GuildID         string    `json:"guild_id"`
+	ChannelID       string    `json:"channel_id"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name"`
+	Top             int       `json:"top"`
+	Schedule        Schedule  `json:"schedule"`
+	LastRun         time.Time `json:"last_run"`
+}
+
+// DigestStore persists digests along with when they last ran. It is safe for concurrent use.
+type DigestStore struct {
+	mu      sync.Mutex
+	path    string
+	NextID  int      `json:"next_id"`
+	Digests []Digest `json:"digests"`
+	owns    guildFilter
+	now     func() time.Time
+}
+
+// OpenDigestStore loads the digests stored in path
+func OpenDigestStore(path string) (*DigestStore, error) {
+	store := &DigestStore{path: path, NextID: 1, now: time.Now}
+	if err := loadJSON(path, store); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Add registers a digest, returning it with its assigned ID
+func (ds *DigestStore) Add(digest Digest) (Digest, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	digest.ID = strconv.Itoa(ds.NextID)
+	ds.NextID++
+	// Start counting from now, so a new digest isn't posted right away
+	digest.LastRun = ds.now()
+	ds.Digests = append(ds.Digests, digest)
+	return digest, ds.save()
+}
+
+// Remove unregisters a guild's digest, reporting whether there was one
+func (ds *DigestStore) Remove(guildID string, digestID string) (bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for i, d := range ds.Digests {
+		if d.GuildID == guildID && d.ID == digestID {
+			ds.Digests = append(ds.Digests[:i], ds.Digests[i+1:]...)
+			return true, ds.save()
+		}
+	}
+	return false, nil
+}
+
+// List returns the digests registered in a guild
+func (ds *DigestStore) List(guildID string) []Digest {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	var digests []Digest
+	for _, d := range ds.Digests {
+		if d.GuildID == guildID {
+			digests = append(digests, d)
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		idI, _ := strconv.Atoi(digests[i].ID)
+		idJ, _ := strconv.Atoi(digests[j].ID)
+		return idI < idJ
+	})
+	return digests
+}
+
+// GuildIDs returns the guilds with digests
+func (ds *DigestStore) GuildIDs() []string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	var guildIDs []string
+	for _, d := range ds.Digests {
+		guildIDs = append(guildIDs, d.GuildID)
+	}
+	return guildIDs
+}
+
+// BroadcasterIDs returns the broadcasters with digests in the guilds this process handles
+func (ds *DigestStore) BroadcasterIDs() []string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	var broadcasterIDs []string
+	for _, d := range ds.Digests {
+		if ds.owns.handles(d.GuildID) {
+			broadcasterIDs = append(broadcasterIDs, d.BroadcasterID)
+		}
+	}
+	return broadcasterIDs
+}
+
+// SetOwner limits RunDue to the digests of the guilds owns reports true for, like the ones whose shard runs in
+// this process
+func (ds *DigestStore) SetOwner(owns func(guildID string) bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.owns = owns
+}
+
+// RunDue posts every digest whose next scheduled time has passed. A digest missed while the bot was down is
+// posted once, and its run is saved before posting so a restart never posts it twice.
+func (ds *DigestStore) RunDue(t TwitchAPI, post func(Digest, []Clip) error) {
+	ds.mu.Lock()
+	now := ds.now()
+	var due []Digest
+	for i, d := range ds.Digests {
+		if ds.owns.handles(d.GuildID) && !d.Schedule.Next(d.LastRun).After(now) {
+			ds.Digests[i].LastRun = now
+			due = append(due, ds.Digests[i])
+		}
+	}
+	if len(due) > 0 {
+		if err := ds.save(); err != nil {
+			Log.Error("Couldn't save digests, skipping due digests", "err", err)
+			ds.mu.Unlock()
+			return
+		}
+	}
+	ds.mu.Unlock()
+
+	for _, d := range due {
+		startedAt, endedAt := d.Schedule.Period(now)
+		targetClip := Clip{BroadcasterID: d.BroadcasterID, StartedAt: startedAt, EndedAt: endedAt}
+		clips, err := t.FindMostPopularClips(targetClip, matchMany(), d.Top)
+		if err != nil {
+			Log.Warn("Couldn't walk clips for digest", "digest", d.ID, "guild", d.GuildID, "err", err)
+		}
+
+		if err := post(d, clips); err != nil {
+			Log.Error("Couldn't post digest", "digest", d.ID, "guild", d.GuildID, "channel", d.ChannelID, "err", err)
+		}
+	}
+}
+
+// Run checks for due digests each interval until stop is closed
+func (ds *DigestStore) Run(t TwitchAPI, interval time.Duration, post func(Digest, []Clip) error, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ds.RunDue(t, post)
+		}
+	}
+}
+
+func (ds *DigestStore) save() error {
+	return saveJSON(ds.path, ds)
+}
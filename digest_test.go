@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule(map[string]string{"every": "month", "day": "15", "at": "18:30", "tz": "America/New_York"}, "UTC")
+	if err != nil {
+		t.Errorf("Got an error while parsing schedule: %s", err)
+	}
+	expected := Schedule{Every: "month", Weekday: time.Monday, MonthDay: 15, Hour: 18, Minute: 30, Timezone: "America/New_York"}
+	if schedule != expected {
+		t.Errorf("Schedule not properly parsed: expected %v got %v", expected, schedule)
+	}
+
+	schedule, _ = ParseSchedule(map[string]string{"day": "fri"}, "Europe/Madrid")
+	expected = Schedule{Every: "week", Weekday: time.Friday, MonthDay: 1, Timezone: "Europe/Madrid"}
+	if schedule != expected {
+		t.Errorf("Schedule not properly parsed: expected %v got %v", expected, schedule)
+	}
+
+	invalid := []map[string]string{
+		{"every": "day"},
+		{"day": "someday"},
+		{"every": "month", "day": "32"},
+		{"at": "25:00"},
+		{"tz": "Mars/Olympus_Mons"},
+	}
+	for _, options := range invalid {
+		if _, err := ParseSchedule(options, "UTC"); err == nil {
+			t.Errorf("Should have returned an error for invalid schedule %v", options)
+		}
+	}
+}
+
+func TestScheduleNextWeekly(t *testing.T) {
+	schedule := Schedule{Every: "week", Weekday: time.Monday, Hour: 18, Timezone: "America/New_York"}
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	// Wednesday 2020-06-03
+	next := schedule.Next(time.Date(2020, 6, 3, 12, 0, 0, 0, newYork))
+	if expected := time.Date(2020, 6, 8, 18, 0, 0, 0, newYork); !next.Equal(expected) {
+		t.Errorf("Next weekly run not properly computed: expected %s got %s", expected, next)
+	}
+
+	next = schedule.Next(time.Date(2020, 6, 8, 18, 0, 0, 0, newYork))
+	if expected := time.Date(2020, 6, 15, 18, 0, 0, 0, newYork); !next.Equal(expected) {
+		t.Errorf("Next weekly run should be strictly after the given time: expected %s got %s", expected, next)
+	}
+
+	// Monday 2020-06-08 at 20:00 UTC is 16:00 in New York, before the scheduled time
+	next = schedule.Next(time.Date(2020, 6, 8, 20, 0, 0, 0, time.UTC))
+	if expected := time.Date(2020, 6, 8, 18, 0, 0, 0, newYork); !next.Equal(expected) {
+		t.Errorf("Next weekly run not computed in the schedule's timezone: expected %s got %s", expected, next)
+	}
+}
+
+func TestScheduleNextMonthly(t *testing.T) {
+	schedule := Schedule{Every: "month", MonthDay: 31, Hour: 9, Timezone: "UTC"}
+
+	next := schedule.Next(time.Date(2020, 1, 31, 10, 0, 0, 0, time.UTC))
+	if expected := time.Date(2020, 2, 29, 9, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Next monthly run not clamped to the end of the month: expected %s got %s", expected, next)
+	}
+
+	next = schedule.Next(time.Date(2020, 12, 31, 10, 0, 0, 0, time.UTC))
+	if expected := time.Date(2021, 1, 31, 9, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Next monthly run not properly computed across years: expected %s got %s", expected, next)
+	}
+}
+
+func TestDigestStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-digests")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "digests.json")
+
+	store, err := OpenDigestStore(path)
+	if err != nil {
+		t.Fatalf("Got an error opening the digest store: %s", err)
+	}
+	first, _ := store.Add(Digest{GuildID: "guild", BroadcasterName: "streamer"})
+	store.Add(Digest{GuildID: "other-guild", BroadcasterName: "streamer"})
+	if first.ID != "1" {
+		t.Errorf("Digest ID not properly assigned: expected \"1\" got %s", first.ID)
+	}
+
+	reopened, _ := OpenDigestStore(path)
+	third, _ := reopened.Add(Digest{GuildID: "guild", BroadcasterName: "another"})
+	if third.ID != "3" {
+		t.Errorf("Digest IDs not persisted: expected \"3\" got %s", third.ID)
+	}
+	if digests := reopened.List("guild"); len(digests) != 2 || digests[0].ID != "1" || digests[1].ID != "3" {
+		t.Errorf("Digests not correctly listed: expected [1 3] got %v", digests)
+	}
+
+	if removed, _ := reopened.Remove("other-guild", "1"); removed {
+		t.Errorf("Digest should not be removed from another guild")
+	}
+	if removed, _ := reopened.Remove("guild", "1"); !removed {
+		t.Errorf("Digest should have been removed")
+	}
+	if digests := reopened.List("guild"); len(digests) != 1 {
+		t.Errorf("Digest not correctly removed: expected 1 digest got %v", digests)
+	}
+}
+
+func TestDigestStoreRunDue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-digests")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "digests.json")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := ClipsResponse{Data: []Clip{{ID: "less", ViewCount: 1}, {ID: "more", ViewCount: 2}}}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	// Wednesday 2020-06-03
+	now := time.Date(2020, 6, 3, 12, 0, 0, 0, time.UTC)
+	store, _ := OpenDigestStore(path)
+	store.now = func() time.Time { return now }
+	store.Add(Digest{GuildID: "guild", BroadcasterID: "1", Top: 1, Schedule: Schedule{Every: "week", Weekday: time.Monday, Timezone: "UTC"}})
+
+	var posted [][]Clip
+	post := func(d Digest, clips []Clip) error {
+		posted = append(posted, clips)
+		return nil
+	}
+
+	store.RunDue(twitch, post)
+	if len(posted) != 0 {
+		t.Errorf("Digest should not be posted before its scheduled time, got %v", posted)
+	}
+
+	// The bot was down for two weeks
+	now = time.Date(2020, 6, 17, 12, 0, 0, 0, time.UTC)
+	store.RunDue(twitch, post)
+	if len(posted) != 1 || len(posted[0]) != 1 || posted[0][0].ID != "more" {
+		t.Errorf("Due digest should be posted once with the top clip, expected [[more]] got %v", posted)
+	}
+
+	reopened, _ := OpenDigestStore(path)
+	reopened.now = store.now
+	reopened.RunDue(twitch, post)
+	if len(posted) != 1 {
+		t.Errorf("Digest should not be posted again after a restart, got %v", posted)
+	}
+}
+
+func TestDigestStoreRunDueOwner(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-digests")
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(clipsHandler))
+	defer ts.Close()
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	now := time.Date(2020, 6, 3, 12, 0, 0, 0, time.UTC)
+	store, _ := OpenDigestStore(filepath.Join(dir, "digests.json"))
+	store.now = func() time.Time { return now }
+	store.Add(Digest{GuildID: "mine", BroadcasterID: "1", Top: 1, Schedule: Schedule{Every: "week", Weekday: time.Monday, Timezone: "UTC"}})
+	store.Add(Digest{GuildID: "theirs", BroadcasterID: "1", Top: 1, Schedule: Schedule{Every: "week", Weekday: time.Monday, Timezone: "UTC"}})
+	store.SetOwner(func(guildID string) bool { return guildID == "mine" })
+	if broadcasterIDs := store.BroadcasterIDs(); len(broadcasterIDs) != 1 {
+		t.Errorf("Only the broadcasters of owned guilds should be indexed, got %v", broadcasterIDs)
+	}
+
+	var posted []string
+	now = now.Add(14 * 24 * time.Hour)
+	store.RunDue(twitch, func(d Digest, clips []Clip) error {
+		posted = append(posted, d.GuildID)
+		return nil
+	})
+	if len(posted) != 1 || posted[0] != "mine" {
+		t.Errorf("Only the digests of owned guilds should be posted, expected [mine] got %v", posted)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func clipEmbed(clip Clip, videos map[string]Video, games map[string]Game, format LocaleFormat) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Views", Value: format.Number(clip.ViewCount), Inline: true},
+		{Name: "Clipped by", Value: clip.CreatorName, Inline: true},
+	}
+	if game, ok := games[clip.GameID]; ok {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Game", Value: game.Name, Inline: true})
+	} else if clip.GameID != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Game", Value: clip.GameID, Inline: true})
+	}
+	if video, ok := videos[clip.VideoID]; ok {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "VOD", Value: VODURL(video, VODOffset(clip, video))})
+	} else if clip.VideoID != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "VOD", Value: "https://www.twitch.tv/videos/" + clip.VideoID})
+	}
+
+	embed := &discordgo.MessageEmbed{
+		URL:       clip.URL,
+		Title:     clip.Title,
+		Timestamp: clip.CreatedAt,
+		Author:    &discordgo.MessageEmbedAuthor{Name: clip.BroadcasterName, URL: "https://www.twitch.tv/" + strings.ToLower(clip.BroadcasterName)},
+		Fields:    fields,
+	}
+	if clip.ThumbnailURL != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: clip.ThumbnailURL}
+	}
+	if game, ok := games[clip.GameID]; ok && game.BoxArtURL != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: game.BoxArt(144, 192)}
+	}
+	return embed
+}
+
+func statsEmbed(broadcaster Broadcaster, stats *ClipStats, games map[string]Game, format LocaleFormat) *discordgo.MessageEmbed {
+	busiest := stats.BusiestDay()
+	median := strconv.FormatFloat(stats.MedianViews(), 'f', -1, 64)
+	if stats.MedianApproximate() {
+		median = "~" + median
+	}
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Clips", Value: format.Number(stats.TotalClips), Inline: true},
+		{Name: "Total views", Value: format.Number(stats.TotalViews), Inline: true},
+		{Name: "Median views", Value: median, Inline: true},
+		{Name: "Clips per day", Value: strconv.FormatFloat(stats.ClipsPerDay(), 'f', 1, 64), Inline: true},
+		{Name: "Busiest day", Value: busiest.Value + " (" + strconv.Itoa(busiest.Count) + " clips)", Inline: true},
+	}
+	if topGames := stats.TopGames(3); len(topGames) > 0 {
+		for i, c := range topGames {
+			if game, ok := games[c.Value]; ok {
+				topGames[i].Value = game.Name
+			}
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Top games", Value: formatCounts(topGames)})
+	}
+	if languages := stats.TopLanguages(3); len(languages) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Top languages", Value: formatCounts(languages)})
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       broadcaster.DisplayName + " clip stats",
+		Description: "From " + format.Date(stats.StartedAt) + " to " + format.Date(stats.EndedAt),
+		Fields:      fields,
+	}
+}
+
+func formatCounts(counts []StatCount) string {
+	lines := make([]string, len(counts))
+	for i, c := range counts {
+		lines[i] = strconv.Itoa(i+1) + ". " + c.Value + " (" + strconv.Itoa(c.Count) + " clips)"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func digestEmbed(digest Digest, clips []Clip, format LocaleFormat) *discordgo.MessageEmbed {
+	period := "week"
+	if digest.Schedule.Every == "month" {
+		period = "month"
+	}
+
+	description := "No clips this " + period + "."
+	if len(clips) > 0 {
+		lines := make([]string, len(clips))
+		for i, clip := range clips {
+			lines[i] = strconv.Itoa(i+1) + ". [" + clip.Title + "](" + clip.URL + ") by " + clip.CreatorName + ". Views: " + format.Number(clip.ViewCount)
+		}
+		description = strings.Join(lines, "\n")
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Top " + digest.BroadcasterName + " clips of the " + period,
+		Description: description,
+	}
+}
+
+func liveEmbed(stream Stream) *discordgo.MessageEmbed {
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Viewers", Value: strconv.Itoa(stream.ViewerCount), Inline: true},
+	}
+	if stream.GameName != "" {
+		fields = append([]*discordgo.MessageEmbedField{{Name: "Game", Value: stream.GameName, Inline: true}}, fields...)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		URL:       "https://www.twitch.tv/" + stream.UserLogin,
+		Title:     stream.Title,
+		Timestamp: stream.StartedAt,
+		Author:    &discordgo.MessageEmbedAuthor{Name: stream.UserName + " is live!", URL: "https://www.twitch.tv/" + stream.UserLogin},
+		Fields:    fields,
+	}
+	if stream.ThumbnailURL != "" {
+		embed.Image = &discordgo.MessageEmbedImage{URL: stream.Thumbnail(1280, 720)}
+	}
+	return embed
+}
+
+// embedText renders an embed as a plain text message, for guilds that turned embeds off
+func embedText(embed *discordgo.MessageEmbed) string {
+	var lines []string
+	if embed.Author != nil && embed.Author.Name != "" {
+		lines = append(lines, embed.Author.Name)
+	}
+	if embed.Title != "" {
+		lines = append(lines, "**"+embed.Title+"**")
+	}
+	if embed.Description != "" {
+		lines = append(lines, embed.Description)
+	}
+	for _, field := range embed.Fields {
+		lines = append(lines, field.Name+": "+field.Value)
+	}
+	if embed.URL != "" {
+		lines = append(lines, embed.URL)
+	}
+	return strings.Join(lines, "\n")
+}
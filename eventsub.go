@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// eventSubMaxAge is how old a message can be before it's rejected as a possible replay
+	eventSubMaxAge = 10 * time.Minute
+	// eventSubMaxBody bounds the size of the messages accepted from Twitch
+	eventSubMaxBody = 1 << 20
+)
+
+// errDuplicateMessage means a message was already received, as Twitch resends messages it isn't sure arrived
+var errDuplicateMessage = errors.New("eventsub: message already received")
+
+// EventSub message headers sent by Twitch
+const (
+	eventSubMessageID        = "Twitch-Eventsub-Message-Id"
+	eventSubMessageTimestamp = "Twitch-Eventsub-Message-Timestamp"
+	eventSubMessageSignature = "Twitch-Eventsub-Message-Signature"
+	eventSubMessageType      = "Twitch-Eventsub-Message-Type"
+)
+
+// EventSubSubscription represents a Twitch EventSub subscription
+type EventSubSubscription struct {
+	ID        string            `json:"id,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+	CreatedAt string            `json:"created_at,omitempty"`
+}
+
+// EventSubTransport describes where Twitch delivers the events of a subscription
+type EventSubTransport struct {
+	Method   string `json:"method"`
+	Callback string `json:"callback"`
+	Secret   string `json:"secret,omitempty"`
+}
+
+// EventSubSubscriptionsResponse represents a response from a request to Twitch's EventSub subscriptions endpoint
+type EventSubSubscriptionsResponse struct {
+	Data []EventSubSubscription `json:"data"`
+}
+
+// EventSubMessage is the body of a message sent by Twitch to an EventSub webhook
+type EventSubMessage struct {
+	Challenge    string               `json:"challenge"`
+	Subscription EventSubSubscription `json:"subscription"`
+	Event        json.RawMessage      `json:"event"`
+}
+
+// StreamOnlineEvent is the event sent by Twitch for stream.online subscriptions
+type StreamOnlineEvent struct {
+	ID                   string `json:"id"`
+	BroadcasterUserID    string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	BroadcasterUserName  string `json:"broadcaster_user_name"`
+	Type                 string `json:"type"`
+	StartedAt            string `json:"started_at"`
+}
+
+// EventSubHandler receives Twitch EventSub webhook callbacks, verifying that they were signed with Secret
+// and rejecting replayed messages
+type EventSubHandler struct {
+	Secret         string
+	OnNotification func(subscription EventSubSubscription, event json.RawMessage)
+	mu             sync.Mutex
+	seen           map[string]time.Time
+	now            func() time.Time
+}
+
+// NewEventSubHandler returns an EventSubHandler that calls onNotification for every verified notification
+func NewEventSubHandler(secret string, onNotification func(EventSubSubscription, json.RawMessage)) *EventSubHandler {
+	return &EventSubHandler{
+		Secret:         secret,
+		OnNotification: onNotification,
+		seen:           make(map[string]time.Time),
+		now:            time.Now,
+	}
+}
+
+// ServeHTTP handles a single EventSub message
+func (h *EventSubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, eventSubMaxBody))
+	if err != nil {
+		http.Error(w, "couldn't read body", http.StatusBadRequest)
+		return
+	}
+
+	messageID := r.Header.Get(eventSubMessageID)
+	timestamp := r.Header.Get(eventSubMessageTimestamp)
+	if !h.validSignature(messageID, timestamp, body, r.Header.Get(eventSubMessageSignature)) {
+		Log.Warn("Rejected EventSub message with an invalid signature", "message", messageID)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	if err := h.checkReplay(messageID, timestamp); err == errDuplicateMessage {
+		// Twitch retries until it gets a 2xx, so acknowledge the message without handling it again
+		Log.Debug("Ignored duplicate EventSub message", "message", messageID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if err != nil {
+		Log.Warn("Rejected EventSub message", "message", messageID, "err", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	message := EventSubMessage{}
+	if err := json.Unmarshal(body, &message); err != nil {
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Header.Get(eventSubMessageType) {
+	case "webhook_callback_verification":
+		Log.Info("Verified EventSub subscription", "subscription", message.Subscription.ID, "type", message.Subscription.Type)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(message.Challenge))
+	case "notification":
+		// Twitch expects an answer within a few seconds, so the notification is handled after acknowledging it
+		w.WriteHeader(http.StatusNoContent)
+		if h.OnNotification != nil {
+			go h.OnNotification(message.Subscription, message.Event)
+		}
+	case "revocation":
+		Log.Warn("EventSub subscription was revoked", "subscription", message.Subscription.ID, "type", message.Subscription.Type, "status", message.Subscription.Status)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unknown message type", http.StatusBadRequest)
+	}
+}
+
+// validSignature checks the HMAC-SHA256 signature Twitch computes over the message ID, timestamp and body
+func (h *EventSubHandler) validSignature(messageID string, timestamp string, body []byte, signature string) bool {
+	expected := signEventSubMessage(h.Secret, messageID, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// checkReplay rejects messages that are too old or were already received, remembering messageID otherwise
+func (h *EventSubHandler) checkReplay(messageID string, timestamp string) error {
+	sentAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return errors.New("eventsub: invalid timestamp")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	if now.Sub(sentAt) > eventSubMaxAge || sentAt.Sub(now) > eventSubMaxAge {
+		return errors.New("eventsub: message timestamp out of range")
+	}
+	if _, ok := h.seen[messageID]; ok {
+		return errDuplicateMessage
+	}
+
+	// Messages older than eventSubMaxAge are rejected by timestamp, so there's no need to remember them
+	for id, seenAt := range h.seen {
+		if now.Sub(seenAt) > eventSubMaxAge {
+			delete(h.seen, id)
+		}
+	}
+	h.seen[messageID] = now
+	return nil
+}
+
+func signEventSubMessage(secret string, messageID string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateEventSubSubscription subscribes callback to an EventSub subscription type, with events signed with secret
+func (t TwitchAPI) CreateEventSubSubscription(subscriptionType string, version string, condition map[string]string, callback string, secret string) (EventSubSubscription, error) {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/eventsub/subscriptions"
+
+	subscription := EventSubSubscription{
+		Type:      subscriptionType,
+		Version:   version,
+		Condition: condition,
+		Transport: EventSubTransport{Method: "webhook", Callback: callback, Secret: secret},
+	}
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return EventSubSubscription{}, err
+	}
+
+	req := t.prepareRequest("POST", endpoint.String())
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	jsonResponse, err := t.Client.Do(req)
+	if err != nil {
+		return EventSubSubscription{}, err
+	}
+	defer jsonResponse.Body.Close()
+	if jsonResponse.StatusCode != http.StatusAccepted {
+		return EventSubSubscription{}, errors.New("twitch: couldn't create subscription, got status " + strconv.Itoa(jsonResponse.StatusCode))
+	}
+
+	resp := EventSubSubscriptionsResponse{}
+	json.NewDecoder(jsonResponse.Body).Decode(&resp)
+	if len(resp.Data) == 0 {
+		return EventSubSubscription{}, errors.New("twitch: no subscription created")
+	}
+	return resp.Data[0], nil
+}
+
+// GetEventSubSubscriptions lists the EventSub subscriptions of the application
+func (t TwitchAPI) GetEventSubSubscriptions() ([]EventSubSubscription, error) {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/eventsub/subscriptions"
+
+	var subscriptions []EventSubSubscription
+	cursor := ""
+	for {
+		if cursor != "" {
+			endpoint.RawQuery = url.Values{"after": {cursor}}.Encode()
+		}
+		req := t.prepareRequest("GET", endpoint.String())
+
+		jsonResponse, err := t.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(jsonResponse); err != nil {
+			jsonResponse.Body.Close()
+			return nil, err
+		}
+
+		resp := struct {
+			EventSubSubscriptionsResponse
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}{}
+		json.NewDecoder(jsonResponse.Body).Decode(&resp)
+		jsonResponse.Body.Close()
+
+		subscriptions = append(subscriptions, resp.Data...)
+		if resp.Pagination.Cursor == "" || len(resp.Data) == 0 {
+			return subscriptions, nil
+		}
+		cursor = resp.Pagination.Cursor
+	}
+}
+
+// DeleteEventSubSubscription removes an EventSub subscription
+func (t TwitchAPI) DeleteEventSubSubscription(subscriptionID string) error {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/eventsub/subscriptions"
+	endpoint.RawQuery = url.Values{"id": {subscriptionID}}.Encode()
+
+	req := t.prepareRequest("DELETE", endpoint.String())
+
+	jsonResponse, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	jsonResponse.Body.Close()
+	if jsonResponse.StatusCode != http.StatusNoContent {
+		return errors.New("twitch: couldn't delete subscription, got status " + strconv.Itoa(jsonResponse.StatusCode))
+	}
+	return nil
+}
+
+// SubscribeStreamOnline makes sure callback has a stream.online subscription for each of the broadcasters
+func (t TwitchAPI) SubscribeStreamOnline(broadcasterIDs []string, callback string, secret string) error {
+	subscriptions, err := t.GetEventSubSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	subscribed := make(map[string]bool)
+	for _, s := range subscriptions {
+		if s.Type == "stream.online" && s.Transport.Callback == callback && s.Status != "authorization_revoked" && s.Status != "user_removed" {
+			subscribed[s.Condition["broadcaster_user_id"]] = true
+		}
+	}
+
+	for _, broadcasterID := range broadcasterIDs {
+		if subscribed[broadcasterID] {
+			continue
+		}
+		_, err := t.CreateEventSubSubscription("stream.online", "1", map[string]string{"broadcaster_user_id": broadcasterID}, callback, secret)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// sendEventSubMessage acts as Twitch, signing and sending an EventSub message to url
+func sendEventSubMessage(t *testing.T, url string, secret string, messageID string, messageType string, sentAt time.Time, message EventSubMessage) (*http.Response, string) {
+	body, _ := json.Marshal(message)
+	timestamp := sentAt.Format(time.RFC3339Nano)
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+	req.Header.Set(eventSubMessageID, messageID)
+	req.Header.Set(eventSubMessageTimestamp, timestamp)
+	req.Header.Set(eventSubMessageType, messageType)
+	req.Header.Set(eventSubMessageSignature, signEventSubMessage(secret, messageID, timestamp, body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Got an error sending EventSub message: %s", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(respBody)
+}
+
+func TestEventSubHandler(t *testing.T) {
+	notifications := make(chan StreamOnlineEvent, 10)
+	handler := NewEventSubHandler("super-secret", func(subscription EventSubSubscription, rawEvent json.RawMessage) {
+		event := StreamOnlineEvent{}
+		json.Unmarshal(rawEvent, &event)
+		notifications <- event
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	subscription := EventSubSubscription{ID: "sub", Type: "stream.online", Version: "1"}
+
+	resp, body := sendEventSubMessage(t, ts.URL, "super-secret", "verify-1", "webhook_callback_verification", time.Now(), EventSubMessage{Challenge: "pogchamp", Subscription: subscription})
+	if resp.StatusCode != http.StatusOK || body != "pogchamp" {
+		t.Errorf("Challenge not properly answered, expected 200 \"pogchamp\" got %d %s", resp.StatusCode, body)
+	}
+
+	event, _ := json.Marshal(StreamOnlineEvent{ID: "stream", BroadcasterUserID: "1"})
+	notification := EventSubMessage{Subscription: subscription, Event: event}
+	resp, _ = sendEventSubMessage(t, ts.URL, "super-secret", "notification-1", "notification", time.Now(), notification)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Notification not properly acknowledged, expected 204 got %d", resp.StatusCode)
+	}
+	select {
+	case event := <-notifications:
+		if event.ID != "stream" {
+			t.Errorf("Notification not properly dispatched, expected stream got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Notification not dispatched")
+	}
+
+	resp, _ = sendEventSubMessage(t, ts.URL, "super-secret", "notification-1", "notification", time.Now(), notification)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Duplicate message should be acknowledged, expected 204 got %d", resp.StatusCode)
+	}
+
+	resp, _ = sendEventSubMessage(t, ts.URL, "super-secret", "notification-2", "notification", time.Now().Add(-time.Hour), notification)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Old message should be rejected, expected 403 got %d", resp.StatusCode)
+	}
+
+	resp, _ = sendEventSubMessage(t, ts.URL, "wrong-secret", "notification-3", "notification", time.Now(), notification)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Message with an invalid signature should be rejected, expected 403 got %d", resp.StatusCode)
+	}
+
+	select {
+	case event := <-notifications:
+		t.Errorf("Duplicate and rejected messages should not be dispatched, got %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeStreamOnline(t *testing.T) {
+	var created []EventSubSubscription
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			existing := EventSubSubscription{Type: "stream.online", Status: "enabled", Condition: map[string]string{"broadcaster_user_id": "1"}, Transport: EventSubTransport{Callback: "https://bot/eventsub"}}
+			json.NewEncoder(w).Encode(EventSubSubscriptionsResponse{Data: []EventSubSubscription{existing}})
+		case "POST":
+			subscription := EventSubSubscription{}
+			json.NewDecoder(r.Body).Decode(&subscription)
+			created = append(created, subscription)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(EventSubSubscriptionsResponse{Data: []EventSubSubscription{subscription}})
+		}
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	if err := twitch.SubscribeStreamOnline([]string{"1", "2"}, "https://bot/eventsub", "super-secret"); err != nil {
+		t.Errorf("Got an error from SubscribeStreamOnline: %s", err)
+	}
+	if len(created) != 1 || created[0].Condition["broadcaster_user_id"] != "2" {
+		t.Errorf("Only missing subscriptions should be created, expected broadcaster 2 got %v", created)
+	}
+	if created[0].Transport.Secret != "super-secret" || created[0].Transport.Method != "webhook" {
+		t.Errorf("Subscription transport not properly set, got %v", created[0].Transport)
+	}
+}
+
+func TestDeleteEventSubSubscription(t *testing.T) {
+	var deleted string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted = r.URL.Query().Get("id")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	if err := twitch.DeleteEventSubSubscription("sub"); err != nil {
+		t.Errorf("Got an error from DeleteEventSubSubscription: %s", err)
+	}
+	if deleted != "sub" {
+		t.Errorf("Subscription not properly deleted, expected \"sub\" got %s", deleted)
+	}
+}
+
+func TestGetEventSubSubscriptionsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"sub"}],"pagination":{"cursor":"next"}}`))
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	if subscriptions, err := twitch.GetEventSubSubscriptions(); err != ErrUnauthorized {
+		t.Errorf("Expected the status of a failed page as an error, got %v and %v", subscriptions, err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// maxAttachmentSize is the largest file Discord accepts from a bot in a server without boosts
+	maxAttachmentSize = 8 << 20
+	// maxExportClips bounds how many clips an export lists
+	maxExportClips = 10000
+)
+
+// exportContentTypes are the formats clips can be exported in, with the content type of their files
+var exportContentTypes = map[string]string{
+	"csv":  "text/csv",
+	"json": "application/x-ndjson",
+}
+
+// exportExtensions are the file extensions of the export formats
+var exportExtensions = map[string]string{
+	"csv":  ".csv",
+	"json": ".jsonl",
+}
+
+// ClipExporter writes clips as CSV or JSON lines into files of at most limit bytes, handing each file to send as
+// soon as it's full, so an export never holds more than a file in memory. Every CSV file starts with the header.
+type ClipExporter struct {
+	format string
+	name   string
+	limit  int
+	send   func(name string, file io.Reader) error
+	file   bytes.Buffer
+	record bytes.Buffer
+	files  int
+	clips  int
+}
+
+// NewClipExporter returns a ClipExporter for format, whose files are called name with the format's extension,
+// numbered when the clips don't fit in one
+func NewClipExporter(format string, name string, limit int, send func(name string, file io.Reader) error) (*ClipExporter, error) {
+	if _, ok := exportContentTypes[format]; !ok {
+		return nil, errors.New("export: format must be csv or json")
+	}
+	return &ClipExporter{format: format, name: name, limit: limit, send: send}, nil
+}
+
+// Write adds a clip to the current file, sending the file first if the clip doesn't fit in it
+func (e *ClipExporter) Write(clip Clip) error {
+	e.record.Reset()
+	record := newClipRecord(clip)
+	if e.format == "csv" {
+		w := csv.NewWriter(&e.record)
+		w.Write(record.values())
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&e.record).Encode(record); err != nil {
+		return err
+	}
+
+	if e.file.Len() > 0 && e.file.Len()+e.record.Len() > e.limit {
+		// More clips follow, so the file is numbered even if it's the first one
+		if err := e.flush(e.name + "-" + strconv.Itoa(e.files+1)); err != nil {
+			return err
+		}
+	}
+	if e.file.Len() == 0 && e.format == "csv" {
+		w := csv.NewWriter(&e.file)
+		w.Write(clipColumns)
+		w.Flush()
+	}
+	e.file.Write(e.record.Bytes())
+	e.clips++
+	return nil
+}
+
+// Close sends the last file, if it has any clips
+func (e *ClipExporter) Close() error {
+	if e.file.Len() == 0 {
+		return nil
+	}
+	if e.files == 0 {
+		return e.flush(e.name)
+	}
+	return e.flush(e.name + "-" + strconv.Itoa(e.files+1))
+}
+
+// Files returns how many files were sent
+func (e *ClipExporter) Files() int {
+	return e.files
+}
+
+// Clips returns how many clips were written
+func (e *ClipExporter) Clips() int {
+	return e.clips
+}
+
+func (e *ClipExporter) flush(name string) error {
+	e.files++
+	err := e.send(name+exportExtensions[e.format], bytes.NewReader(e.file.Bytes()))
+	e.file.Reset()
+	return err
+}
+
+// exportFileName returns a name for the export of a broadcaster's clips that's safe to use as a file name
+func exportFileName(broadcaster string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return -1
+	}, broadcaster)
+	return name + "-clips"
+}
+
+// handleExportCommand uploads every clip matching a search, or the top clips of a top command, as files in the
+// format of its export option rather than listing a few of them. Search matches are written as Twitch returns them,
+// so files go out while the search runs.
+func handleExportCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	format := c.Options["export"]
+	if _, ok := exportContentTypes[format]; !ok {
+		sendReply(ctx, s, m, "I can export clips as csv or json, like export:csv.")
+		return outcomeInvalid
+	}
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need at least the name of a streamer to look for clips!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+	targetClip := Clip{
+		BroadcasterID: broadcasters[0].ID,
+		Title:         c.Title,
+		StartedAt:     c.StartedAt,
+		EndedAt:       c.EndedAt,
+		CreatorName:   c.Creator,
+	}
+	if targetClip.StartedAt.IsZero() {
+		targetClip.StartedAt = config.PeriodStart()
+	}
+
+	content := "Here are the " + c.Broadcaster + " clips that match your search."
+	if c.SubCommand == "top" {
+		content = "Here are the top " + strconv.Itoa(c.Top) + " " + c.Broadcaster + " clips that match your search, most viewed first."
+	}
+	exporter, err := NewClipExporter(format, exportFileName(c.Broadcaster), maxAttachmentSize, func(name string, file io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+			Content: content,
+			Files:   []*discordgo.File{{Name: name, ContentType: exportContentTypes[format], Reader: file}},
+		})
+		// Only the first file needs an introduction
+		content = ""
+		return err
+	})
+	if err != nil {
+		messageLog(m).Error("Couldn't export clips", "err", err)
+		return outcomeError
+	}
+
+	// writeErr is set when a file can't be uploaded, which ends the export
+	var writeErr error
+	truncated := false
+	write := func(clip Clip) bool {
+		if exporter.Clips() == maxExportClips {
+			truncated = true
+			return false
+		}
+		writeErr = exporter.Write(clip)
+		return writeErr == nil
+	}
+	matchFunc := matchMany(matchTitle, matchCreator)
+	var walkErr error
+	if c.SubCommand == "top" {
+		var results []Clip
+		results, walkErr = twitch.FindMostPopularClips(targetClip, matchFunc, c.Top)
+		for _, clip := range results {
+			if !write(clip) {
+				break
+			}
+		}
+	} else {
+		walkErr = twitch.walkTargetClips(targetClip, func(clips []Clip) bool {
+			for _, clip := range clips {
+				if matchFunc(clip, targetClip) && !write(clip) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	if walkErr != nil {
+		messageLog(m).Warn("Couldn't walk clips", "broadcaster", targetClip.BroadcasterID, "err", walkErr)
+	}
+	if writeErr == nil {
+		writeErr = exporter.Close()
+	}
+	if writeErr != nil {
+		messageLog(m).Warn("Couldn't upload exported clips", "files", exporter.Files(), "err", writeErr)
+		sendReply(ctx, s, m, "I couldn't upload the exported clips, please try again later.")
+		return outcomeError
+	}
+	if exporter.Clips() == 0 {
+		sendReply(ctx, s, m, "Couldn't find any \""+c.Broadcaster+"\" clips. Check the streamer name and the date bounds.")
+		return lookupOutcome(walkErr)
+	}
+
+	switch {
+	case walkErr != nil:
+		sendReply(ctx, s, m, "Twitch stopped answering partway, so the export only has the first "+config.Format().Number(exporter.Clips())+" clips I found. Please try again later for the rest.")
+	case truncated:
+		sendReply(ctx, s, m, "More clips match than the "+config.Format().Number(maxExportClips)+" I can export, try a shorter date range to get the rest.")
+	}
+	return partialOutcome(walkErr)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type exportedFile struct {
+	name    string
+	content string
+}
+
+func exportClips(t *testing.T, format string, limit int, n int) []exportedFile {
+	var files []exportedFile
+	exporter, err := NewClipExporter(format, "streamer-clips", limit, func(name string, file io.Reader) error {
+		content, _ := ioutil.ReadAll(file)
+		files = append(files, exportedFile{name, string(content)})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Got an error from NewClipExporter: %s", err)
+	}
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		if err := exporter.Write(Clip{ID: id, Title: "Clip, \"" + id + "\"", ViewCount: n - i}); err != nil {
+			t.Fatalf("Got an error from Write: %s", err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Got an error from Close: %s", err)
+	}
+	if exporter.Files() != len(files) || exporter.Clips() != n {
+		t.Errorf("Expected %d files and %d clips, got %d and %d", len(files), n, exporter.Files(), exporter.Clips())
+	}
+	return files
+}
+
+func TestClipExporterSingleFile(t *testing.T) {
+	files := exportClips(t, "json", maxAttachmentSize, 3)
+	if len(files) != 1 || files[0].name != "streamer-clips.jsonl" {
+		t.Fatalf("Expected a single streamer-clips.jsonl file, got %+v", files)
+	}
+	decoder := json.NewDecoder(strings.NewReader(files[0].content))
+	var ids []string
+	for decoder.More() {
+		var record clipRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Couldn't decode JSON line: %s", err)
+		}
+		ids = append(ids, record.ID)
+	}
+	if strings.Join(ids, ",") != "0,1,2" {
+		t.Errorf("Expected clips 0,1,2, got %v", ids)
+	}
+
+	if files := exportClips(t, "csv", maxAttachmentSize, 0); len(files) != 0 {
+		t.Errorf("Expected no files without clips, got %+v", files)
+	}
+}
+
+func TestClipExporterSplitsFiles(t *testing.T) {
+	files := exportClips(t, "csv", 300, 20)
+	if len(files) < 2 {
+		t.Fatalf("Expected the clips to be split into several files, got %d", len(files))
+	}
+
+	clips := 0
+	for i, file := range files {
+		if expected := "streamer-clips-" + strconv.Itoa(i+1) + ".csv"; file.name != expected {
+			t.Errorf("Expected file %s, got %s", expected, file.name)
+		}
+		if len(file.content) > 300 {
+			t.Errorf("File %s is %d bytes, over the limit", file.name, len(file.content))
+		}
+		rows, err := csv.NewReader(strings.NewReader(file.content)).ReadAll()
+		if err != nil {
+			t.Fatalf("Couldn't read %s: %s", file.name, err)
+		}
+		if strings.Join(rows[0], ",") != strings.Join(clipColumns, ",") {
+			t.Errorf("Expected %s to start with the header, got %v", file.name, rows[0])
+		}
+		for _, row := range rows[1:] {
+			if row[0] != strconv.Itoa(clips) || row[1] != "Clip, \""+row[0]+"\"" {
+				t.Errorf("Unexpected row %v in %s", row, file.name)
+			}
+			clips++
+		}
+	}
+	if clips != 20 {
+		t.Errorf("Expected 20 clips across the files, got %d", clips)
+	}
+}
+
+func TestNewClipExporterInvalidFormat(t *testing.T) {
+	if _, err := NewClipExporter("xml", "streamer-clips", maxAttachmentSize, nil); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestExportFileName(t *testing.T) {
+	if name := exportFileName("../some streamer_1"); name != "somestreamer_1-clips" {
+		t.Errorf("Expected somestreamer_1-clips, got %s", name)
+	}
+}
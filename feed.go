@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"html"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// feedPeriod is how far back feeds look for clips
+	feedPeriod = 7 * 24 * time.Hour
+	// feedSize is how many clips a feed lists
+	feedSize = 50
+	// feedTTL is how long the clips of a feed are reused, and how long readers may cache it
+	feedTTL = 5 * time.Minute
+)
+
+// loginRegex matches valid Twitch logins
+var loginRegex = regexp.MustCompile(`^[A-Za-z0-9_]{1,25}$`)
+
+// atomFeed is an Atom feed, as described in RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// rssFeed is an RSS 2.0 feed
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	TTL           int       `xml:"ttl"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Creator     string        `xml:"dc:creator"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// FeedHandler serves Atom and RSS feeds of the most recent clips of a broadcaster at /feeds/<login>.atom and
+// /feeds/<login>.rss. The clips come from the index when it covers the broadcaster, and from Twitch otherwise.
+// Feeds need no key, so the Twitch requests of all of them share an hourly budget.
+type FeedHandler struct {
+	twitch TwitchAPI
+	limits *RateLimiter
+	now    func() time.Time
+}
+
+// feedContent is what a feed lists, cached for feedTTL
+type feedContent struct {
+	broadcaster Broadcaster
+	clips       []Clip
+}
+
+// NewFeedHandler returns a FeedHandler looking clips up with t that makes up to budget Twitch requests per hour,
+// unlimited if 0
+func NewFeedHandler(t TwitchAPI, budget int) *FeedHandler {
+	return &FeedHandler{twitch: t, limits: NewRateLimiter(0, 0, 0, budget), now: time.Now}
+}
+
+func (fh *FeedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "only GET and HEAD are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/feeds/")
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 || !loginRegex.MatchString(name[:dot]) {
+		http.NotFound(w, r)
+		return
+	}
+	login, kind := strings.ToLower(name[:dot]), name[dot+1:]
+	if kind != "atom" && kind != "rss" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Feed readers poll often, so feeds are cached and only the ones Twitch is asked for again are charged
+	cacheKey := "feed:" + login
+	var content feedContent
+	if cached, ok := fh.twitch.Cache.Get(cacheKey); ok {
+		content = cached.(feedContent)
+	} else {
+		now := fh.now()
+		wait, err := fh.limits.Allow("feeds", "", EstimateCost(Command{}, now.Add(-feedPeriod), now))
+		if err != nil || wait > 0 {
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			}
+			http.Error(w, "too many feeds were requested, try again later", http.StatusTooManyRequests)
+			return
+		}
+		broadcasters, err := fh.twitch.GetBroadcastersByName([]string{login})
+		if err != nil {
+			if errors.Is(err, ErrNoBroadcasters) {
+				http.NotFound(w, r)
+				return
+			}
+			Log.Warn("Couldn't look up the broadcaster of a feed", "login", login, "err", err)
+			http.Error(w, "Twitch couldn't answer the request", http.StatusBadGateway)
+			return
+		}
+		clips, err := fh.recentClips(broadcasters[0].ID)
+		if err != nil {
+			Log.Warn("Couldn't get the clips of a feed", "login", login, "err", err)
+			http.Error(w, "Twitch couldn't answer the request", http.StatusBadGateway)
+			return
+		}
+		content = feedContent{broadcaster: broadcasters[0], clips: clips}
+		fh.twitch.Cache.SetWithTTL(cacheKey, content, feedTTL)
+	}
+	broadcaster, clips := content.broadcaster, content.clips
+
+	self := "http://" + r.Host + r.URL.Path
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		self = "https://" + r.Host + r.URL.Path
+	}
+	var body []byte
+	var contentType string
+	var err error
+	if kind == "atom" {
+		body, err = xml.Marshal(newAtomFeed(broadcaster, clips, self, fh.now()))
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		body, err = xml.Marshal(newRSSFeed(broadcaster, clips))
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+	if err != nil {
+		Log.Error("Couldn't write a feed", "login", login, "err", err)
+		http.Error(w, "couldn't write the feed", http.StatusInternalServerError)
+		return
+	}
+	body = append([]byte(xml.Header), body...)
+
+	sum := sha256.Sum256(body)
+	etag := "\"" + hex.EncodeToString(sum[:16]) + "\""
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(feedTTL.Seconds())))
+	if len(clips) > 0 {
+		if createdAt, err := time.Parse(time.RFC3339, clips[0].CreatedAt); err == nil {
+			w.Header().Set("Last-Modified", createdAt.UTC().Format(http.TimeFormat))
+		}
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
+
+// recentClips returns the most recent clips of a broadcaster within feedPeriod, newest first
+func (fh *FeedHandler) recentClips(broadcasterID string) ([]Clip, error) {
+	startedAt := fh.now().Add(-feedPeriod)
+	var clips []Clip
+	if fh.twitch.Index != nil && fh.twitch.Index.Covers(broadcasterID, startedAt, time.Time{}) {
+		clips = fh.twitch.Index.Clips(broadcasterID, startedAt, time.Time{})
+	} else {
+		// Twitch returns the most viewed clips first, so the newest can be on any page of the window
+		err := fh.twitch.WalkClips(broadcasterID, startedAt, time.Time{}, func(page []Clip) bool {
+			clips = append(clips, page...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(clips, func(i, j int) bool { return clips[i].CreatedAt > clips[j].CreatedAt })
+	}
+	if len(clips) > feedSize {
+		clips = clips[:feedSize]
+	}
+	return clips, nil
+}
+
+func newAtomFeed(broadcaster Broadcaster, clips []Clip, self string, now time.Time) atomFeed {
+	channel := "https://www.twitch.tv/" + broadcaster.Login + "/clips"
+	feed := atomFeed{
+		Title: broadcaster.DisplayName + " clips",
+		ID:    channel,
+		// A feed without entries has nothing to date it by, so it changes at most once per feedTTL for ETags to work
+		Updated: now.UTC().Truncate(feedTTL).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "alternate", Href: channel, Type: "text/html"},
+			{Rel: "self", Href: self, Type: "application/atom+xml"},
+		},
+	}
+	if len(clips) > 0 {
+		feed.Updated = clips[0].CreatedAt
+	}
+	for _, clip := range clips {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     clip.Title,
+			ID:        clip.URL,
+			Published: clip.CreatedAt,
+			Updated:   clip.CreatedAt,
+			Links:     []atomLink{{Rel: "alternate", Href: clip.URL, Type: "text/html"}},
+			Author:    atomAuthor{Name: clip.CreatorName},
+			Content:   atomContent{Type: "html", Body: feedClipHTML(clip)},
+		})
+	}
+	return feed
+}
+
+func newRSSFeed(broadcaster Broadcaster, clips []Clip) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       broadcaster.DisplayName + " clips",
+			Link:        "https://www.twitch.tv/" + broadcaster.Login + "/clips",
+			Description: "The most recent Twitch clips of " + broadcaster.DisplayName,
+			TTL:         int(feedTTL.Minutes()),
+		},
+	}
+	for i, clip := range clips {
+		createdAt, err := time.Parse(time.RFC3339, clip.CreatedAt)
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			feed.Channel.LastBuildDate = createdAt.Format(time.RFC1123Z)
+		}
+		item := rssItem{
+			Title:       clip.Title,
+			Link:        clip.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: clip.URL},
+			PubDate:     createdAt.Format(time.RFC1123Z),
+			Creator:     clip.CreatorName,
+			Description: feedClipHTML(clip),
+		}
+		if clip.ThumbnailURL != "" {
+			item.Enclosure = &rssEnclosure{URL: clip.ThumbnailURL, Type: "image/jpeg"}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+// feedClipHTML describes a clip in HTML, with its thumbnail, views and creator
+func feedClipHTML(clip Clip) string {
+	var b bytes.Buffer
+	if clip.ThumbnailURL != "" {
+		b.WriteString(`<a href="` + html.EscapeString(clip.URL) + `"><img src="` + html.EscapeString(clip.ThumbnailURL) + `" alt="` + html.EscapeString(clip.Title) + `"></a>`)
+	}
+	b.WriteString("<p>Clipped by " + html.EscapeString(clip.CreatorName) + ", " + strconv.Itoa(clip.ViewCount) + " views</p>")
+	return b.String()
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
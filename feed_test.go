@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFeedHandler(t *testing.T, clipRequests *int32) *FeedHandler {
+	twitch := newCLITwitchAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/helix/users":
+			if r.URL.Query().Get("login") != "streamer" {
+				json.NewEncoder(w).Encode(BroadcasterResponse{})
+				return
+			}
+			json.NewEncoder(w).Encode(BroadcasterResponse{Data: []Broadcaster{{ID: "1", Login: "streamer", DisplayName: "Streamer"}}})
+		case "/helix/clips":
+			atomic.AddInt32(clipRequests, 1)
+			json.NewEncoder(w).Encode(ClipsResponse{Data: []Clip{
+				{ID: "a", Title: "Most viewed", CreatorName: "alice", ViewCount: 30, URL: "https://clips.twitch.tv/a",
+					ThumbnailURL: "https://clips-media.twitch.tv/a.jpg", CreatedAt: "2020-06-01T10:00:00Z"},
+				{ID: "b", Title: "Newest <3", CreatorName: "bob", ViewCount: 10, URL: "https://clips.twitch.tv/b",
+					CreatedAt: "2020-06-03T10:00:00Z"},
+			}})
+		}
+	})
+	return NewFeedHandler(*twitch, 0)
+}
+
+func feedRequest(fh *FeedHandler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	fh.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFeedHandlerAtom(t *testing.T) {
+	var clipRequests int32
+	fh := newTestFeedHandler(t, &clipRequests)
+
+	rec := feedRequest(fh, "/feeds/Streamer.atom", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/atom+xml") {
+		t.Errorf("Expected an Atom content type, got %s", contentType)
+	}
+	if rec.Header().Get("Last-Modified") != "Wed, 03 Jun 2020 10:00:00 GMT" {
+		t.Errorf("Expected Last-Modified to be the newest clip, got %s", rec.Header().Get("Last-Modified"))
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Couldn't parse the feed: %s", err)
+	}
+	if feed.Title != "Streamer clips" || feed.Updated != "2020-06-03T10:00:00Z" || len(feed.Entries) != 2 {
+		t.Fatalf("Unexpected feed %+v", feed)
+	}
+	if feed.Entries[0].ID != "https://clips.twitch.tv/b" || feed.Entries[0].Title != "Newest <3" || feed.Entries[0].Author.Name != "bob" {
+		t.Errorf("Expected the newest clip first, got %+v", feed.Entries[0])
+	}
+	if content := feed.Entries[1].Content.Body; !strings.Contains(content, `<img src="https://clips-media.twitch.tv/a.jpg"`) || !strings.Contains(content, "30 views") {
+		t.Errorf("Expected the thumbnail and views in the content, got %s", content)
+	}
+
+	feedRequest(fh, "/feeds/streamer.rss", nil)
+	if clipRequests != 1 {
+		t.Errorf("Expected the clips to be cached between feeds, got %d requests", clipRequests)
+	}
+}
+
+func TestFeedHandlerRSS(t *testing.T) {
+	var clipRequests int32
+	fh := newTestFeedHandler(t, &clipRequests)
+
+	rec := feedRequest(fh, "/feeds/streamer.rss", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a 200, got %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `xmlns:dc="http://purl.org/dc/elements/1.1/"`) || !strings.Contains(body, "<dc:creator>bob</dc:creator>") {
+		t.Errorf("Expected the creators as dc:creator, got %s", body)
+	}
+
+	var feed rssFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Couldn't parse the feed: %s", err)
+	}
+	items := feed.Channel.Items
+	if len(items) != 2 || items[0].Link != "https://clips.twitch.tv/b" || items[0].PubDate != "Wed, 03 Jun 2020 10:00:00 +0000" {
+		t.Fatalf("Unexpected items %+v", items)
+	}
+	if items[0].Enclosure != nil || items[1].Enclosure == nil || items[1].Enclosure.URL != "https://clips-media.twitch.tv/a.jpg" {
+		t.Errorf("Expected thumbnails as enclosures, got %+v and %+v", items[0].Enclosure, items[1].Enclosure)
+	}
+}
+
+func TestFeedHandlerETag(t *testing.T) {
+	var clipRequests int32
+	fh := newTestFeedHandler(t, &clipRequests)
+	fh.now = func() time.Time { return time.Date(2020, 6, 4, 0, 0, 0, 0, time.UTC) }
+
+	rec := feedRequest(fh, "/feeds/streamer.atom", nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Fatalf("Expected an ETag and caching headers, got %v", rec.Header())
+	}
+
+	rec = feedRequest(fh, "/feeds/streamer.atom", http.Header{"If-None-Match": {`"other", ` + etag}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() > 0 {
+		t.Errorf("Expected a 304 without a body for a matching ETag, got %d", rec.Code)
+	}
+	rec = feedRequest(fh, "/feeds/streamer.atom", http.Header{"If-None-Match": {`"other"`}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected a 200 for another ETag, got %d", rec.Code)
+	}
+	if rss := feedRequest(fh, "/feeds/streamer.rss", nil); rss.Header().Get("ETag") == etag {
+		t.Errorf("Expected the RSS and Atom feeds to have different ETags")
+	}
+}
+
+func TestFeedHandlerNotFound(t *testing.T) {
+	var clipRequests int32
+	fh := newTestFeedHandler(t, &clipRequests)
+
+	for _, path := range []string{"/feeds/nobody.atom", "/feeds/streamer.json", "/feeds/streamer", "/feeds/not-a-login.rss"} {
+		if rec := feedRequest(fh, path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected a 404 for %s, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestFeedHandlerWalksEveryPage(t *testing.T) {
+	twitch := newCLITwitchAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/helix/users":
+			json.NewEncoder(w).Encode(BroadcasterResponse{Data: []Broadcaster{{ID: "1", Login: "streamer", DisplayName: "Streamer"}}})
+		case "/helix/clips":
+			res := ClipsResponse{Data: []Clip{{ID: "a", ViewCount: 30, URL: "https://clips.twitch.tv/a", CreatedAt: "2020-06-01T10:00:00Z"}}}
+			if r.URL.Query().Get("after") == "" {
+				res.Pagination.Cursor = "page-2"
+			} else {
+				res.Data = []Clip{{ID: "b", ViewCount: 1, URL: "https://clips.twitch.tv/b", CreatedAt: "2020-06-03T10:00:00Z"}}
+			}
+			json.NewEncoder(w).Encode(res)
+		}
+	})
+	fh := NewFeedHandler(*twitch, 0)
+
+	var feed atomFeed
+	xml.Unmarshal(feedRequest(fh, "/feeds/streamer.atom", nil).Body.Bytes(), &feed)
+	if len(feed.Entries) != 2 || feed.Entries[0].ID != "https://clips.twitch.tv/b" {
+		t.Errorf("Expected the newest clip from the last page first, got %+v", feed.Entries)
+	}
+}
+
+func TestFeedHandlerBudget(t *testing.T) {
+	var clipRequests int32
+	fh := newTestFeedHandler(t, &clipRequests)
+	now := time.Now()
+	// Enough for a single feed that isn't cached
+	fh.limits = NewRateLimiter(0, 0, 0, EstimateCost(Command{}, now.Add(-feedPeriod), now))
+
+	if rec := feedRequest(fh, "/feeds/streamer.atom", nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := feedRequest(fh, "/feeds/other.atom", nil)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 429 with Retry-After once the budget is spent, got %d", rec.Code)
+	}
+	if rec := feedRequest(fh, "/feeds/streamer.rss", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected cached feeds to be served without budget, got %d", rec.Code)
+	}
+}
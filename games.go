@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gamesTTL is how long resolved games are cached, as game names and box art rarely change
+const gamesTTL = 24 * time.Hour
+
+// maxGamesPerRequest is the maximum number of IDs Twitch accepts in a single Get Games request
+const maxGamesPerRequest = 100
+
+// GamesResponse represents a response from a request to Twitch's Get Games
+type GamesResponse struct {
+	Data []Game `json:"data"`
+}
+
+// Game represents a Twitch game or category
+type Game struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	BoxArtURL string `json:"box_art_url"`
+}
+
+// BoxArt returns the URL of the game's box art with the given dimensions
+func (g Game) BoxArt(width int, height int) string {
+	r := strings.NewReplacer("{width}", strconv.Itoa(width), "{height}", strconv.Itoa(height))
+	return r.Replace(g.BoxArtURL)
+}
+
+// GetGamesByID resolves game IDs to games, using cached games when available. Each missing game is requested
+// once, in batches of up to maxGamesPerRequest.
+func (t TwitchAPI) GetGamesByID(gameIDs []string) (map[string]Game, error) {
+	games := make(map[string]Game)
+	seen := make(map[string]bool)
+	var missing []string
+	for _, gameID := range gameIDs {
+		if gameID == "" || seen[gameID] {
+			continue
+		}
+		seen[gameID] = true
+		if game, ok := t.Cache.Get("games:" + gameID); ok {
+			games[gameID] = game.(Game)
+		} else {
+			missing = append(missing, gameID)
+		}
+	}
+
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > maxGamesPerRequest {
+			batch = batch[:maxGamesPerRequest]
+		}
+		missing = missing[len(batch):]
+
+		found, err := t.requestGames(batch)
+		if err != nil {
+			return games, err
+		}
+		for _, game := range found {
+			t.Cache.SetWithTTL("games:"+game.ID, game, gamesTTL)
+			games[game.ID] = game
+		}
+	}
+
+	return games, nil
+}
+
+func (t TwitchAPI) requestGames(gameIDs []string) ([]Game, error) {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/games"
+
+	q := endpoint.Query()
+	for _, gameID := range gameIDs {
+		q.Add("id", gameID)
+	}
+	endpoint.RawQuery = q.Encode()
+
+	req := t.prepareRequest("GET", endpoint.String())
+	Log.Debug("Twitch request", "method", req.Method, "url", req.URL)
+
+	jsonResponse, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer jsonResponse.Body.Close()
+	if err := checkResponse(jsonResponse); err != nil {
+		return nil, err
+	}
+
+	resp := GamesResponse{}
+	json.NewDecoder(jsonResponse.Body).Decode(&resp)
+
+	return resp.Data, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestGetGamesByID(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		gamesHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	games, err := twitch.GetGamesByID([]string{"509658", "", "21779"})
+	if err != nil {
+		t.Errorf("Got an error from GetGamesByID: %s", err)
+	}
+	if len(games) != 2 || games["509658"].Name != "Game 509658" || games["21779"].Name != "Game 21779" {
+		t.Errorf("Games not correctly returned by GetGamesByID, got %v", games)
+	}
+
+	games, _ = twitch.GetGamesByID([]string{"509658"})
+	if requests != 1 {
+		t.Errorf("Cached games should not be requested again, expected 1 request got %d", requests)
+	}
+	if games["509658"].Name != "Game 509658" {
+		t.Errorf("Game not correctly returned from cache, got %v", games["509658"])
+	}
+	ts.Close()
+}
+
+func TestGetGamesByIDError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	if _, err := twitch.GetGamesByID([]string{"509658"}); err == nil {
+		t.Errorf("GetGamesByID should return an error when Twitch fails")
+	}
+	ts.Close()
+}
+
+func TestGetGamesByIDBatches(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if ids := r.URL.Query()["id"]; len(ids) > maxGamesPerRequest {
+			t.Errorf("Expected at most %d games per request, got %d", maxGamesPerRequest, len(ids))
+		}
+		gamesHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	// Every game appears twice, as clips of the same game often do
+	var gameIDs []string
+	for i := 0; i < 300; i++ {
+		gameIDs = append(gameIDs, strconv.Itoa(i%150))
+	}
+	games, _ := twitch.GetGamesByID(gameIDs)
+	if requests != 2 {
+		t.Errorf("Games not deduplicated and requested in batches of 100, expected 2 requests got %d", requests)
+	}
+	if len(games) != 150 {
+		t.Errorf("Games not correctly returned by GetGamesByID, expected 150 got %d", len(games))
+	}
+	ts.Close()
+}
+
+func gamesHandler(w http.ResponseWriter, r *http.Request) {
+	res := GamesResponse{}
+	for _, id := range r.URL.Query()["id"] {
+		res.Data = append(res.Data, Game{ID: id, Name: "Game " + id, BoxArtURL: "https://some.fancy/art-{width}x{height}.jpg"})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestGameBoxArt(t *testing.T) {
+	game := Game{BoxArtURL: "https://some.fancy/art-{width}x{height}.jpg"}
+
+	expected := "https://some.fancy/art-144x192.jpg"
+	if boxArt := game.BoxArt(144, 192); boxArt != expected {
+		t.Errorf("Box art URL not properly built: expected \"%s\" got %s", expected, boxArt)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPrefix is the command prefix used by guilds that haven't set their own
+const defaultPrefix = "!clips"
+
+// configKeys are the settings that can be changed with "!clips config set", in the order they are listed
+var configKeys = []string{"prefix", "period", "top", "channels", "locale", "timezone", "embeds"}
+
+var periodRegex = regexp.MustCompile(`^\d+(d|m|y)$`)
+
+// LocaleFormat describes how dates and numbers are written in a locale
+type LocaleFormat struct {
+	DateLayout         string
+	ThousandsSeparator string
+}
+
+// locales are the locales a guild can choose from
+var locales = map[string]LocaleFormat{
+	"en": {DateLayout: "2006-01-02", ThousandsSeparator: ","},
+	"es": {DateLayout: "02/01/2006", ThousandsSeparator: "."},
+	"de": {DateLayout: "02.01.2006", ThousandsSeparator: "."},
+	"fr": {DateLayout: "02/01/2006", ThousandsSeparator: " "},
+	"pt": {DateLayout: "02/01/2006", ThousandsSeparator: "."},
+}
+
+// Date formats t as a date
+func (lf LocaleFormat) Date(t time.Time) string {
+	return t.Format(lf.DateLayout)
+}
+
+// Number formats n with its thousands separated
+func (lf LocaleFormat) Number(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + lf.ThousandsSeparator + digits[i:]
+	}
+	return sign + digits
+}
+
+// GuildConfig holds the settings of a Discord guild
+type GuildConfig struct {
+	Prefix string `json:"prefix"`
+	// Period is how far back searches go when no dates are given, like "7d"
+	Period string `json:"period"`
+	// Top is how many clips "topN" returns when N is left out
+	Top int `json:"top"`
+	// Channels are the only channels the bot answers in, or every channel if empty
+	Channels []string `json:"channels"`
+	Locale   string   `json:"locale"`
+	Timezone string   `json:"timezone"`
+	// Embeds is whether replies are sent as embeds or as plain text
+	Embeds bool `json:"embeds"`
+	// Permissions are the subcommands whose permissions were changed from their defaults
+	Permissions map[string]Permission `json:"permissions,omitempty"`
+}
+
+// DefaultGuildConfig returns the settings of a guild that hasn't configured the bot
+func DefaultGuildConfig() GuildConfig {
+	return GuildConfig{
+		Prefix:   defaultPrefix,
+		Period:   "7d",
+		Top:      10,
+		Locale:   "en",
+		Timezone: "UTC",
+		Embeds:   true,
+	}
+}
+
+// Set validates value and assigns it to the setting called key. Channels are given as their IDs.
+func (gc *GuildConfig) Set(key string, value string) error {
+	switch key {
+	case "prefix":
+		if value == "" || strings.ContainsAny(value, " \t\n") {
+			return errors.New("config: prefix must be a single word like \"!clips\"")
+		}
+		gc.Prefix = value
+	case "period":
+		if !periodRegex.MatchString(value) {
+			return errors.New("config: period must be a number of days, months or years like \"7d\"")
+		}
+		if _, _, _, err := parseSimpleDate(value); err != nil {
+			return errors.New("config: period can be at most " + strconv.Itoa(maxSimpleDateYears) + " years, like \"" + strconv.Itoa(maxSimpleDateYears) + "y\"")
+		}
+		gc.Period = value
+	case "top":
+		top, err := strconv.Atoi(value)
+		if err != nil || top < 1 || top > 100 {
+			return errors.New("config: top must be a number between 1 and 100")
+		}
+		gc.Top = top
+	case "channels":
+		if value == "" || value == "all" {
+			gc.Channels = nil
+			return nil
+		}
+		gc.Channels = strings.Fields(value)
+	case "locale":
+		if _, ok := locales[value]; !ok {
+			return errors.New("config: locale must be one of " + strings.Join(localeNames(), ", "))
+		}
+		gc.Locale = value
+	case "timezone":
+		if _, err := time.LoadLocation(value); err != nil {
+			return errors.New("config: unknown timezone \"" + value + "\"")
+		}
+		gc.Timezone = value
+	case "embeds":
+		embeds, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("config: embeds must be \"true\" or \"false\"")
+		}
+		gc.Embeds = embeds
+	default:
+		return errors.New("config: unknown setting \"" + key + "\", settings are " + strings.Join(configKeys, ", "))
+	}
+	return nil
+}
+
+// Get returns the value of the setting called key as it would be passed to Set
+func (gc GuildConfig) Get(key string) (string, error) {
+	switch key {
+	case "prefix":
+		return gc.Prefix, nil
+	case "period":
+		return gc.Period, nil
+	case "top":
+		return strconv.Itoa(gc.Top), nil
+	case "channels":
+		if len(gc.Channels) == 0 {
+			return "all", nil
+		}
+		return strings.Join(gc.Channels, " "), nil
+	case "locale":
+		return gc.Locale, nil
+	case "timezone":
+		return gc.Timezone, nil
+	case "embeds":
+		return strconv.FormatBool(gc.Embeds), nil
+	}
+	return "", errors.New("config: unknown setting \"" + key + "\", settings are " + strings.Join(configKeys, ", "))
+}
+
+// AllowsChannel reports whether the bot answers commands in channelID
+func (gc GuildConfig) AllowsChannel(channelID string) bool {
+	return len(gc.Channels) == 0 || containsString(gc.Channels, channelID)
+}
+
+// PeriodStart returns when searches without dates start, a week ago if the period can't be used
+func (gc GuildConfig) PeriodStart() time.Time {
+	start, _, matched, err := parseSimpleDate(gc.Period)
+	if err != nil || matched == "" {
+		return time.Now().AddDate(0, 0, -7)
+	}
+	return start
+}
+
+// Format returns how dates and numbers are written in the guild's locale
+func (gc GuildConfig) Format() LocaleFormat {
+	if format, ok := locales[gc.Locale]; ok {
+		return format
+	}
+	return locales["en"]
+}
+
+// Location returns the guild's timezone
+func (gc GuildConfig) Location() *time.Location {
+	location, err := time.LoadLocation(gc.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+func localeNames() []string {
+	var names []string
+	for name := range locales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigStore persists the settings of every guild. It is safe for concurrent use.
+type ConfigStore struct {
+	mu     sync.Mutex
+	path   string
+	Guilds map[string]GuildConfig `json:"guilds"`
+}
+
+// OpenConfigStore loads the guild settings stored in path
+func OpenConfigStore(path string) (*ConfigStore, error) {
+	store := &ConfigStore{path: path}
+	if err := loadJSON(path, store); err != nil {
+		return nil, err
+	}
+	if store.Guilds == nil {
+		store.Guilds = make(map[string]GuildConfig)
+	}
+	return store, nil
+}
+
+// Get returns the settings of a guild, or the defaults if it has none
+func (cs *ConfigStore) Get(guildID string) GuildConfig {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if config, ok := cs.Guilds[guildID]; ok {
+		return config
+	}
+	return DefaultGuildConfig()
+}
+
+// GuildIDs returns the guilds with settings
+func (cs *ConfigStore) GuildIDs() []string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var guildIDs []string
+	for guildID := range cs.Guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	return guildIDs
+}
+
+// Set changes a single setting of a guild, returning its updated settings
+func (cs *ConfigStore) Set(guildID string, key string, value string) (GuildConfig, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	config, ok := cs.Guilds[guildID]
+	if !ok {
+		config = DefaultGuildConfig()
+	}
+	if err := config.Set(key, value); err != nil {
+		return GuildConfig{}, err
+	}
+	cs.Guilds[guildID] = config
+	return config, cs.save()
+}
+
+func (cs *ConfigStore) save() error {
+	return saveJSON(cs.path, cs)
+}
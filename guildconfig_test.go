@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGuildConfigSet(t *testing.T) {
+	config := DefaultGuildConfig()
+	valid := map[string]string{
+		"prefix":   "!c",
+		"period":   "30d",
+		"top":      "5",
+		"channels": "1 2",
+		"locale":   "es",
+		"timezone": "Europe/Madrid",
+		"embeds":   "false",
+	}
+	for key, value := range valid {
+		if err := config.Set(key, value); err != nil {
+			t.Errorf("Setting %s to %s should be valid, got %s", key, value, err)
+		}
+		if got, _ := config.Get(key); got != value {
+			t.Errorf("Setting %s not properly set: expected %s got %s", key, value, got)
+		}
+	}
+
+	invalid := map[string]string{
+		"prefix":   "two words",
+		"period":   "a week",
+		"top":      "0",
+		"locale":   "xx",
+		"timezone": "Mars/Olympus",
+		"embeds":   "maybe",
+		"unknown":  "value",
+	}
+	for key, value := range invalid {
+		if err := config.Set(key, value); err == nil {
+			t.Errorf("Setting %s to %s should be invalid", key, value)
+		}
+	}
+
+	for _, period := range []string{"99999999999999999999d", "11y", "121m"} {
+		if err := config.Set("period", period); err == nil {
+			t.Errorf("Setting period to %s should be invalid", period)
+		}
+	}
+
+	config.Set("channels", "all")
+	if !config.AllowsChannel("3") {
+		t.Errorf("Every channel should be allowed after setting channels to all")
+	}
+}
+
+func TestGuildConfigAllowsChannel(t *testing.T) {
+	config := DefaultGuildConfig()
+	if !config.AllowsChannel("1") {
+		t.Errorf("Every channel should be allowed by default")
+	}
+
+	config.Channels = []string{"1", "2"}
+	if !config.AllowsChannel("2") || config.AllowsChannel("3") {
+		t.Errorf("Only the configured channels should be allowed, got %v", config.Channels)
+	}
+}
+
+func TestGuildConfigPeriodStart(t *testing.T) {
+	config := DefaultGuildConfig()
+	config.Period = "1m"
+
+	expected := time.Now().AddDate(0, -1, 0)
+	if start := config.PeriodStart(); expected.Sub(start) > time.Minute || start.Sub(expected) > time.Minute {
+		t.Errorf("PeriodStart not properly computed: expected %s got %s", expected, start)
+	}
+}
+
+func TestGuildConfigPeriodStartInvalid(t *testing.T) {
+	// Settings saved before periods were bounded may still hold one that can't be used
+	config := DefaultGuildConfig()
+	config.Period = "99999999999999999999d"
+
+	expected := time.Now().AddDate(0, 0, -7)
+	if start := config.PeriodStart(); expected.Sub(start) > time.Minute || start.Sub(expected) > time.Minute {
+		t.Errorf("PeriodStart should fall back to a week, expected %s got %s", expected, start)
+	}
+}
+
+func TestLocaleFormat(t *testing.T) {
+	numbers := map[int]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1.000",
+		1234567:  "1.234.567",
+		-12345:   "-12.345",
+		12345678: "12.345.678",
+	}
+	for n, expected := range numbers {
+		if got := locales["es"].Number(n); got != expected {
+			t.Errorf("Number not properly formatted: expected %s got %s", expected, got)
+		}
+	}
+
+	date := time.Date(2020, 3, 14, 0, 0, 0, 0, time.UTC)
+	if got := locales["de"].Date(date); got != "14.03.2020" {
+		t.Errorf("Date not properly formatted: expected 14.03.2020 got %s", got)
+	}
+}
+
+func TestConfigStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+
+	store, err := OpenConfigStore(path)
+	if err != nil {
+		t.Fatalf("Got an error opening the config store: %s", err)
+	}
+	if config := store.Get("guild"); config.Prefix != defaultPrefix || config.Top != 10 || !config.Embeds {
+		t.Errorf("Unconfigured guilds should get the defaults, got %v", config)
+	}
+
+	if _, err := store.Set("guild", "top", "25"); err != nil {
+		t.Errorf("Got an error setting top: %s", err)
+	}
+	if _, err := store.Set("guild", "top", "many"); err == nil {
+		t.Errorf("Setting top to an invalid value should fail")
+	}
+
+	reopened, _ := OpenConfigStore(path)
+	if config := reopened.Get("guild"); config.Top != 25 || config.Prefix != defaultPrefix {
+		t.Errorf("Settings not correctly persisted, expected top 25 with the default prefix got %v", config)
+	}
+	if config := reopened.Get("other-guild"); config.Top != 10 {
+		t.Errorf("Settings should be per guild, expected top 10 got %d", config.Top)
+	}
+}
@@ -0,0 +1,989 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// typingInterval is how often the typing indicator is renewed while a command runs, as Discord shows it for 10 seconds
+const typingInterval = 8 * time.Second
+
+// restartingReply tells users their command won't run because the bot is shutting down
+const restartingReply = "I'm restarting, please try again in a minute."
+
+// Outcomes of the commands handlers finish, used as the outcome label of commandsTotal
+const (
+	outcomeOK          = "ok"
+	outcomePartial     = "partial"
+	outcomeInvalid     = "invalid"
+	outcomeNotFound    = "not_found"
+	outcomeTwitchError = "twitch_error"
+	outcomeError       = "error"
+)
+
+func handleHelpCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, config GuildConfig) string {
+	help := `Search for Twitch clips.
+Usage: !clips subcommand streamer "title" creator start_date end_date
+Or: !clips streamer "title" creator start_date end_date export:csv|json
+Or: !clips clip url_or_slug
+Or: !clips vod url_or_video_id
+Or: !clips watch streamer min-views:N, !clips unwatch streamer, !clips watches
+Or: !clips digest add streamer every:week|month day:mon|1 at:18:00 tz:Europe/Madrid top:N, !clips digest list, !clips digest remove id
+Or: !clips live add streamer @role, !clips live list, !clips live remove streamer
+Or: !clips config get setting, !clips config set setting value
+Or: !clips permissions get command, !clips permissions set command roles|access|channels|deny value, !clips permissions reset command
+Required arguments:
+	- streamer: The name of the Twitch channel/streamer where to look for clips.
+Optional arguments:
+	- subcommand: Available subcommands are "topN", "stats", "clip", "vod", "watch", "unwatch", "watches", "digest", "live", "config", "permissions" and "help": "topN" returns the top N clips by view count for the given streamer, filtering by any other optional argument passed, "stats" summarizes the streamer's clips in the date range, "clip" looks up a single clip by its link or slug, "vod" lists the clips taken from a past broadcast in chronological order, "watch" posts new clips of the streamer in this channel once they have at least min-views views, "unwatch" stops posting them, "watches" lists the streamers watched in this server, "digest" schedules a post of the streamer's top clips of the week or month in this channel, "live" announces in this channel when the streamer goes live, mentioning the role if given, "config" shows or changes this server's settings (prefix, period, top, channels, locale, timezone and embeds) and requires the Manage Server permission, "permissions" shows or changes which roles can use each subcommand and in which channels, also requiring the Manage Server permission, "help" prints this message. Clip links posted in the channel are looked up automatically.
+	- title: Find a clip with a specific title. **Must** be enclosed in double quotes.
+	- creator: Filter by clips created by a specific user. If defined, **must** always come after streamer argument.
+	- start_date: Look for a clip created from this date onwards. Defaults to **1 week ago**. Format as YYYY-MM-DD. Will make things run faster if used.
+	- end_date: Look for a clip created before this date. Format as YYYY-MM-DD. Will make things run faster if used.
+	- export: Upload every clip that matches the search as a csv or json file instead of replying with a few of them.`
+	help = strings.Replace(help, "!clips", config.Prefix, -1)
+	help = strings.Replace(help, "**1 week ago**", "**"+config.Period+" ago**", 1)
+	sendReply(ctx, s, m, help)
+	return outcomeOK
+}
+
+func handleTopCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need at least the name of a streamer to look for clips!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+	targetClip := Clip{
+		BroadcasterID: broadcasters[0].ID,
+		Title:         c.Title,
+		StartedAt:     c.StartedAt,
+		EndedAt:       c.EndedAt,
+		CreatorName:   c.Creator,
+	}
+	if targetClip.StartedAt.IsZero() {
+		targetClip.StartedAt = config.PeriodStart()
+	}
+	matchFunc := matchMany(matchTitle, matchCreator)
+	results, err := twitch.FindMostPopularClips(targetClip, matchFunc, c.Top)
+	if err != nil {
+		messageLog(m).Warn("Couldn't walk clips", "broadcaster", targetClip.BroadcasterID, "err", err)
+	}
+
+	if len(results) == 0 {
+		sendReply(ctx, s, m, "Couldn't find any \""+c.Broadcaster+"\" clips. Check the streamer name and the date bounds.")
+		return lookupOutcome(err)
+	}
+
+	endedAt := c.EndedAt
+	if endedAt.IsZero() {
+		endedAt = time.Now()
+	}
+	format, location := config.Format(), config.Location()
+	msg := "Top " + strconv.Itoa(len(results)) + " " + c.Broadcaster + " clips from " + format.Date(targetClip.StartedAt.In(location)) + " to " + format.Date(endedAt.In(location)) + "\n"
+	for i, clip := range results {
+		msg = msg + "\t" + strconv.Itoa(i+1) + ". \"" + clip.Title + "\" by " + clip.CreatorName + ". Views: " + format.Number(clip.ViewCount) + "\n"
+	}
+
+	sendReply(ctx, s, m, msg)
+	return partialOutcome(err)
+}
+
+func handleStatsCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need at least the name of a streamer to compute stats!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+	startedAt, endedAt := c.StartedAt, c.EndedAt
+	if startedAt.IsZero() {
+		startedAt = config.PeriodStart()
+	}
+	if endedAt.IsZero() {
+		endedAt = time.Now()
+	}
+
+	location := config.Location()
+	stats := NewClipStats(startedAt.In(location), endedAt.In(location))
+	walkErr := twitch.WalkClips(broadcasters[0].ID, startedAt, c.EndedAt, func(clips []Clip) bool {
+		for _, clip := range clips {
+			stats.Add(clip)
+		}
+		return true
+	})
+	if walkErr != nil {
+		messageLog(m).Warn("Couldn't walk clips", "broadcaster", broadcasters[0].ID, "err", walkErr)
+	}
+
+	if stats.TotalClips == 0 {
+		sendReply(ctx, s, m, "Couldn't find any \""+c.Broadcaster+"\" clips. Check the streamer name and the date bounds.")
+		return lookupOutcome(walkErr)
+	}
+
+	var gameIDs []string
+	for _, c := range stats.TopGames(3) {
+		gameIDs = append(gameIDs, c.Value)
+	}
+	games, err := twitch.GetGamesByID(gameIDs)
+	if err != nil {
+		messageLog(m).Warn("Couldn't resolve games", "games", strings.Join(gameIDs, ","), "err", err)
+	}
+
+	replyEmbed(ctx, s, m, config, statsEmbed(broadcasters[0], stats, games, config.Format()))
+	return partialOutcome(walkErr)
+}
+
+func handleClipCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, clipIDs []string, config GuildConfig) string {
+	if len(clipIDs) == 0 {
+		sendReply(ctx, s, m, "I need a clip link or slug to look up!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	clips, err := twitch.GetClipsByID(clipIDs)
+	if err != nil {
+		sendReply(ctx, s, m, "I couldn't find that clip. Could you check the link and try again?")
+		return lookupOutcome(err)
+	}
+
+	videos := make(map[string]Video)
+	var videoIDs, gameIDs []string
+	for _, clip := range clips {
+		if clip.VideoID != "" {
+			videoIDs = append(videoIDs, clip.VideoID)
+		}
+		gameIDs = append(gameIDs, clip.GameID)
+	}
+	if len(videoIDs) > 0 {
+		found, err := twitch.GetVideosByID(videoIDs)
+		if err != nil {
+			messageLog(m).Warn("Couldn't find VODs", "videos", strings.Join(videoIDs, ","), "err", err)
+		}
+		for _, video := range found {
+			videos[video.ID] = video
+		}
+	}
+
+	games, err := twitch.GetGamesByID(gameIDs)
+	if err != nil {
+		messageLog(m).Warn("Couldn't resolve games", "games", strings.Join(gameIDs, ","), "err", err)
+	}
+
+	for _, clip := range clips {
+		replyEmbed(ctx, s, m, config, clipEmbed(clip, videos, games, config.Format()))
+	}
+	return outcomeOK
+}
+
+func handleVodCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.VideoID == "" {
+		sendReply(ctx, s, m, "I need a VOD link or ID to look for clips!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	videos, err := twitch.GetVideosByID([]string{c.VideoID})
+	if err != nil {
+		sendReply(ctx, s, m, "I couldn't find that VOD. Could you check the link and try again?")
+		return lookupOutcome(err)
+	}
+	video := videos[0]
+
+	clips, err := twitch.FindVODClips(video)
+	if err != nil || len(clips) == 0 {
+		sendReply(ctx, s, m, "Couldn't find any clips from \""+video.Title+"\".")
+		return lookupOutcome(err)
+	}
+
+	format := config.Format()
+	msg := strconv.Itoa(len(clips)) + " clips from \"" + video.Title + "\"\n"
+	for i, clip := range clips {
+		if i == maxVODClips {
+			msg = msg + "\t...and " + strconv.Itoa(len(clips)-maxVODClips) + " more\n"
+			break
+		}
+		offset := VODOffset(clip, video)
+		msg = msg + "\t" + formatOffset(offset) + " \"" + clip.Title + "\" by " + clip.CreatorName + ". Views: " + format.Number(clip.ViewCount) + " <" + VODURL(video, offset) + ">\n"
+	}
+
+	sendReply(ctx, s, m, msg)
+	return outcomeOK
+}
+
+func handleWatchCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need the name of a streamer to watch!"+helpHint(config))
+		return outcomeInvalid
+	}
+	minViews, err := c.IntOption("min-views", 0)
+	if err != nil || minViews < 0 {
+		sendReply(ctx, s, m, "min-views must be a positive number, like \"min-views:100\".")
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+
+	watch := Watch{
+		GuildID:         m.GuildID,
+		ChannelID:       m.ChannelID,
+		BroadcasterID:   broadcasters[0].ID,
+		BroadcasterName: broadcasters[0].Login,
+		MinViews:        minViews,
+	}
+	if err := Watches.Add(watch); err != nil {
+		messageLog(m).Error("Couldn't save watch", "broadcaster", watch.BroadcasterID, "err", err)
+		sendReply(ctx, s, m, "Something went wrong while saving the watch, please try again later.")
+		return outcomeError
+	}
+
+	sendReply(ctx, s, m, "I'll post new "+broadcasters[0].DisplayName+" clips in this channel once they reach "+config.Format().Number(minViews)+" views.")
+	return outcomeOK
+}
+
+func handleUnwatchCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need the name of a streamer to stop watching!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	removed, err := Watches.Remove(m.ChannelID, c.Broadcaster)
+	if err != nil {
+		messageLog(m).Error("Couldn't remove watch", "broadcaster", c.Broadcaster, "err", err)
+		sendReply(ctx, s, m, "Something went wrong while removing the watch, please try again later.")
+		return outcomeError
+	}
+	if !removed {
+		sendReply(ctx, s, m, "This channel isn't watching \""+c.Broadcaster+"\".")
+		return outcomeNotFound
+	}
+
+	sendReply(ctx, s, m, "I'll stop posting \""+c.Broadcaster+"\" clips in this channel.")
+	return outcomeOK
+}
+
+func handleWatchesCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, config GuildConfig) string {
+	watches := Watches.List(m.GuildID)
+	if len(watches) == 0 {
+		sendReply(ctx, s, m, "No streamers are being watched in this server. Use \""+config.Prefix+" watch streamer\" to start.")
+		return outcomeOK
+	}
+
+	format := config.Format()
+	msg := "Watched streamers:\n"
+	for _, w := range watches {
+		msg = msg + "\t" + w.BroadcasterName + " in <#" + w.ChannelID + "> with at least " + format.Number(w.MinViews) + " views\n"
+	}
+
+	sendReply(ctx, s, m, msg)
+	return outcomeOK
+}
+
+func handleDigestCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	switch c.Action {
+	case "add":
+		return handleDigestAddCommand(ctx, s, m, c, config)
+	case "list":
+		return handleDigestListCommand(ctx, s, m, config)
+	case "remove":
+		return handleDigestRemoveCommand(ctx, s, m, c, config)
+	default:
+		sendReply(ctx, s, m, "Use \""+config.Prefix+" digest add\", \""+config.Prefix+" digest list\" or \""+config.Prefix+" digest remove\"."+helpHint(config))
+		return outcomeInvalid
+	}
+}
+
+func handleDigestAddCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need the name of a streamer to post a digest for!"+helpHint(config))
+		return outcomeInvalid
+	}
+	top, err := c.IntOption("top", config.Top)
+	if err != nil || top < 1 {
+		sendReply(ctx, s, m, "top must be a positive number, like \"top:10\".")
+		return outcomeInvalid
+	}
+	schedule, err := ParseSchedule(c.Options, config.Timezone)
+	if err != nil {
+		sendReply(ctx, s, m, "I couldn't understand that schedule: "+strings.TrimPrefix(err.Error(), "digest: ")+".")
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+
+	digest, err := Digests.Add(Digest{
+		GuildID:         m.GuildID,
+		ChannelID:       m.ChannelID,
+		BroadcasterID:   broadcasters[0].ID,
+		BroadcasterName: broadcasters[0].Login,
+		Top:             top,
+		Schedule:        schedule,
+	})
+	if err != nil {
+		messageLog(m).Error("Couldn't save digest", "broadcaster", broadcasters[0].ID, "err", err)
+		sendReply(ctx, s, m, "Something went wrong while saving the digest, please try again later.")
+		return outcomeError
+	}
+
+	sendReply(ctx, s, m, "Digest "+digest.ID+" will post the top "+strconv.Itoa(top)+" "+broadcasters[0].DisplayName+" clips in this channel "+schedule.String()+".")
+	return outcomeOK
+}
+
+func handleDigestListCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, config GuildConfig) string {
+	digests := Digests.List(m.GuildID)
+	if len(digests) == 0 {
+		sendReply(ctx, s, m, "There are no digests in this server. Use \""+config.Prefix+" digest add streamer\" to create one.")
+		return outcomeOK
+	}
+
+	msg := "Digests:\n"
+	for _, d := range digests {
+		msg = msg + "\t" + d.ID + ". Top " + strconv.Itoa(d.Top) + " " + d.BroadcasterName + " clips in <#" + d.ChannelID + "> " + d.Schedule.String() + "\n"
+	}
+
+	sendReply(ctx, s, m, msg)
+	return outcomeOK
+}
+
+func handleDigestRemoveCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	// The digest ID is parsed in the streamer position
+	removed, err := Digests.Remove(m.GuildID, c.Broadcaster)
+	if err != nil {
+		messageLog(m).Error("Couldn't remove digest", "digest", c.Broadcaster, "err", err)
+		sendReply(ctx, s, m, "Something went wrong while removing the digest, please try again later.")
+		return outcomeError
+	}
+	if !removed {
+		sendReply(ctx, s, m, "There's no digest \""+c.Broadcaster+"\" in this server. Use \""+config.Prefix+" digest list\" to see them.")
+		return outcomeNotFound
+	}
+
+	sendReply(ctx, s, m, "Digest "+c.Broadcaster+" removed.")
+	return outcomeOK
+}
+
+func handleLiveCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	switch c.Action {
+	case "add":
+		return handleLiveAddCommand(ctx, s, m, c, config)
+	case "list":
+		return handleLiveListCommand(ctx, s, m, config)
+	case "remove":
+		return handleLiveRemoveCommand(ctx, s, m, c, config)
+	default:
+		sendReply(ctx, s, m, "Use \""+config.Prefix+" live add\", \""+config.Prefix+" live list\" or \""+config.Prefix+" live remove\"."+helpHint(config))
+		return outcomeInvalid
+	}
+}
+
+func handleLiveAddCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need the name of a streamer to announce!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+
+	subscription := LiveSubscription{
+		GuildID:         m.GuildID,
+		ChannelID:       m.ChannelID,
+		BroadcasterID:   broadcasters[0].ID,
+		BroadcasterName: broadcasters[0].Login,
+	}
+	if len(c.Roles) > 0 {
+		subscription.RoleID = c.Roles[0]
+	}
+	if err := Live.Add(subscription); err != nil {
+		messageLog(m).Error("Couldn't save live subscription", "broadcaster", subscription.BroadcasterID, "err", err)
+		sendReply(ctx, s, m, "Something went wrong while saving the subscription, please try again later.")
+		return outcomeError
+	}
+
+	if EventSubCallback != "" {
+		go func() {
+			if err := Twitch.SubscribeStreamOnline([]string{subscription.BroadcasterID}, EventSubCallback, EventSubSecret); err != nil {
+				messageLog(m).Error("Couldn't subscribe to stream.online", "broadcaster", subscription.BroadcasterID, "err", err)
+			}
+		}()
+	}
+
+	sendReply(ctx, s, m, "I'll announce in this channel when "+broadcasters[0].DisplayName+" goes live.")
+	return outcomeOK
+}
+
+func handleLiveListCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, config GuildConfig) string {
+	subscriptions := Live.List(m.GuildID)
+	if len(subscriptions) == 0 {
+		sendReply(ctx, s, m, "No go-live announcements in this server. Use \""+config.Prefix+" live add streamer\" to create one.")
+		return outcomeOK
+	}
+
+	msg := "Go-live announcements:\n"
+	for _, ls := range subscriptions {
+		msg = msg + "\t" + ls.BroadcasterName + " in <#" + ls.ChannelID + ">"
+		if ls.RoleID != "" {
+			msg = msg + " mentioning <@&" + ls.RoleID + ">"
+		}
+		msg = msg + "\n"
+	}
+
+	sendReply(ctx, s, m, msg)
+	return outcomeOK
+}
+
+func handleLiveRemoveCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if c.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need the name of a streamer to stop announcing!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	removed, err := Live.Remove(m.GuildID, c.Broadcaster)
+	if err != nil {
+		messageLog(m).Error("Couldn't remove live subscription", "broadcaster", c.Broadcaster, "err", err)
+		sendReply(ctx, s, m, "Something went wrong while removing the subscription, please try again later.")
+		return outcomeError
+	}
+	if !removed {
+		sendReply(ctx, s, m, "This server isn't announcing \""+c.Broadcaster+"\".")
+		return outcomeNotFound
+	}
+
+	sendReply(ctx, s, m, "I'll stop announcing when \""+c.Broadcaster+"\" goes live.")
+	return outcomeOK
+}
+
+func handleConfigCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	switch c.Action {
+	case "get":
+		return handleConfigGetCommand(s, m, c, config)
+	case "set":
+		return handleConfigSetCommand(s, m, c, config)
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Use \""+config.Prefix+" config get setting\" or \""+config.Prefix+" config set setting value\". Settings are "+strings.Join(configKeys, ", ")+".")
+		return outcomeInvalid
+	}
+}
+
+func handleConfigGetCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	keys := configKeys
+	if len(c.Args) > 0 {
+		keys = c.Args[:1]
+	}
+
+	msg := "Settings:\n"
+	for _, key := range keys {
+		value, err := config.Get(key)
+		if err != nil {
+			s.ChannelMessageSend(m.ChannelID, strings.TrimPrefix(err.Error(), "config: ")+".")
+			return outcomeInvalid
+		}
+		if key == "channels" && len(config.Channels) > 0 {
+			value = "<#" + strings.Join(config.Channels, "> <#") + ">"
+		}
+		msg = msg + "\t" + key + ": " + value + "\n"
+	}
+
+	s.ChannelMessageSend(m.ChannelID, msg)
+	return outcomeOK
+}
+
+func handleConfigSetCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if len(c.Args) < 2 {
+		s.ChannelMessageSend(m.ChannelID, "I need a setting and its value, like \""+config.Prefix+" config set period 30d\". Settings are "+strings.Join(configKeys, ", ")+".")
+		return outcomeInvalid
+	}
+	key, value := c.Args[0], strings.Join(c.Args[1:], " ")
+	if key == "channels" {
+		if _, _, channels := parseMentions(c.Args[1:]); len(channels) > 0 {
+			value = strings.Join(channels, " ")
+		}
+	}
+
+	config, err := Configs.Set(m.GuildID, key, value)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "config: ") {
+			s.ChannelMessageSend(m.ChannelID, strings.TrimPrefix(err.Error(), "config: ")+".")
+			return outcomeInvalid
+		}
+		messageLog(m).Error("Couldn't save setting", "setting", key, "err", err)
+		s.ChannelMessageSend(m.ChannelID, "Something went wrong while saving the setting, please try again later.")
+		return outcomeError
+	}
+
+	value, _ = config.Get(key)
+	s.ChannelMessageSend(m.ChannelID, "Set "+key+" to "+value+".")
+	return outcomeOK
+}
+
+func handlePermissionsCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	switch c.Action {
+	case "get":
+		return handlePermissionsGetCommand(s, m, c, config)
+	case "set":
+		return handlePermissionsSetCommand(s, m, c, config)
+	case "reset":
+		return handlePermissionsResetCommand(s, m, c, config)
+	default:
+		s.ChannelMessageSend(m.ChannelID, "Use \""+config.Prefix+" permissions get\", \""+config.Prefix+" permissions set command rule value\" or \""+config.Prefix+" permissions reset command\"."+helpHint(config))
+		return outcomeInvalid
+	}
+}
+
+func handlePermissionsGetCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	subCommands := permissionSubCommands
+	if len(c.Args) > 0 {
+		if !containsString(permissionSubCommands, c.Args[0]) {
+			s.ChannelMessageSend(m.ChannelID, "There's no command \""+c.Args[0]+"\". Commands are "+strings.Join(permissionSubCommands, ", ")+".")
+			return outcomeInvalid
+		}
+		subCommands = c.Args[:1]
+	}
+
+	msg := "Permissions:\n"
+	for _, subCommand := range subCommands {
+		msg = msg + "\t" + subCommand + ": " + config.Permission(subCommand).String() + "\n"
+	}
+
+	s.ChannelMessageSend(m.ChannelID, msg)
+	return outcomeOK
+}
+
+func handlePermissionsSetCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if len(c.Args) < 3 {
+		s.ChannelMessageSend(m.ChannelID, "I need a command, a rule and its value, like \""+config.Prefix+" permissions set top roles @role\". Rules are roles, access, channels and deny.")
+		return outcomeInvalid
+	}
+	subCommand, key, values := c.Args[0], c.Args[1], c.Args[2:]
+	if _, roles, channels := parseMentions(values); key == "roles" {
+		values = roles
+	} else if len(channels) > 0 {
+		values = channels
+	}
+
+	permission, err := Configs.SetPermission(m.GuildID, subCommand, key, values)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "permission: ") {
+			s.ChannelMessageSend(m.ChannelID, strings.TrimPrefix(err.Error(), "permission: ")+".")
+			return outcomeInvalid
+		}
+		messageLog(m).Error("Couldn't save permission", "permission", subCommand, "err", err)
+		s.ChannelMessageSend(m.ChannelID, "Something went wrong while saving the permission, please try again later.")
+		return outcomeError
+	}
+
+	s.ChannelMessageSend(m.ChannelID, "\""+subCommand+"\" can now be used "+permission.String()+".")
+	return outcomeOK
+}
+
+func handlePermissionsResetCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig) string {
+	if len(c.Args) == 0 || !containsString(permissionSubCommands, c.Args[0]) {
+		s.ChannelMessageSend(m.ChannelID, "I need the command to reset, one of "+strings.Join(permissionSubCommands, ", ")+".")
+		return outcomeInvalid
+	}
+
+	if err := Configs.ResetPermission(m.GuildID, c.Args[0]); err != nil {
+		messageLog(m).Error("Couldn't reset permission", "permission", c.Args[0], "err", err)
+		s.ChannelMessageSend(m.ChannelID, "Something went wrong while resetting the permission, please try again later.")
+		return outcomeError
+	}
+
+	s.ChannelMessageSend(m.ChannelID, "\""+c.Args[0]+"\" can now be used "+defaultPermission(c.Args[0]).String()+".")
+	return outcomeOK
+}
+
+func handleEventSubNotification(s *discordgo.Session, subscription EventSubSubscription, rawEvent json.RawMessage) {
+	if subscription.Type != "stream.online" {
+		return
+	}
+	event := StreamOnlineEvent{}
+	if err := json.Unmarshal(rawEvent, &event); err != nil {
+		Log.Warn("Couldn't decode stream.online event", "err", err)
+		return
+	}
+
+	// The event doesn't include the title or game, so look the stream up, falling back to what the event has
+	stream := Stream{ID: event.ID, UserID: event.BroadcasterUserID, UserLogin: event.BroadcasterUserLogin, UserName: event.BroadcasterUserName, StartedAt: event.StartedAt}
+	if streams, err := Twitch.GetStreamsByUserID([]string{event.BroadcasterUserID}); err == nil && len(streams) > 0 {
+		stream = streams[0]
+	}
+
+	Live.Online(stream, func(ls LiveSubscription, stream Stream) error {
+		return sendLiveAnnouncement(s, ls, stream)
+	})
+}
+
+func sendLiveAnnouncement(s *discordgo.Session, ls LiveSubscription, stream Stream) error {
+	content := stream.UserName + " is live!"
+	if ls.RoleID != "" {
+		content = "<@&" + ls.RoleID + "> " + content
+	}
+	if !Configs.Get(ls.GuildID).Embeds {
+		_, err := s.ChannelMessageSend(ls.ChannelID, content+"\n"+embedText(liveEmbed(stream)))
+		return err
+	}
+	_, err := s.ChannelMessageSendComplex(ls.ChannelID, &discordgo.MessageSend{Content: content, Embed: liveEmbed(stream)})
+	return err
+}
+
+// sendEmbed sends embed to a channel, or its plain text version if the guild turned embeds off
+func sendEmbed(s *discordgo.Session, channelID string, config GuildConfig, embed *discordgo.MessageEmbed) error {
+	if !config.Embeds {
+		_, err := s.ChannelMessageSend(channelID, embedText(embed))
+		return err
+	}
+	_, err := s.ChannelMessageSendEmbed(channelID, embed)
+	return err
+}
+
+// sendReply sends content to the channel m was sent to, unless ctx was cancelled, as its author was then told the bot
+// is restarting instead
+func sendReply(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, content string) {
+	if ctx.Err() != nil {
+		return
+	}
+	s.ChannelMessageSend(m.ChannelID, content)
+}
+
+// replyEmbed sends embed to the channel m was sent to like sendEmbed, unless ctx was cancelled
+func replyEmbed(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, config GuildConfig, embed *discordgo.MessageEmbed) {
+	if ctx.Err() != nil {
+		return
+	}
+	sendEmbed(s, m.ChannelID, config, embed)
+}
+
+// canManageServer reports whether the author of m has the Manage Server permission in the channel it was sent to
+func canManageServer(s *discordgo.Session, m *discordgo.MessageCreate) bool {
+	permissions, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err != nil {
+		messageLog(m).Warn("Couldn't get the permissions of the author", "err", err)
+		return false
+	}
+	return permissions&(discordgo.PermissionManageServer|discordgo.PermissionAdministrator) != 0
+}
+
+// checkPermission returns why the author of m can't use subCommand in the channel it was sent to, or nil if they can
+func checkPermission(s *discordgo.Session, m *discordgo.MessageCreate, config GuildConfig, subCommand string) error {
+	var roles []string
+	if m.Member != nil {
+		roles = m.Member.Roles
+	}
+	return config.Permission(subCommand).Check(m.ChannelID, roles, func() bool {
+		return canManageServer(s, m)
+	})
+}
+
+// allowCommand charges the estimated cost of a command to the author of m and their guild, returning the cost and
+// whether they can afford it. If reply is set, they're told why not.
+func allowCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command, config GuildConfig, reply bool) (int, bool) {
+	cost := EstimateCost(c, config.PeriodStart(), time.Now())
+	wait, err := Limits.Allow(m.GuildID, m.Author.ID, cost)
+	switch {
+	case err == ErrOverBudget:
+		messageLog(m).Info("Rejected command over budget", "command", c.SubCommand, "err", err)
+		commandsTotal.Inc(c.Name(), "over_budget")
+		if reply {
+			s.ChannelMessageSend(m.ChannelID, "That search covers too much time for me to go through, could you try a shorter date range?")
+		}
+		return 0, false
+	case wait > 0:
+		messageLog(m).Info("Rate limited command", "command", c.SubCommand, "wait", wait)
+		commandsTotal.Inc(c.Name(), "rate_limited")
+		rateLimitWait.Observe(wait.Seconds())
+		if reply {
+			s.ChannelMessageSend(m.ChannelID, "I'm a bit busy right now, try again in "+strconv.Itoa(int(math.Ceil(wait.Seconds())))+" seconds.")
+		}
+		return 0, false
+	}
+	return cost, true
+}
+
+// messageLog returns a logger that tags its lines with where a message was sent and who sent it
+func messageLog(m *discordgo.MessageCreate) *Logger {
+	return Log.With("guild", m.GuildID, "channel", m.ChannelID, "user", m.Author.ID, "message", m.ID)
+}
+
+// lookupOutcome returns the outcome of a command that found nothing, either because Twitch failed with err or,
+// if err is nil or one of Twitch's not found errors, because there was nothing to find
+func lookupOutcome(err error) string {
+	if err == nil || err == ErrNoBroadcasters || err == ErrNoClips || err == ErrNoVideos {
+		return outcomeNotFound
+	}
+	return outcomeTwitchError
+}
+
+// partialOutcome returns the outcome of a command that replied with what it found before Twitch failed with err, if
+// it did
+func partialOutcome(err error) string {
+	if err != nil {
+		return outcomePartial
+	}
+	return outcomeOK
+}
+
+func helpHint(config GuildConfig) string {
+	return " Use \"" + config.Prefix + " help\" for more info."
+}
+
+func handleCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author.ID == s.State.User.ID {
+		return
+	}
+	config := Configs.Get(m.GuildID)
+	if !strings.HasPrefix(m.Content, config.Prefix) {
+		if !config.AllowsChannel(m.ChannelID) {
+			return
+		}
+		if clipIDs := FindClipLinks(m.Content); len(clipIDs) > 0 {
+			if checkPermission(s, m, config, "clip") != nil {
+				commandsTotal.Inc("clip", "denied")
+				return
+			}
+			cost, ok := allowCommand(s, m, Command{SubCommand: "clip"}, config, false)
+			if !ok {
+				return
+			}
+			if len(clipIDs) > maxClipLinks {
+				clipIDs = clipIDs[:maxClipLinks]
+			}
+			messageLog(m).Info("Found clip links", "clips", strings.Join(clipIDs, ","))
+			submitCommand(s, m, "clip", cost, false, func(ctx context.Context) string {
+				return handleClipCommand(ctx, s, m, clipIDs, config)
+			})
+		}
+		return
+	}
+	messageLog(m).Debug("Got command", "content", m.Content)
+	command, err := ParseGuildCommand(m.Content, config)
+	if err != nil {
+		messageLog(m).Info("Couldn't parse command", "content", m.Content, "err", err)
+		if config.AllowsChannel(m.ChannelID) {
+			s.ChannelMessageSend(m.ChannelID, "Sorry, "+strings.TrimPrefix(err.Error(), "command: ")+"."+helpHint(config))
+		}
+		return
+	}
+	if rawSubCommands[command.SubCommand] {
+		// Settings are only for managers, who can change them from any channel so they can't lock themselves out
+		if !canManageServer(s, m) {
+			commandsTotal.Inc(command.SubCommand, "denied")
+			if config.AllowsChannel(m.ChannelID) {
+				s.ChannelMessageSend(m.ChannelID, "Only members with the Manage Server permission can change my settings.")
+			}
+			return
+		}
+		start := time.Now()
+		var outcome string
+		if command.SubCommand == "config" {
+			outcome = handleConfigCommand(s, m, command, config)
+		} else {
+			outcome = handlePermissionsCommand(s, m, command, config)
+		}
+		commandsTotal.Inc(command.SubCommand, outcome)
+		commandDuration.ObserveDuration(start, command.SubCommand)
+		return
+	}
+	if !config.AllowsChannel(m.ChannelID) {
+		return
+	}
+	subCommand := command.Name()
+	if err := checkPermission(s, m, config, subCommand); err != nil {
+		commandsTotal.Inc(subCommand, "denied")
+		s.ChannelMessageSend(m.ChannelID, "Sorry, "+strings.TrimPrefix(err.Error(), "permission: ")+".")
+		return
+	}
+	cost, ok := allowCommand(s, m, command, config, true)
+	if !ok {
+		return
+	}
+	submitCommand(s, m, subCommand, cost, true, func(ctx context.Context) string {
+		return dispatchCommand(ctx, s, m, command, config)
+	})
+}
+
+// submitCommand queues a command to run on the worker pool, showing the bot typing while it runs, and counts it with
+// the outcome run returns. If the bot shuts down before the command finishes, the context passed to run is cancelled
+// and the command is counted as cancelled instead. Commands the queue rejects get their cost refunded. If reply is
+// set, the author of m is told when the queue is full or the bot is restarting.
+func submitCommand(s *discordgo.Session, m *discordgo.MessageCreate, subCommand string, cost int, reply bool, run func(ctx context.Context) string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Whichever of the command finishing and Shutdown cancelling it comes first counts it and has the last word
+	var settle sync.Once
+	queued := Queue.SubmitCancelable(m.GuildID, func() {
+		defer cancel()
+		start := time.Now()
+		// Stays an error if run panics, the queue recovers and logs the panic itself
+		outcome := outcomeError
+		defer settle.Do(func() {
+			commandsTotal.Inc(subCommand, outcome)
+			commandDuration.ObserveDuration(start, subCommand)
+		})
+		whileTyping(s, m.ChannelID, func() {
+			outcome = run(ctx)
+		})
+	}, func() {
+		cancel()
+		settle.Do(func() {
+			messageLog(m).Warn("Cancelled command at shutdown", "command", subCommand)
+			commandsTotal.Inc(subCommand, "cancelled")
+			if reply {
+				s.ChannelMessageSend(m.ChannelID, restartingReply)
+			}
+		})
+	})
+	if !queued {
+		cancel()
+		Limits.Refund(m.GuildID, m.Author.ID, cost)
+	}
+	if !queued && Queue.Closed() {
+		commandsTotal.Inc(subCommand, "shutting_down")
+		if reply {
+			s.ChannelMessageSend(m.ChannelID, restartingReply)
+		}
+		return
+	}
+	if !queued {
+		messageLog(m).Warn("Work queue full, dropped command")
+		commandsTotal.Inc(subCommand, "queue_full")
+		if reply {
+			s.ChannelMessageSend(m.ChannelID, "I'm handling too many searches right now, try again in a moment.")
+		}
+	}
+}
+
+// whileTyping shows the bot typing in a channel until work returns
+func whileTyping(s *discordgo.Session, channelID string, work func()) {
+	s.ChannelTyping(channelID)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.ChannelTyping(channelID)
+			}
+		}
+	}()
+
+	defer close(done)
+	work()
+}
+
+func dispatchCommand(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, command Command, config GuildConfig) string {
+	messageLog(m).Info("Running command", "command", command.SubCommand)
+	if _, ok := command.Options["export"]; ok && (command.SubCommand == "" || command.SubCommand == "top") {
+		return handleExportCommand(ctx, s, m, command, config)
+	}
+	switch command.SubCommand {
+	case "help":
+		return handleHelpCommand(ctx, s, m, config)
+	case "top":
+		return handleTopCommand(ctx, s, m, command, config)
+	case "stats":
+		return handleStatsCommand(ctx, s, m, command, config)
+	case "clip":
+		if command.ClipID == "" {
+			return handleClipCommand(ctx, s, m, nil, config)
+		}
+		return handleClipCommand(ctx, s, m, []string{command.ClipID}, config)
+	case "vod":
+		return handleVodCommand(ctx, s, m, command, config)
+	case "watch":
+		return handleWatchCommand(ctx, s, m, command, config)
+	case "unwatch":
+		return handleUnwatchCommand(ctx, s, m, command, config)
+	case "watches":
+		return handleWatchesCommand(ctx, s, m, config)
+	case "digest":
+		return handleDigestCommand(ctx, s, m, command, config)
+	case "live":
+		return handleLiveCommand(ctx, s, m, command, config)
+	}
+	if command.Broadcaster == "" {
+		sendReply(ctx, s, m, "I need at least the name of a streamer to look for clips!"+helpHint(config))
+		return outcomeInvalid
+	}
+
+	twitch := Twitch.WithContext(ctx)
+	broadcasters, err := twitch.GetBroadcastersByName([]string{command.Broadcaster})
+	if err != nil {
+		sendReply(ctx, s, m, "Couldn't find a streamer named \""+command.Broadcaster+"\". Could you check the name and try again?")
+		return lookupOutcome(err)
+	}
+	messageLog(m).Debug("Searching clips", "broadcaster", command.Broadcaster, "title", command.Title, "creator", command.Creator, "started_at", command.StartedAt, "ended_at", command.EndedAt)
+	targetClip := Clip{
+		BroadcasterID: broadcasters[0].ID,
+		Title:         command.Title,
+		StartedAt:     command.StartedAt,
+		EndedAt:       command.EndedAt,
+		CreatorName:   command.Creator,
+	}
+	if targetClip.StartedAt.IsZero() {
+		targetClip.StartedAt = config.PeriodStart()
+	}
+	result, err := twitch.FindBestClip(targetClip)
+	if err != nil {
+		messageLog(m).Warn("Couldn't walk clips", "broadcaster", targetClip.BroadcasterID, "err", err)
+	}
+
+	if result == targetClip {
+		sendReply(ctx, s, m, "I couldn't find a clip that matches your search.")
+		return lookupOutcome(err)
+	}
+
+	sendReply(ctx, s, m, "Found your clip: "+result.URL)
+	return partialOutcome(err)
+}
+
+func matchMany(funcs ...func(Clip, Clip) bool) func(Clip, Clip) bool {
+	return func(clip1, clip2 Clip) bool {
+		result := true
+		for _, f := range funcs {
+			result = result && f(clip1, clip2)
+		}
+
+		return result
+	}
+}
+
+func matchTitle(clip1, clip2 Clip) bool {
+	return strings.Contains(strings.ToLower(clip1.Title), strings.ToLower(clip2.Title))
+}
+
+func matchCreator(clip1, clip2 Clip) bool {
+	return strings.Contains(strings.ToLower(clip1.CreatorName), strings.ToLower(clip2.CreatorName))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// discordReconnectGrace is how long the Discord gateway can stay disconnected before the bot is considered
+	// wedged, as discordgo reconnects on its own
+	discordReconnectGrace = 5 * time.Minute
+	// twitchFailingAfter is how long Twitch requests can keep failing before the bot stops being ready
+	twitchFailingAfter = 5 * time.Minute
+	// queueStallAfter is how long the workers can all be busy without finishing a command before the bot is
+	// considered wedged. It's longer than the slowest searches, which page through a year of clips.
+	queueStallAfter = 10 * time.Minute
+)
+
+// HealthCheck tracks the state of the bot's connections to Discord and Twitch and of its work queue, to tell
+// orchestrators whether the bot is alive and ready. It is safe for concurrent use.
+type HealthCheck struct {
+	mu            sync.Mutex
+	shards        map[int]*shardHealth
+	twitchSuccess time.Time
+	twitchFailure time.Time
+	tokenRejected bool
+	shuttingDown  bool
+	queueStats    func() QueueStats
+	maxQueued     int
+	now           func() time.Time
+}
+
+// shardHealth is the state of the gateway connection of a shard
+type shardHealth struct {
+	connected bool
+	changed   time.Time
+}
+
+// HealthStatus is the body of the health endpoints
+type HealthStatus struct {
+	Status   string   `json:"status"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// NewHealthCheck returns a HealthCheck for the given Discord shards that reads the state of the work queue from
+// queueStats, which holds up to maxQueued commands. The shards start out disconnected.
+func NewHealthCheck(shardIDs []int, queueStats func() QueueStats, maxQueued int) *HealthCheck {
+	h := &HealthCheck{shards: make(map[int]*shardHealth), queueStats: queueStats, maxQueued: maxQueued, now: time.Now}
+	for _, id := range shardIDs {
+		h.shards[id] = &shardHealth{changed: h.now()}
+	}
+	return h
+}
+
+// SetShardConnected records a shard's gateway connection opening or closing
+func (h *HealthCheck) SetShardConnected(shardID int, connected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	shard, ok := h.shards[shardID]
+	if !ok {
+		shard = &shardHealth{}
+		h.shards[shardID] = shard
+	}
+	if connected != shard.connected || shard.changed.IsZero() {
+		shard.connected = connected
+		shard.changed = h.now()
+	}
+}
+
+// ObserveTwitchResponse records the outcome of a request to Twitch. Server errors and failed requests count as
+// failures, and a 401 means Twitch rejected the access token.
+func (h *HealthCheck) ObserveTwitchResponse(resp *http.Response, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case err != nil || resp == nil || resp.StatusCode >= 500:
+		h.twitchFailure = h.now()
+	case resp.StatusCode == http.StatusUnauthorized:
+		h.tokenRejected = true
+		h.twitchFailure = h.now()
+	case resp.StatusCode < 400:
+		h.tokenRejected = false
+		h.twitchSuccess = h.now()
+	default:
+		// Twitch answered, the request was just wrong
+		h.twitchSuccess = h.now()
+	}
+}
+
+// SetShuttingDown records the bot shutting down, so it stops being ready
+func (h *HealthCheck) SetShuttingDown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.shuttingDown = true
+}
+
+// Alive returns the problems a restart would fix: a Discord shard that didn't reconnect, a Twitch token
+// that was rejected and workers that stopped finishing commands
+func (h *HealthCheck) Alive() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var problems []string
+	now := h.now()
+	for _, id := range h.shardIDs() {
+		if shard := h.shards[id]; !shard.connected && now.Sub(shard.changed) > discordReconnectGrace {
+			problems = append(problems, "discord shard "+strconv.Itoa(id)+" disconnected since "+shard.changed.UTC().Format(time.RFC3339))
+		}
+	}
+	if h.tokenRejected {
+		problems = append(problems, "twitch rejected the access token")
+	}
+	stats := h.queueStats()
+	if stats.Queued > 0 && stats.Active >= stats.Workers && now.Sub(stats.LastCompleted) > queueStallAfter {
+		problems = append(problems, "work queue stalled since "+stats.LastCompleted.UTC().Format(time.RFC3339))
+	}
+	return problems
+}
+
+// Ready returns the problems keeping the bot from answering commands right now, which includes the ones that
+// make it not Alive
+func (h *HealthCheck) Ready() []string {
+	problems := h.Alive()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	if h.shuttingDown {
+		problems = append(problems, "shutting down")
+	}
+	for _, id := range h.shardIDs() {
+		if shard := h.shards[id]; !shard.connected && now.Sub(shard.changed) <= discordReconnectGrace {
+			problems = append(problems, "discord shard "+strconv.Itoa(id)+" disconnected")
+		}
+	}
+	if h.twitchFailure.After(h.twitchSuccess) && now.Sub(h.twitchSuccess) > twitchFailingAfter {
+		problems = append(problems, "twitch requests failing since "+h.twitchSuccess.UTC().Format(time.RFC3339))
+	}
+	if stats := h.queueStats(); stats.Queued >= h.maxQueued {
+		problems = append(problems, "work queue full")
+	}
+	return problems
+}
+
+// shardIDs returns the IDs of the shards in order, so problems are always listed the same way
+func (h *HealthCheck) shardIDs() []int {
+	ids := make([]int, 0, len(h.shards))
+	for id := range h.shards {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Handler serves the result of check as a HealthStatus, with a 503 status if there are problems
+func (h *HealthCheck) Handler(check func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := HealthStatus{Status: "ok", Problems: check()}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if len(status.Problems) > 0 {
+			status.Status = "unavailable"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
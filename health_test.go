@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestHealthCheck(stats *QueueStats, now *time.Time) *HealthCheck {
+	h := NewHealthCheck([]int{0}, func() QueueStats { return *stats }, 10)
+	h.now = func() time.Time { return *now }
+	h.shards[0].changed = *now
+	return h
+}
+
+func TestHealthCheckDiscord(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	stats := QueueStats{Workers: 2, LastCompleted: now}
+	h := newTestHealthCheck(&stats, &now)
+
+	if len(h.Alive()) != 0 {
+		t.Errorf("Bot should be alive while connecting to Discord, got %v", h.Alive())
+	}
+	if len(h.Ready()) != 1 {
+		t.Errorf("Bot should not be ready until connected to Discord, got %v", h.Ready())
+	}
+
+	h.SetShardConnected(0, true)
+	if len(h.Ready()) != 0 {
+		t.Errorf("Bot should be ready once connected to Discord, got %v", h.Ready())
+	}
+
+	h.SetShardConnected(0, false)
+	now = now.Add(discordReconnectGrace + time.Second)
+	if len(h.Alive()) != 1 {
+		t.Errorf("Bot should not be alive after staying disconnected from Discord, got %v", h.Alive())
+	}
+	h.SetShardConnected(0, true)
+	if len(h.Alive()) != 0 {
+		t.Errorf("Bot should be alive once reconnected to Discord, got %v", h.Alive())
+	}
+}
+
+func TestHealthCheckTwitch(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	stats := QueueStats{Workers: 2, LastCompleted: now}
+	h := newTestHealthCheck(&stats, &now)
+	h.SetShardConnected(0, true)
+
+	h.ObserveTwitchResponse(&http.Response{StatusCode: 200}, nil)
+	now = now.Add(time.Minute)
+	h.ObserveTwitchResponse(nil, errors.New("timeout"))
+	if len(h.Ready()) != 0 {
+		t.Errorf("Bot should stay ready through brief Twitch failures, got %v", h.Ready())
+	}
+	now = now.Add(twitchFailingAfter)
+	h.ObserveTwitchResponse(&http.Response{StatusCode: 503}, nil)
+	if len(h.Ready()) != 1 || len(h.Alive()) != 0 {
+		t.Errorf("Bot should not be ready, but alive, while Twitch keeps failing, got %v and %v", h.Ready(), h.Alive())
+	}
+	h.ObserveTwitchResponse(&http.Response{StatusCode: 404}, nil)
+	if len(h.Ready()) != 0 {
+		t.Errorf("Bot should be ready once Twitch answers, got %v", h.Ready())
+	}
+
+	h.ObserveTwitchResponse(&http.Response{StatusCode: 401}, nil)
+	if len(h.Alive()) != 1 {
+		t.Errorf("Bot should not be alive once Twitch rejects its token, got %v", h.Alive())
+	}
+}
+
+func TestHealthCheckQueue(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	stats := QueueStats{Workers: 2, Active: 2, Queued: 10, LastCompleted: now}
+	h := newTestHealthCheck(&stats, &now)
+	h.SetShardConnected(0, true)
+
+	if len(h.Alive()) != 0 || len(h.Ready()) != 1 {
+		t.Errorf("Bot should be alive but not ready with a full queue, got %v and %v", h.Alive(), h.Ready())
+	}
+	now = now.Add(queueStallAfter + time.Second)
+	if len(h.Alive()) != 1 {
+		t.Errorf("Bot should not be alive once the queue stalls, got %v", h.Alive())
+	}
+}
+
+func TestHealthCheckHandler(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	stats := QueueStats{Workers: 2, LastCompleted: now}
+	h := newTestHealthCheck(&stats, &now)
+
+	rec := httptest.NewRecorder()
+	h.Handler(h.Ready).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var status HealthStatus
+	json.NewDecoder(rec.Body).Decode(&status)
+	if rec.Code != http.StatusServiceUnavailable || status.Status != "unavailable" || len(status.Problems) != 1 {
+		t.Errorf("Unready bot not correctly reported, got %d %v", rec.Code, status)
+	}
+
+	h.SetShardConnected(0, true)
+	rec = httptest.NewRecorder()
+	h.Handler(h.Ready).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Ready bot not correctly reported, got %d", rec.Code)
+	}
+}
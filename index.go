@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// indexSyncChunk is the window size used when syncing, small enough to stay under Twitch's pagination limit
+	indexSyncChunk = 7 * 24 * time.Hour
+	// indexRefreshWindow is how far back view counts are refreshed on every sync
+	indexRefreshWindow = 7 * 24 * time.Hour
+	// indexSyncInterval is how often indexed broadcasters are synced in the background
+	indexSyncInterval = 10 * time.Minute
+	// indexStaleAfter is how old the last sync can be for the index to still answer searches up to now
+	indexStaleAfter = 15 * time.Minute
+	// maxIndexedBroadcasters bounds how many broadcasters are indexed, as each one costs a backfill and a sync
+	// every interval
+	maxIndexedBroadcasters = 100
+	// indexCompactSlack is how many superseded records a broadcaster's file can hold on top of one per clip
+	// before it is rewritten
+	indexCompactSlack = 1000
+)
+
+// ClipIndex stores the clips of the broadcasters guilds subscribe to on disk so searches don't have to page
+// through Twitch. Each broadcaster has a file of JSON records, one per line, that syncs append their changes to.
+// It is safe for concurrent use.
+type ClipIndex struct {
+	mu           sync.RWMutex
+	dir          string
+	backfill     time.Duration
+	broadcasters map[string]*indexedBroadcaster
+	texts        map[string]*TextIndex
+	now          func() time.Time
+}
+
+// indexedBroadcaster holds the clips of a broadcaster created between CoveredFrom and CoveredUntil
+type indexedBroadcaster struct {
+	BroadcasterID string
+	CoveredFrom   time.Time
+	CoveredUntil  time.Time
+	Clips         map[string]Clip
+	// records counts the records in the broadcaster's file, to know when it's worth compacting
+	records int
+	// rewrite is set when the file must be rewritten before appending to it, because it's damaged
+	rewrite bool
+}
+
+// indexRecord is a line of a broadcaster's file: a clip that was added or changed, or the window covered once a
+// sync completed
+type indexRecord struct {
+	Clip     *Clip          `json:"clip,omitempty"`
+	Coverage *indexCoverage `json:"coverage,omitempty"`
+}
+
+type indexCoverage struct {
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+}
+
+// OpenClipIndex loads the index stored in dir, which will cover up to backfill in the past once synced
+func OpenClipIndex(dir string, backfill time.Duration) (*ClipIndex, error) {
+	index := &ClipIndex{
+		dir:          dir,
+		backfill:     backfill,
+		broadcasters: make(map[string]*indexedBroadcaster),
+		texts:        make(map[string]*TextIndex),
+		now:          time.Now,
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// Nothing indexed yet
+		return index, nil
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".jsonl") {
+			continue
+		}
+		b, err := loadIndexedBroadcaster(filepath.Join(dir, file.Name()), strings.TrimSuffix(file.Name(), ".jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		index.broadcasters[b.BroadcasterID] = b
+
+		text := NewTextIndex()
+		for _, clip := range b.Clips {
+			text.Add(clip)
+		}
+		index.texts[b.BroadcasterID] = text
+	}
+	return index, nil
+}
+
+// loadIndexedBroadcaster replays the records of a broadcaster's file. A crash can leave the last record cut
+// short, which only loses that record as the coverage is always recorded after the clips it covers.
+func loadIndexedBroadcaster(path string, broadcasterID string) (*indexedBroadcaster, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b := &indexedBroadcaster{BroadcasterID: broadcasterID, Clips: make(map[string]Clip)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record indexRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			Log.Warn("Ignoring the rest of a damaged index file", "broadcaster", broadcasterID, "err", err)
+			b.rewrite = true
+			break
+		}
+		if record.Clip != nil {
+			b.Clips[record.Clip.ID] = *record.Clip
+		}
+		if record.Coverage != nil {
+			b.CoveredFrom, b.CoveredUntil = record.Coverage.From, record.Coverage.Until
+		}
+		b.records++
+	}
+	if err := scanner.Err(); err != nil {
+		Log.Warn("Ignoring the rest of a damaged index file", "broadcaster", broadcasterID, "err", err)
+		b.rewrite = true
+	}
+	return b, nil
+}
+
+// SetBroadcasters makes the index hold the given broadcasters, like the ones guilds watch or get digests of, and
+// drops the others along with their clips. New broadcasters are backfilled by the next sync. Past
+// maxIndexedBroadcasters, the broadcasters already indexed are kept first.
+func (idx *ClipIndex) SetBroadcasters(broadcasterIDs []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var kept, added []string
+	for _, broadcasterID := range broadcasterIDs {
+		if seen[broadcasterID] {
+			continue
+		}
+		seen[broadcasterID] = true
+		if _, ok := idx.broadcasters[broadcasterID]; ok {
+			kept = append(kept, broadcasterID)
+		} else {
+			added = append(added, broadcasterID)
+		}
+	}
+	wanted := append(kept, added...)
+	if len(wanted) > maxIndexedBroadcasters {
+		Log.Warn("Too many broadcasters to index, leaving some out", "broadcasters", len(wanted), "max", maxIndexedBroadcasters)
+		wanted = wanted[:maxIndexedBroadcasters]
+	}
+
+	keep := make(map[string]bool, len(wanted))
+	for _, broadcasterID := range wanted {
+		keep[broadcasterID] = true
+		idx.add(broadcasterID)
+	}
+	for broadcasterID := range idx.broadcasters {
+		if !keep[broadcasterID] {
+			idx.remove(broadcasterID)
+		}
+	}
+}
+
+// add starts indexing a broadcaster if it isn't yet, returning it. Callers must hold the write lock.
+func (idx *ClipIndex) add(broadcasterID string) *indexedBroadcaster {
+	b, ok := idx.broadcasters[broadcasterID]
+	if !ok {
+		b = &indexedBroadcaster{BroadcasterID: broadcasterID, Clips: make(map[string]Clip)}
+		idx.broadcasters[broadcasterID] = b
+		idx.texts[broadcasterID] = NewTextIndex()
+	}
+	return b
+}
+
+// remove stops indexing a broadcaster and deletes its clips. Callers must hold the write lock.
+func (idx *ClipIndex) remove(broadcasterID string) {
+	delete(idx.broadcasters, broadcasterID)
+	delete(idx.texts, broadcasterID)
+	err := os.Remove(idx.path(broadcasterID))
+	if err != nil && !os.IsNotExist(err) {
+		Log.Warn("Couldn't remove indexed clips", "broadcaster", broadcasterID, "err", err)
+	}
+}
+
+// Covers reports whether the index holds every clip of a broadcaster created between startedAt and endedAt.
+// A zero endedAt means up to now.
+func (idx *ClipIndex) Covers(broadcasterID string, startedAt time.Time, endedAt time.Time) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	b, ok := idx.broadcasters[broadcasterID]
+	if !ok || b.CoveredFrom.IsZero() || startedAt.Before(b.CoveredFrom) {
+		return false
+	}
+	if endedAt.IsZero() {
+		return idx.now().Sub(b.CoveredUntil) <= indexStaleAfter
+	}
+	return !endedAt.After(b.CoveredUntil)
+}
+
+// Clips returns the indexed clips of a broadcaster created between startedAt and endedAt, newest first
+func (idx *ClipIndex) Clips(broadcasterID string, startedAt time.Time, endedAt time.Time) []Clip {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	b, ok := idx.broadcasters[broadcasterID]
+	if !ok {
+		return nil
+	}
+
+	var clips []Clip
+	for _, clip := range b.Clips {
+		if inWindow(clip, startedAt, endedAt) {
+			clips = append(clips, clip)
+		}
+	}
+	sort.Slice(clips, func(i, j int) bool { return clips[i].CreatedAt > clips[j].CreatedAt })
+	return clips
+}
+
+// Search returns the indexed clips of a broadcaster created between startedAt and endedAt whose title and
+// creator match the given queries, most relevant first and then most viewed first
+func (idx *ClipIndex) Search(broadcasterID string, startedAt time.Time, endedAt time.Time, titleQuery string, creatorQuery string) []Clip {
+	// Searching may sort the text index's tokens, so it needs exclusive access
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	b, ok := idx.broadcasters[broadcasterID]
+	if !ok {
+		return nil
+	}
+
+	matches := idx.texts[broadcasterID].Search(titleQuery, creatorQuery)
+	scores := make(map[string]float64, len(matches))
+	var clips []Clip
+	for _, match := range matches {
+		clip := b.Clips[match.ClipID]
+		if inWindow(clip, startedAt, endedAt) {
+			scores[clip.ID] = match.Score
+			clips = append(clips, clip)
+		}
+	}
+	sort.SliceStable(clips, func(i, j int) bool {
+		if scores[clips[i].ID] == scores[clips[j].ID] {
+			return clips[i].ViewCount > clips[j].ViewCount
+		}
+		return scores[clips[i].ID] > scores[clips[j].ID]
+	})
+	return clips
+}
+
+// Broadcasters returns the IDs of all indexed broadcasters
+func (idx *ClipIndex) Broadcasters() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	broadcasterIDs := make([]string, 0, len(idx.broadcasters))
+	for broadcasterID := range idx.broadcasters {
+		broadcasterIDs = append(broadcasterIDs, broadcasterID)
+	}
+	sort.Strings(broadcasterIDs)
+	return broadcasterIDs
+}
+
+// Sync fetches the clips created since the last sync of a broadcaster and refreshes the view counts of recent
+// ones, starting to index the broadcaster if it isn't yet. The first sync of a broadcaster backfills the index up
+// to the configured backfill duration.
+func (idx *ClipIndex) Sync(t TwitchAPI, broadcasterID string) error {
+	idx.mu.Lock()
+	b := idx.add(broadcasterID)
+	coveredFrom, coveredUntil := b.CoveredFrom, b.CoveredUntil
+	idx.mu.Unlock()
+
+	now := idx.now()
+	from := coveredUntil.Add(-indexRefreshWindow)
+	if coveredFrom.IsZero() {
+		coveredFrom = now.Add(-idx.backfill)
+		from = coveredFrom
+	}
+
+	for start := from; start.Before(now); start = start.Add(indexSyncChunk) {
+		end := start.Add(indexSyncChunk)
+		if end.After(now) {
+			end = now
+		}
+
+		var clips []Clip
+		err := t.WalkClips(broadcasterID, start, end, func(page []Clip) bool {
+			clips = append(clips, page...)
+			return true
+		})
+		// Keep what was synced, the covered window only grows once a sync completes
+		saveErr := idx.upsert(broadcasterID, clips)
+		if err != nil {
+			return err
+		}
+		if saveErr != nil {
+			return saveErr
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	b, ok := idx.broadcasters[broadcasterID]
+	if !ok {
+		// Dropped while syncing, so there's nothing left to save
+		return nil
+	}
+	b.CoveredFrom = coveredFrom
+	b.CoveredUntil = now
+	return idx.appendRecords(b, []indexRecord{{Coverage: &indexCoverage{From: coveredFrom, Until: now}}})
+}
+
+// Run indexes the broadcasters subscribed returns and syncs them each interval until stop is closed
+func (idx *ClipIndex) Run(t TwitchAPI, interval time.Duration, subscribed func() []string, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		idx.SetBroadcasters(subscribed())
+		for _, broadcasterID := range idx.Broadcasters() {
+			if err := idx.Sync(t, broadcasterID); err != nil {
+				Log.Warn("Couldn't sync clip index", "broadcaster", broadcasterID, "err", err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// upsert indexes the clips of a broadcaster, appending the ones that are new or changed to its file
+func (idx *ClipIndex) upsert(broadcasterID string, clips []Clip) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	b, ok := idx.broadcasters[broadcasterID]
+	if !ok {
+		// Dropped while syncing
+		return nil
+	}
+	text := idx.texts[broadcasterID]
+	var records []indexRecord
+	for i, clip := range clips {
+		if indexed, ok := b.Clips[clip.ID]; ok && reflect.DeepEqual(indexed, clip) {
+			continue
+		}
+		b.Clips[clip.ID] = clip
+		text.Add(clip)
+		records = append(records, indexRecord{Clip: &clips[i]})
+	}
+	return idx.appendRecords(b, records)
+}
+
+// appendRecords appends records to a broadcaster's file, or rewrites the file once superseded records pile up
+// or it's damaged. Callers must hold the write lock and have applied the records to b.
+func (idx *ClipIndex) appendRecords(b *indexedBroadcaster, records []indexRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if b.rewrite || b.records+len(records) > 2*len(b.Clips)+indexCompactSlack {
+		return idx.compact(b)
+	}
+
+	if err := os.MkdirAll(idx.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(idx.path(b.BroadcasterID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		// Part of a record may have been written, so don't append after it
+		b.rewrite = true
+		file.Close()
+		return err
+	}
+	b.records += len(records)
+	return file.Close()
+}
+
+// compact rewrites a broadcaster's file with a record for each of its clips and one for its coverage.
+// Callers must hold the write lock.
+func (idx *ClipIndex) compact(b *indexedBroadcaster) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	records := 0
+	for _, clip := range b.Clips {
+		clip := clip
+		if err := encoder.Encode(indexRecord{Clip: &clip}); err != nil {
+			return err
+		}
+		records++
+	}
+	if !b.CoveredFrom.IsZero() {
+		if err := encoder.Encode(indexRecord{Coverage: &indexCoverage{From: b.CoveredFrom, Until: b.CoveredUntil}}); err != nil {
+			return err
+		}
+		records++
+	}
+
+	if err := writeFile(idx.path(b.BroadcasterID), buf.Bytes()); err != nil {
+		b.rewrite = true
+		return err
+	}
+	b.records = records
+	b.rewrite = false
+	return nil
+}
+
+// path returns where a broadcaster's clips are stored
+func (idx *ClipIndex) path(broadcasterID string) string {
+	return filepath.Join(idx.dir, broadcasterID+".jsonl")
+}
+
+// inWindow reports whether clip was created between startedAt and endedAt, where zero times are unbounded
+func inWindow(clip Clip, startedAt time.Time, endedAt time.Time) bool {
+	createdAt, err := time.Parse(time.RFC3339, clip.CreatedAt)
+	if err != nil {
+		return false
+	}
+	if !startedAt.IsZero() && createdAt.Before(startedAt) {
+		return false
+	}
+	if !endedAt.IsZero() && createdAt.After(endedAt) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClipIndexSync(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-index")
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		indexClipsHandler(w, r)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	index, err := OpenClipIndex(dir, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Got an error opening the clip index: %s", err)
+	}
+	now := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	index.now = func() time.Time { return now }
+
+	if index.Covers("broadcaster", now.AddDate(0, 0, -7), time.Time{}) {
+		t.Errorf("Index should not cover a broadcaster before syncing")
+	}
+
+	if err := index.Sync(twitch, "broadcaster"); err != nil {
+		t.Errorf("Got an error syncing the clip index: %s", err)
+	}
+	if requests != 5 {
+		t.Errorf("Backfill not synced in weekly chunks, expected 5 requests got %d", requests)
+	}
+
+	if !index.Covers("broadcaster", now.AddDate(0, 0, -7), time.Time{}) {
+		t.Errorf("Index should cover the last week after syncing")
+	}
+	if index.Covers("broadcaster", now.AddDate(0, -2, 0), time.Time{}) {
+		t.Errorf("Index should not cover windows older than the backfill")
+	}
+	now = now.Add(time.Hour)
+	if index.Covers("broadcaster", now.AddDate(0, 0, -7), time.Time{}) {
+		t.Errorf("Index should not cover up to now when the last sync is stale")
+	}
+
+	clips := index.Clips("broadcaster", time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC), time.Time{})
+	if len(clips) != 2 || clips[0].ID != "newest" || clips[1].ID != "middle" {
+		t.Errorf("Indexed clips not correctly returned, expected [newest middle] got %v", clips)
+	}
+
+	reopened, err := OpenClipIndex(dir, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Got an error reopening the clip index: %s", err)
+	}
+	reopened.now = index.now
+	if clips := reopened.Clips("broadcaster", time.Time{}, time.Time{}); len(clips) != 3 {
+		t.Errorf("Indexed clips not persisted, expected 3 clips got %d", len(clips))
+	}
+	if !reopened.Covers("broadcaster", now.AddDate(0, 0, -7), now.Add(-time.Hour)) {
+		t.Errorf("Index coverage not persisted")
+	}
+}
+
+func TestClipIndexSyncError(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-index")
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	index, _ := OpenClipIndex(dir, 30*24*time.Hour)
+	now := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	index.now = func() time.Time { return now }
+
+	if err := index.Sync(twitch, "broadcaster"); err == nil {
+		t.Errorf("Expected an error syncing when Twitch fails")
+	}
+	if index.Covers("broadcaster", now.AddDate(0, 0, -7), time.Time{}) {
+		t.Errorf("Index should not cover a broadcaster whose sync failed")
+	}
+}
+
+func TestClipIndexSetBroadcasters(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-index")
+	defer os.RemoveAll(dir)
+
+	index, _ := OpenClipIndex(dir, 30*24*time.Hour)
+	index.SetBroadcasters([]string{"watched", "digested", "watched"})
+	if broadcasters := index.Broadcasters(); len(broadcasters) != 2 || broadcasters[0] != "digested" || broadcasters[1] != "watched" {
+		t.Errorf("Expected the subscribed broadcasters to be indexed, got %v", broadcasters)
+	}
+
+	index.upsert("watched", []Clip{{ID: "clip", CreatedAt: "2020-06-15T10:00:00Z"}})
+	index.SetBroadcasters([]string{"digested"})
+	if broadcasters := index.Broadcasters(); len(broadcasters) != 1 || broadcasters[0] != "digested" {
+		t.Errorf("Expected unsubscribed broadcasters to be dropped, got %v", broadcasters)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "watched.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("Expected the clips of dropped broadcasters to be removed, got %v", err)
+	}
+
+	var many []string
+	for i := 0; i < maxIndexedBroadcasters+10; i++ {
+		many = append(many, "broadcaster-"+strconv.Itoa(i))
+	}
+	index.SetBroadcasters(append(many, "digested"))
+	broadcasters := index.Broadcasters()
+	if len(broadcasters) != maxIndexedBroadcasters {
+		t.Errorf("Expected at most %d broadcasters, got %d", maxIndexedBroadcasters, len(broadcasters))
+	}
+	found := false
+	for _, broadcasterID := range broadcasters {
+		found = found || broadcasterID == "digested"
+	}
+	if !found {
+		t.Errorf("Expected broadcasters already indexed to be kept first")
+	}
+}
+
+func TestClipIndexAppendsChanges(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-index")
+	defer os.RemoveAll(dir)
+
+	views := 30
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := ClipsResponse{Data: []Clip{
+			{ID: "old", CreatedAt: "2020-06-05T10:00:00Z", ViewCount: 10},
+			{ID: "recent", CreatedAt: "2020-06-29T10:00:00Z", ViewCount: views},
+		}}
+		startedAt, _ := time.Parse(time.RFC3339, r.URL.Query().Get("started_at"))
+		endedAt, _ := time.Parse(time.RFC3339, r.URL.Query().Get("ended_at"))
+		var clips []Clip
+		for _, clip := range res.Data {
+			if inWindow(clip, startedAt, endedAt) {
+				clips = append(clips, clip)
+			}
+		}
+		res.Data = clips
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	index, _ := OpenClipIndex(dir, 30*24*time.Hour)
+	now := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	index.now = func() time.Time { return now }
+	path := filepath.Join(dir, "broadcaster.jsonl")
+	lines := func() int {
+		data, _ := ioutil.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+
+	index.Sync(twitch, "broadcaster")
+	if n := lines(); n != 3 {
+		t.Errorf("Expected a record per clip and one for the coverage, got %d", n)
+	}
+	now = now.Add(10 * time.Minute)
+	index.Sync(twitch, "broadcaster")
+	if n := lines(); n != 4 {
+		t.Errorf("Expected only the new coverage to be appended when no clip changed, got %d records", n)
+	}
+	views = 40
+	now = now.Add(10 * time.Minute)
+	index.Sync(twitch, "broadcaster")
+	if n := lines(); n != 6 {
+		t.Errorf("Expected the changed clip and the coverage to be appended, got %d records", n)
+	}
+
+	// A crash while appending leaves a record cut short
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"clip":{"id":"cut`)
+	file.Close()
+
+	reopened, err := OpenClipIndex(dir, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Got an error reopening the clip index: %s", err)
+	}
+	reopened.now = index.now
+	clips := reopened.Clips("broadcaster", time.Time{}, time.Time{})
+	if len(clips) != 2 || clips[0].ViewCount != 40 {
+		t.Errorf("Appended changes not replayed, got %v", clips)
+	}
+	if !reopened.Covers("broadcaster", now.AddDate(0, 0, -7), time.Time{}) {
+		t.Errorf("Appended coverage not replayed")
+	}
+
+	now = now.Add(10 * time.Minute)
+	reopened.Sync(twitch, "broadcaster")
+	if n := lines(); n != 3 {
+		t.Errorf("Expected a damaged file to be rewritten, got %d records", n)
+	}
+}
+
+func TestFindMostPopularClipsFromIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-index")
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		indexClipsHandler(w, r)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+	twitch.Index, _ = OpenClipIndex(dir, 30*24*time.Hour)
+	now := time.Date(2020, 6, 30, 12, 0, 0, 0, time.UTC)
+	twitch.Index.now = func() time.Time { return now }
+	twitch.Index.Sync(twitch, "broadcaster")
+
+	requests = 0
+	target := Clip{BroadcasterID: "broadcaster", StartedAt: now.AddDate(0, 0, -29)}
+	clips, _ := twitch.FindMostPopularClips(target, matchTitle, 2)
+	if requests != 0 {
+		t.Errorf("Search covered by the index should not request clips, got %d requests", requests)
+	}
+	if len(clips) != 2 || clips[0].ID != "middle" || clips[1].ID != "newest" {
+		t.Errorf("Most popular clips not correctly returned from index, expected [middle newest] got %v", clips)
+	}
+}
+
+func indexClipsHandler(w http.ResponseWriter, r *http.Request) {
+	startedAt, _ := time.Parse(time.RFC3339, r.URL.Query().Get("started_at"))
+	endedAt, _ := time.Parse(time.RFC3339, r.URL.Query().Get("ended_at"))
+	clips := []Clip{
+		{ID: "oldest", CreatedAt: "2020-06-05T10:00:00Z", ViewCount: 10},
+		{ID: "middle", CreatedAt: "2020-06-15T10:00:00Z", ViewCount: 30},
+		{ID: "newest", CreatedAt: "2020-06-25T10:00:00Z", ViewCount: 20},
+	}
+
+	res := ClipsResponse{}
+	for _, clip := range clips {
+		if inWindow(clip, startedAt, endedAt) {
+			res.Data = append(res.Data, clip)
+		}
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestClipIndexSearch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-index")
+	defer os.RemoveAll(dir)
+
+	index, _ := OpenClipIndex(dir, 30*24*time.Hour)
+	index.SetBroadcasters([]string{"broadcaster"})
+	index.upsert("broadcaster", []Clip{
+		{ID: "old", Title: "Funny clip", CreatedAt: "2020-05-01T10:00:00Z", ViewCount: 100},
+		{ID: "popular", Title: "Funny clip", CreatedAt: "2020-06-15T10:00:00Z", ViewCount: 50},
+		{ID: "unpopular", Title: "Funny clip", CreatedAt: "2020-06-16T10:00:00Z", ViewCount: 5},
+		{ID: "other", Title: "Sad clip", CreatedAt: "2020-06-17T10:00:00Z", ViewCount: 500},
+	})
+
+	clips := index.Search("broadcaster", time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), time.Time{}, "funny", "")
+	if len(clips) != 2 || clips[0].ID != "popular" || clips[1].ID != "unpopular" {
+		t.Errorf("Indexed clips not correctly searched, expected [popular unpopular] got %v", clips)
+	}
+}
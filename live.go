@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveInterval is how often subscribed broadcasters are polled for going live
+const liveInterval = 2 * time.Minute
+
+// LiveSubscription is a request to announce in a Discord channel when a broadcaster goes live
+type LiveSubscription struct {
+	GuildID         string `json:"guild_id"`
+	ChannelID       string `json:"channel_id"`
+	BroadcasterID   string `json:"broadcaster_id"`
+	BroadcasterName string `json:"broadcaster_name"`
+	RoleID          string `json:"role_id"`
+}
+
+// LiveStore persists live subscriptions along with the streams already announced. It is safe for concurrent use.
+type LiveStore struct {
+	mu            sync.Mutex
+	path          string
+	Subscriptions []LiveSubscription `json:"subscriptions"`
+	// Live maps broadcasters that are live to the ID of their current stream
+	Live map[string]string `json:"live"`
+	owns guildFilter
+}
+
+// OpenLiveStore loads the live subscriptions stored in path
+func OpenLiveStore(path string) (*LiveStore, error) {
+	store := &LiveStore{path: path}
+	if err := loadJSON(path, store); err != nil {
+		return nil, err
+	}
+	if store.Live == nil {
+		store.Live = make(map[string]string)
+	}
+	return store, nil
+}
+
+// Add registers a subscription, replacing the guild's existing subscription to the same broadcaster
+func (ls *LiveStore) Add(subscription LiveSubscription) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for i, s := range ls.Subscriptions {
+		if s.GuildID == subscription.GuildID && s.BroadcasterID == subscription.BroadcasterID {
+			ls.Subscriptions[i] = subscription
+			return ls.save()
+		}
+	}
+	ls.Subscriptions = append(ls.Subscriptions, subscription)
+	return ls.save()
+}
+
+// Remove unregisters a guild's subscription to a broadcaster, reporting whether there was one
+func (ls *LiveStore) Remove(guildID string, broadcasterName string) (bool, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	for i, s := range ls.Subscriptions {
+		if s.GuildID == guildID && strings.EqualFold(s.BroadcasterName, broadcasterName) {
+			ls.Subscriptions = append(ls.Subscriptions[:i], ls.Subscriptions[i+1:]...)
+			return true, ls.save()
+		}
+	}
+	return false, nil
+}
+
+// List returns the subscriptions registered in a guild
+func (ls *LiveStore) List(guildID string) []LiveSubscription {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var subscriptions []LiveSubscription
+	for _, s := range ls.Subscriptions {
+		if s.GuildID == guildID {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].BroadcasterName < subscriptions[j].BroadcasterName })
+	return subscriptions
+}
+
+// GuildIDs returns the guilds with live subscriptions
+func (ls *LiveStore) GuildIDs() []string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var guildIDs []string
+	for _, s := range ls.Subscriptions {
+		guildIDs = append(guildIDs, s.GuildID)
+	}
+	return guildIDs
+}
+
+// SetOwner limits polling and announcements to the subscriptions of the guilds owns reports true for, like the
+// ones whose shard runs in this process
+func (ls *LiveStore) SetOwner(owns func(guildID string) bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.owns = owns
+}
+
+// Broadcasters returns the IDs of the broadcasters subscribed to by the guilds this process handles
+func (ls *LiveStore) Broadcasters() []string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var broadcasterIDs []string
+	for _, s := range ls.Subscriptions {
+		if ls.owns.handles(s.GuildID) && !seen[s.BroadcasterID] {
+			seen[s.BroadcasterID] = true
+			broadcasterIDs = append(broadcasterIDs, s.BroadcasterID)
+		}
+	}
+	return broadcasterIDs
+}
+
+// Poll checks which subscribed broadcasters are live and announces the streams that weren't announced yet
+func (ls *LiveStore) Poll(t TwitchAPI, post func(LiveSubscription, Stream) error) error {
+	broadcasterIDs := ls.Broadcasters()
+	if len(broadcasterIDs) == 0 {
+		return nil
+	}
+
+	streams, err := t.GetStreamsByUserID(broadcasterIDs)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]bool)
+	for _, stream := range streams {
+		live[stream.UserID] = true
+		ls.Online(stream, post)
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for _, broadcasterID := range broadcasterIDs {
+		if !live[broadcasterID] {
+			delete(ls.Live, broadcasterID)
+		}
+	}
+	return ls.save()
+}
+
+// Online announces stream to every subscription of its broadcaster, unless it was already announced
+func (ls *LiveStore) Online(stream Stream, post func(LiveSubscription, Stream) error) {
+	ls.mu.Lock()
+	if ls.Live[stream.UserID] == stream.ID {
+		ls.mu.Unlock()
+		return
+	}
+	ls.Live[stream.UserID] = stream.ID
+	var subscriptions []LiveSubscription
+	for _, s := range ls.Subscriptions {
+		if s.BroadcasterID == stream.UserID && ls.owns.handles(s.GuildID) {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	if err := ls.save(); err != nil {
+		Log.Error("Couldn't save live streams", "err", err)
+	}
+	ls.mu.Unlock()
+
+	for _, s := range subscriptions {
+		if err := post(s, stream); err != nil {
+			Log.Error("Couldn't announce stream", "stream", stream.ID, "guild", s.GuildID, "channel", s.ChannelID, "err", err)
+		}
+	}
+}
+
+// Run polls for live broadcasters each interval until stop is closed
+func (ls *LiveStore) Run(t TwitchAPI, interval time.Duration, post func(LiveSubscription, Stream) error, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := ls.Poll(t, post); err != nil {
+				Log.Warn("Couldn't poll live streams", "err", err)
+			}
+		}
+	}
+}
+
+func (ls *LiveStore) save() error {
+	return saveJSON(ls.path, ls)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLiveStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-live")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "live.json")
+
+	store, err := OpenLiveStore(path)
+	if err != nil {
+		t.Fatalf("Got an error opening the live store: %s", err)
+	}
+	store.Add(LiveSubscription{GuildID: "guild", ChannelID: "channel", BroadcasterID: "1", BroadcasterName: "streamer"})
+	store.Add(LiveSubscription{GuildID: "guild", ChannelID: "channel", BroadcasterID: "1", BroadcasterName: "streamer", RoleID: "role"})
+	store.Add(LiveSubscription{GuildID: "other-guild", ChannelID: "elsewhere", BroadcasterID: "1", BroadcasterName: "streamer"})
+
+	reopened, _ := OpenLiveStore(path)
+	subscriptions := reopened.List("guild")
+	if len(subscriptions) != 1 || subscriptions[0].RoleID != "role" {
+		t.Errorf("Subscriptions not correctly persisted, expected streamer mentioning role got %v", subscriptions)
+	}
+	if broadcasters := reopened.Broadcasters(); len(broadcasters) != 1 {
+		t.Errorf("Broadcasters should be listed once, got %v", broadcasters)
+	}
+
+	if removed, _ := reopened.Remove("guild", "Streamer"); !removed {
+		t.Errorf("Subscription should have been removed")
+	}
+	if subscriptions := reopened.List("guild"); len(subscriptions) != 0 {
+		t.Errorf("Subscription not correctly removed, got %v", subscriptions)
+	}
+}
+
+func TestLiveStorePoll(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-live")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "live.json")
+
+	var live []Stream
+	failing := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(StreamsResponse{Data: live})
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	store, _ := OpenLiveStore(path)
+	store.Add(LiveSubscription{GuildID: "guild", ChannelID: "channel", BroadcasterID: "1"})
+	store.Add(LiveSubscription{GuildID: "other-guild", ChannelID: "elsewhere", BroadcasterID: "1"})
+
+	var announced []string
+	post := func(ls LiveSubscription, stream Stream) error {
+		announced = append(announced, ls.ChannelID+":"+stream.ID)
+		return nil
+	}
+
+	store.Poll(twitch, post)
+	if len(announced) != 0 {
+		t.Errorf("Offline broadcasters should not be announced, got %v", announced)
+	}
+
+	live = []Stream{{ID: "first-stream", UserID: "1"}}
+	store.Poll(twitch, post)
+	store.Poll(twitch, post)
+	if len(announced) != 2 {
+		t.Errorf("Stream should be announced once per subscription, got %v", announced)
+	}
+
+	failing = true
+	if err := store.Poll(twitch, post); err == nil {
+		t.Errorf("Poll should return an error when Twitch fails")
+	}
+	failing = false
+	store.Poll(twitch, post)
+	if len(announced) != 2 {
+		t.Errorf("Stream should not be announced again after Twitch fails, got %v", announced)
+	}
+
+	reopened, _ := OpenLiveStore(path)
+	reopened.Poll(twitch, post)
+	if len(announced) != 2 {
+		t.Errorf("Stream should not be announced again after a restart, got %v", announced)
+	}
+
+	live = nil
+	reopened.Poll(twitch, post)
+	live = []Stream{{ID: "second-stream", UserID: "1"}}
+	reopened.Poll(twitch, post)
+	if len(announced) != 4 || !strings.HasSuffix(announced[2], ":second-stream") || !strings.HasSuffix(announced[3], ":second-stream") {
+		t.Errorf("New stream should be announced after going offline, got %v", announced)
+	}
+}
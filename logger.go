@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// Log levels, from the most to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level called name
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, errors.New("log: unknown level \"" + name + "\", levels are debug, info, warn and error")
+}
+
+// redacted replaces credentials in log lines
+const redacted = "[REDACTED]"
+
+var bearerRegex = regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`)
+
+// sensitiveKeys are the parts of field names whose values are always redacted
+var sensitiveKeys = []string{"token", "secret", "password", "authorization"}
+
+// Log is the bot's logger. It writes logfmt at the info level until main configures it.
+var Log = NewLogger(os.Stderr, LevelInfo, "logfmt")
+
+// Logger writes structured log lines as logfmt or JSON, made of a message and key value fields, redacting
+// credentials from them. It is safe for concurrent use.
+type Logger struct {
+	output *logOutput
+	fields []interface{}
+}
+
+// logOutput is shared by a Logger and every Logger derived from it with With
+type logOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	level   Level
+	json    bool
+	secrets []string
+	now     func() time.Time
+}
+
+// NewLogger returns a Logger writing lines of at least level to w in format, either "logfmt" or "json"
+func NewLogger(w io.Writer, level Level, format string) *Logger {
+	return &Logger{output: &logOutput{w: w, level: level, json: format == "json", now: time.Now}}
+}
+
+// With returns a Logger that adds the key value pairs in keyvals to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{output: l.output, fields: fields}
+}
+
+// Configure changes the level and format of the Logger and every Logger derived from it
+func (l *Logger) Configure(w io.Writer, level Level, format string) {
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+
+	l.output.w, l.output.level, l.output.json = w, level, format == "json"
+}
+
+// Redact makes the Logger replace secrets wherever they show up in its lines
+func (l *Logger) Redact(secrets ...string) {
+	l.output.mu.Lock()
+	defer l.output.mu.Unlock()
+
+	for _, secret := range secrets {
+		// Very short values would redact unrelated text
+		if len(secret) >= 4 {
+			l.output.secrets = append(l.output.secrets, secret)
+		}
+	}
+	// Replace longer secrets first, in case one contains another
+	sort.Slice(l.output.secrets, func(i, j int) bool { return len(l.output.secrets[i]) > len(l.output.secrets[j]) })
+}
+
+// Debug logs msg with the key value pairs in keyvals, for troubleshooting
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+// Info logs msg with the key value pairs in keyvals
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+// Warn logs msg with the key value pairs in keyvals, for problems the bot recovers from
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+// Error logs msg with the key value pairs in keyvals, for failures a user or operator will notice
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Fatal logs msg as an error and exits
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+// Writer returns a writer that logs every line written to it at level, to route the standard library's and
+// discordgo's logs through the Logger
+func (l *Logger) Writer(level Level) io.Writer {
+	return logWriter{logger: l, level: level}
+}
+
+type logWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (lw logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		lw.logger.log(lw.level, line, nil)
+	}
+	return len(p), nil
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	out := l.output
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	if level < out.level {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(keyvals))
+	fields = append(fields, "time", out.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	var line bytes.Buffer
+	if out.json {
+		line.WriteByte('{')
+	}
+	for i := 0; i < len(fields); i += 2 {
+		key := out.redact("", fmt.Sprint(fields[i]))
+		value := fields[i+1]
+		if out.json {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			encodedKey, _ := json.Marshal(key)
+			line.Write(encodedKey)
+			line.WriteByte(':')
+			line.Write(out.jsonValue(key, value))
+		} else {
+			if i > 0 {
+				line.WriteByte(' ')
+			}
+			line.WriteString(key)
+			line.WriteByte('=')
+			line.WriteString(logfmtValue(out.redact(key, formatValue(value))))
+		}
+	}
+	if out.json {
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+	out.w.Write(line.Bytes())
+}
+
+// redact hides the credentials in the value of a field
+func (out *logOutput) redact(key string, value string) string {
+	lowerKey := strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lowerKey, sensitive) && value != "" {
+			return redacted
+		}
+	}
+	for _, secret := range out.secrets {
+		value = strings.Replace(value, secret, redacted, -1)
+	}
+	return bearerRegex.ReplaceAllString(value, "${1}"+redacted)
+}
+
+func (out *logOutput) jsonValue(key string, value interface{}) []byte {
+	switch value.(type) {
+	case int, int64, float64, bool:
+		encoded, _ := json.Marshal(value)
+		return encoded
+	}
+	encoded, _ := json.Marshal(out.redact(key, formatValue(value)))
+	return encoded
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// logfmtValue quotes value if it has spaces, quotes or equal signs
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogger(level Level, format string) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, level, format)
+	logger.output.now = func() time.Time { return time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return logger, &buf
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": LevelDebug, "INFO": LevelInfo, "warn": LevelWarn, "error": LevelError} {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("ParseLevel(%q) expected %v got %v, %v", name, expected, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel should fail for unknown levels")
+	}
+}
+
+func TestLoggerLogfmt(t *testing.T) {
+	logger, buf := newTestLogger(LevelInfo, "logfmt")
+
+	logger.With("guild", "guild-1").Info("Running command", "command", "top", "err", errors.New("not found"))
+	expected := "time=2020-05-01T12:00:00Z level=info msg=\"Running command\" guild=guild-1 command=top err=\"not found\"\n"
+	if buf.String() != expected {
+		t.Errorf("Line not correctly formatted, expected %q got %q", expected, buf.String())
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	logger, buf := newTestLogger(LevelInfo, "json")
+
+	logger.Warn("Retrying", "attempt", 2, "user", "user-1")
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Line is not valid JSON: %s", err)
+	}
+	if line["level"] != "warn" || line["msg"] != "Retrying" || line["attempt"] != float64(2) || line["user"] != "user-1" {
+		t.Errorf("Line not correctly formatted, got %s", buf.String())
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	logger, buf := newTestLogger(LevelWarn, "logfmt")
+
+	logger.Debug("debug")
+	logger.Info("info")
+	if buf.Len() != 0 {
+		t.Errorf("Lines below the level should not be logged, got %q", buf.String())
+	}
+	logger.Error("error")
+	if !strings.Contains(buf.String(), "level=error") {
+		t.Errorf("Lines at or above the level should be logged, got %q", buf.String())
+	}
+
+	logger.Configure(buf, LevelDebug, "logfmt")
+	logger.With("guild", "guild-1").Debug("debug")
+	if !strings.Contains(buf.String(), "level=debug") {
+		t.Errorf("Configure should change the level of derived loggers, got %q", buf.String())
+	}
+}
+
+func TestLoggerRedact(t *testing.T) {
+	logger, buf := newTestLogger(LevelInfo, "logfmt")
+	logger.Redact("super-secret-value", "ab")
+
+	logger.Info("Requesting https://example.com/?client_secret=super-secret-value", "header", "Bearer abcdef", "access_token", "abcdef", "user", "ab")
+	line := buf.String()
+	for _, leaked := range []string{"super-secret-value", "abcdef"} {
+		if strings.Contains(line, leaked) {
+			t.Errorf("Line leaked %q: %s", leaked, line)
+		}
+	}
+	if !strings.Contains(line, "user=ab") {
+		t.Errorf("Short secrets should not be redacted, got %s", line)
+	}
+
+	buf.Reset()
+	logger.Writer(LevelInfo).Write([]byte("2020/05/01 error connecting with super-secret-value\n"))
+	if strings.Contains(buf.String(), "super-secret-value") || !strings.Contains(buf.String(), redacted) {
+		t.Errorf("Writer should redact lines written to it, got %s", buf.String())
+	}
+}
@@ -1,177 +1,306 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"expvar"
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
+var ConfigFile string
+var LogLevel string
+var LogFormat string
 var Token string
 var ClientID string
 var ClientSecret string
+var DataDir string
+var IndexBackfill time.Duration
+var HTTPAddr string
+var EventSubCallback string
+var EventSubSecret string
+var APIKeys string
+var APIBudget int
+var FeedBudget int
+var UserCooldown time.Duration
+var GuildCooldown time.Duration
+var UserBudget int
+var GuildBudget int
+var Workers int
+var QueueSize int
+var GuildQueueSize int
+var ShutdownGrace time.Duration
+var ShardCount int
+var ShardIDs string
 var Twitch TwitchAPI
+var Watches *WatchStore
+var Digests *DigestStore
+var Live *LiveStore
+var Configs *ConfigStore
+var Limits *RateLimiter
+var Queue *WorkQueue
+var Health *HealthCheck
+var Shards *ShardManager
+
+// maxClipLinks bounds how many clip links are looked up from a single message
+const maxClipLinks = 5
+
+// maxVODClips bounds how many clips are listed in a reply to keep it under Discord's message size limit
+const maxVODClips = 15
+
+// registerFlags defines the bot's flags on fs
+func registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&ConfigFile, "config", "", "Path of a config file with a flag per line as name = value")
+	fs.StringVar(&Token, "token", "", "Bot token")
+	fs.StringVar(&Token, "t", "", "Short for -token")
+	fs.StringVar(&ClientID, "client-id", "", "Twitch client id")
+	fs.StringVar(&ClientID, "c", "", "Short for -client-id")
+	fs.StringVar(&ClientSecret, "client-secret", "", "Twitch client secret")
+	fs.StringVar(&ClientSecret, "s", "", "Short for -client-secret")
+	fs.StringVar(&DataDir, "data-dir", "data", "Directory where the bot keeps its persistent state")
+	fs.StringVar(&DataDir, "d", "data", "Short for -data-dir")
+	fs.DurationVar(&IndexBackfill, "index-backfill", 365*24*time.Hour, "How far back the local clip index goes")
+	fs.StringVar(&HTTPAddr, "http", "", "Address for the bot's HTTP server to listen on, disabled if empty")
+	fs.StringVar(&EventSubCallback, "eventsub-callback", "", "Public URL of the bot's /eventsub endpoint, enables Twitch EventSub if set")
+	fs.StringVar(&EventSubSecret, "eventsub-secret", "", "Secret used to sign Twitch EventSub messages")
+	fs.StringVar(&APIKeys, "api-keys", "", "Comma separated keys accepted by the HTTP JSON API under /v1, disabled if empty")
+	fs.IntVar(&APIBudget, "api-budget", 2000, "Twitch requests each API key can make per hour, unlimited if 0")
+	fs.IntVar(&FeedBudget, "feed-budget", 500, "Twitch requests the feeds under /feeds can make per hour, unlimited if 0")
+	fs.DurationVar(&UserCooldown, "user-cooldown", 5*time.Second, "Minimum time between the commands of a user")
+	fs.DurationVar(&GuildCooldown, "guild-cooldown", time.Second, "Minimum time between the commands of a server")
+	fs.IntVar(&UserBudget, "user-budget", 500, "Twitch requests a user's commands can make per hour, unlimited if 0")
+	fs.IntVar(&GuildBudget, "guild-budget", 2000, "Twitch requests a server's commands can make per hour, unlimited if 0")
+	fs.IntVar(&Workers, "workers", 4, "Number of commands run at the same time")
+	fs.IntVar(&QueueSize, "queue-size", 100, "Number of commands that can wait for a worker")
+	fs.IntVar(&GuildQueueSize, "guild-queue-size", 10, "Number of commands of a single server that can wait for a worker")
+	fs.IntVar(&ShardCount, "shard-count", 0, "Number of Discord gateway shards across every process, Discord's recommendation if 0")
+	fs.StringVar(&ShardIDs, "shard-ids", "", "Shards run by this process, like 0,2,4-7, all of them if empty")
+	fs.DurationVar(&ShutdownGrace, "shutdown-grace", 20*time.Second, "How long running commands get to finish when the bot stops")
+	fs.StringVar(&LogLevel, "log-level", "info", "Least severe level logged: debug, info, warn or error")
+	fs.StringVar(&LogFormat, "log-format", "logfmt", "Format of the logs: logfmt or json")
+}
 
 func main() {
 
-	flag.StringVar(&Token, "t", "a-token", "Bot token")
-	flag.StringVar(&ClientID, "c", "a-client-id", "Twitch client id")
-	flag.StringVar(&ClientSecret, "s", "a-client-secret", "Twitch client secret")
+	if len(os.Args) > 1 && cliCommands[os.Args[1]] {
+		os.Exit(runCLI(os.Args[1], os.Args[2:], os.Stdout, os.Stderr, os.Getenv))
+	}
+
+	registerFlags(flag.CommandLine)
 	flag.Parse()
+	if err := loadConfig(flag.CommandLine, os.Getenv); err != nil {
+		Log.Fatal("Error loading configuration", "err", err)
+	}
+	if err := validateConfig(); err != nil {
+		Log.Fatal("Invalid configuration", "err", err)
+	}
+	// validateConfig rejects unknown levels and formats, so the level is always valid here
+	level, _ := ParseLevel(LogLevel)
+	Log.Configure(os.Stderr, level, LogFormat)
+	Log.Redact(Token, ClientID, ClientSecret, EventSubSecret)
+	Log.Redact(ParseAPIKeys(APIKeys)...)
+	// Route the standard library's and discordgo's logs through Log, so they're redacted too
+	log.SetFlags(0)
+	log.SetOutput(Log.Writer(LevelInfo))
 
 	dg, err := discordgo.New("Bot " + Token)
 	if err != nil {
-		log.Fatalln("error creating Discord session, ", err)
+		Log.Fatal("Error creating Discord session", "err", err)
 	}
-	dg.SyncEvents = true
-	Twitch = NewTwitchAPI(ClientID, ClientSecret, true)
-
-	dg.AddHandler(handleCommand)
-
-	err = dg.Open()
-	if err != nil {
-		log.Fatalln("error opening connection, ", err)
+	dg.Client.Transport = instrumentedTransport{next: dg.Client.Transport, observe: observeDiscordRequest}
+	if _, err := dg.User("@me"); err != nil {
+		Log.Fatal("Error checking the Discord token", "err", err)
 	}
-
-	log.Println("Bot is now running. Press CTRL-C to exit.")
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
-	<-sc
-
-	dg.Close()
-}
-
-func handleHelpCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
-	help := `Search for Twitch clips.
-Usage: !clips subcommand streamer "title" creator start_date end_date
-Required arguments:
-	- streamer: The name of the Twitch channel/streamer where to look for clips.
-Optional arguments:
-	- subcommand: Available subcommands are "topN" and "help": "topN" returns the top N clips by view count for the given streamer, filtering by any other optional argument passed, "help" prints this message.
-	- title: Find a clip with a specific title. **Must** be enclosed in double quotes.
-	- creator: Filter by clips created by a specific user. If defined, **must** always come after streamer argument.
-	- start_date: Look for a clip created from this date onwards. Defaults to **1 week ago**. Format as YYYY-MM-DD. Will make things run faster if used.
-	- end_date: Look for a clip created before this date. Format as YYYY-MM-DD. Will make things run faster if used.`
-	s.ChannelMessageSend(m.ChannelID, help)
-	return
-}
-
-func handleTopCommand(s *discordgo.Session, m *discordgo.MessageCreate, c Command) {
-	if c.Broadcaster == "" {
-		s.ChannelMessageSend(m.ChannelID, "I need at least the name of a streamer to look for clips! Use \"!clips help\" for more info.")
-		return
+	if ShardCount == 0 {
+		gateway, err := dg.GatewayBot()
+		if err != nil {
+			Log.Fatal("Error getting the recommended number of shards", "err", err)
+		}
+		ShardCount = gateway.Shards
+		if ShardCount < 1 {
+			ShardCount = 1
+		}
 	}
-
-	broadcasters, err := Twitch.GetBroadcastersByName([]string{c.Broadcaster})
+	shardIDs, err := ParseShardIDs(ShardIDs, ShardCount)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Couldn't find a streamer named \""+c.Broadcaster+"\". Could you check the name and try again?")
-		return
+		Log.Fatal("Invalid shards", "err", err)
 	}
-	targetClip := Clip{
-		BroadcasterID: broadcasters[0].ID,
-		Title:         c.Title,
-		StartedAt:     c.StartedAt,
-		EndedAt:       c.EndedAt,
-		CreatorName:   c.Creator,
+	Health = NewHealthCheck(shardIDs, func() QueueStats { return Queue.Stats() }, QueueSize)
+	Shards, err = NewShardManager(dg, ShardCount, shardIDs, func(s *discordgo.Session) {
+		s.AddHandler(handleCommand)
+		s.AddHandler(func(s *discordgo.Session, c *discordgo.Connect) {
+			Health.SetShardConnected(s.ShardID, true)
+			discordShardConnected.Set(1, strconv.Itoa(s.ShardID))
+		})
+		s.AddHandler(func(s *discordgo.Session, d *discordgo.Disconnect) {
+			Log.Warn("Disconnected from the Discord gateway", "shard", s.ShardID)
+			Health.SetShardConnected(s.ShardID, false)
+			discordShardConnected.Set(0, strconv.Itoa(s.ShardID))
+		})
+	})
+	if err != nil {
+		Log.Fatal("Error creating Discord shards", "err", err)
 	}
-	if targetClip.StartedAt.IsZero() {
-		targetClip.StartedAt = time.Now().AddDate(0, 0, -7)
+	expvar.Publish("shards", expvar.Func(func() interface{} { return Shards.Status() }))
+	Twitch, err = NewTwitchAPI(ClientID, ClientSecret, true)
+	if err != nil {
+		Log.Fatal("Error checking the Twitch credentials", "err", err)
 	}
-	matchFunc := matchMany(matchTitle, matchCreator)
-	results := Twitch.FindMostPopularClips(targetClip, matchFunc, c.Top)
 
-	if len(results) == 0 {
-		s.ChannelMessageSend(m.ChannelID, "Couldn't find any \""+c.Broadcaster+"\" clips. Check the streamer name and the date bounds.")
-		return
+	Queue = NewWorkQueue(Workers, QueueSize, GuildQueueSize)
+	expvar.Publish("work_queue", expvar.Func(func() interface{} { return Queue.Stats() }))
+	registerRuntimeMetrics()
+	Limits = NewRateLimiter(UserCooldown, GuildCooldown, UserBudget, GuildBudget)
+	Configs, err = OpenConfigStore(filepath.Join(DataDir, "config.json"))
+	if err != nil {
+		Log.Fatal("Error opening guild settings", "err", err)
 	}
-
-	msg := "Top " + strconv.Itoa(len(results)) + " " + c.Broadcaster + " clips from " + c.StartedAt.Format("2006-01-02") + " to " + c.EndedAt.Format("2006-01-02") + "\n"
-	for i, clip := range results {
-		msg = msg + "\t" + strconv.Itoa(i+1) + ". \"" + clip.Title + "\" by " + clip.CreatorName + ". Views: " + strconv.Itoa(clip.ViewCount) + "\n"
+	Watches, err = OpenWatchStore(filepath.Join(DataDir, "watches.json"))
+	if err != nil {
+		Log.Fatal("Error opening watches", "err", err)
 	}
-
-	s.ChannelMessageSend(m.ChannelID, msg)
-	return
-}
-
-func handleCommand(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author.ID == s.State.User.ID || !strings.HasPrefix(m.Content, "!clips") {
-		return
+	Watches.SetOwner(Shards.Owns)
+	Digests, err = OpenDigestStore(filepath.Join(DataDir, "digests.json"))
+	if err != nil {
+		Log.Fatal("Error opening digests", "err", err)
 	}
-	log.Printf("Got message %s", m.Content)
-	command, err := ParseCommand(m.Content)
-	switch command.SubCommand {
-	case "help":
-		handleHelpCommand(s, m)
-		return
-	case "top":
-		handleTopCommand(s, m, command)
-		return
+	Digests.SetOwner(Shards.Owns)
+	Live, err = OpenLiveStore(filepath.Join(DataDir, "live.json"))
+	if err != nil {
+		Log.Fatal("Error opening live subscriptions", "err", err)
 	}
-	log.Printf("Command: %v", command)
-
-	if err != nil || command.Broadcaster == "" {
-		s.ChannelMessageSend(m.ChannelID, "I need at least the name of a streamer to look for clips! Use \"!clips help\" for more info.")
-		return
+	Live.SetOwner(Shards.Owns)
+	// Guilds whose shard moved to another process would silently lose their settings, watches, digests and
+	// announcements, so don't start until their data is where their shard runs
+	if foreign := Shards.ForeignGuilds(Configs, Watches, Digests, Live); len(foreign) > 0 {
+		Log.Fatal("Data dir holds guilds whose shards run in another process, use the shards it was written with",
+			"data_dir", DataDir, "guilds", len(foreign), "first", foreign[0])
 	}
 
-	broadcasters, err := Twitch.GetBroadcastersByName([]string{command.Broadcaster})
+	index, err := OpenClipIndex(filepath.Join(DataDir, "index"), IndexBackfill)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Couldn't find a streamer named \""+command.Broadcaster+"\". Could you check the name and try again?")
-		return
+		Log.Fatal("Error opening clip index", "err", err)
 	}
-	log.Printf("Command: %v", command)
-	targetClip := Clip{
-		BroadcasterID: broadcasters[0].ID,
-		Title:         command.Title,
-		StartedAt:     command.StartedAt,
-		EndedAt:       command.EndedAt,
-		CreatorName:   command.Creator,
+	Twitch.Index = index
+	// pollers tracks the background loops, so shutdown can wait for them to save their state
+	var pollers sync.WaitGroup
+	stopIndex := make(chan struct{})
+	pollers.Add(1)
+	go func() {
+		defer pollers.Done()
+		// Only the broadcasters guilds subscribe to are indexed, searches for others go to Twitch
+		index.Run(Twitch, indexSyncInterval, func() []string {
+			return append(Watches.BroadcasterIDs(), Digests.BroadcasterIDs()...)
+		}, stopIndex)
+	}()
+
+	stopWatches := make(chan struct{})
+	pollers.Add(1)
+	go func() {
+		defer pollers.Done()
+		Watches.Run(Twitch, watchInterval, func(w Watch, clip Clip) error {
+			games, _ := Twitch.GetGamesByID([]string{clip.GameID})
+			config := Configs.Get(w.GuildID)
+			return sendEmbed(Shards.Session(w.GuildID), w.ChannelID, config, clipEmbed(clip, nil, games, config.Format()))
+		}, stopWatches)
+	}()
+
+	stopDigests := make(chan struct{})
+	pollers.Add(1)
+	go func() {
+		defer pollers.Done()
+		Digests.Run(Twitch, digestInterval, func(d Digest, clips []Clip) error {
+			config := Configs.Get(d.GuildID)
+			return sendEmbed(Shards.Session(d.GuildID), d.ChannelID, config, digestEmbed(d, clips, config.Format()))
+		}, stopDigests)
+	}()
+
+	stopLive := make(chan struct{})
+	pollers.Add(1)
+	go func() {
+		defer pollers.Done()
+		Live.Run(Twitch, liveInterval, func(ls LiveSubscription, stream Stream) error {
+			return sendLiveAnnouncement(Shards.Session(ls.GuildID), ls, stream)
+		}, stopLive)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", Metrics)
+	mux.Handle("/healthz", Health.Handler(Health.Alive))
+	mux.Handle("/readyz", Health.Handler(Health.Ready))
+	mux.Handle("/feeds/", NewFeedHandler(Twitch, FeedBudget))
+	if keys := ParseAPIKeys(APIKeys); len(keys) > 0 {
+		mux.Handle("/v1/", NewAPIHandler(Twitch, keys, APIBudget))
 	}
-	if targetClip.StartedAt.IsZero() {
-		targetClip.StartedAt = time.Now().AddDate(0, 0, -7)
+	if EventSubCallback != "" {
+		mux.Handle("/eventsub", NewEventSubHandler(EventSubSecret, func(subscription EventSubSubscription, event json.RawMessage) {
+			handleEventSubNotification(Shards.Session(""), subscription, event)
+		}))
+		go func() {
+			if err := Twitch.SubscribeStreamOnline(Live.Broadcasters(), EventSubCallback, EventSubSecret); err != nil {
+				Log.Error("Error subscribing to EventSub", "err", err)
+			}
+		}()
 	}
-	matchFunc := matchMany(matchTitle, matchCreator)
-
-	var result Clip
-	if targetClip.Title == "" || targetClip.CreatorName == "" {
-		// There may be many clips with the same creator or title, so we look for the most popular one
-		result = Twitch.FindMostPopularClip(targetClip, matchFunc)
-	} else {
-		// Otherwise, we're looking for a specific clip
-		result = Twitch.FindClip(targetClip, matchFunc)
+	server := &http.Server{Addr: HTTPAddr, Handler: mux}
+	if HTTPAddr != "" {
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				Log.Fatal("Error running HTTP server", "err", err)
+			}
+		}()
 	}
 
-	if result == targetClip {
-		s.ChannelMessageSend(m.ChannelID, "I couldn't find a clip that matches your search.")
-		return
+	if err := Shards.Open(); err != nil {
+		Log.Fatal("Error opening connection", "err", err)
 	}
 
-	s.ChannelMessageSend(m.ChannelID, "Found your clip: "+result.URL)
-	return
-}
-
-func matchMany(funcs ...func(Clip, Clip) bool) func(Clip, Clip) bool {
-	return func(clip1, clip2 Clip) bool {
-		result := true
-		for _, f := range funcs {
-			result = result && f(clip1, clip2)
-		}
+	Log.Info("Bot is now running. Press CTRL-C to exit.", "shards", shardIDs, "shard_count", ShardCount)
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+	<-sc
 
-		return result
+	// Stop taking commands and give the running ones the grace period to finish, while the gateway stays open
+	// for their replies
+	Log.Info("Shutting down", "grace", ShutdownGrace)
+	deadline := time.Now().Add(ShutdownGrace)
+	Health.SetShuttingDown()
+	close(stopIndex)
+	close(stopWatches)
+	close(stopDigests)
+	close(stopLive)
+	if cancelled := Queue.Shutdown(ShutdownGrace); cancelled > 0 {
+		Log.Warn("Cancelled commands that didn't finish in time", "commands", cancelled)
 	}
-}
 
-func matchTitle(clip1, clip2 Clip) bool {
-	return strings.Contains(strings.ToLower(clip1.Title), strings.ToLower(clip2.Title))
-}
+	stopped := make(chan struct{})
+	go func() {
+		pollers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+		Log.Warn("Background polls didn't finish in time")
+	}
+	if err := Watches.Flush(); err != nil {
+		Log.Error("Couldn't save watches", "err", err)
+	}
 
-func matchCreator(clip1, clip2 Clip) bool {
-	return strings.Contains(strings.ToLower(clip1.CreatorName), strings.ToLower(clip2.CreatorName))
+	ctx, cancel := context.WithDeadline(context.Background(), deadline.Add(time.Second))
+	defer cancel()
+	server.Shutdown(ctx)
+	Shards.Close()
+	Log.Info("Bot stopped")
 }
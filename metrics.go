@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics is the registry of the bot's metrics, served in the Prometheus text format at /metrics
+var Metrics = NewMetricsRegistry()
+
+// Buckets of the histograms, in their unit
+var (
+	durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	pageBuckets     = []float64{0, 1, 2, 5, 10, 25, 50, 100}
+	waitBuckets     = []float64{1, 5, 15, 30, 60, 300, 900, 3600}
+)
+
+var (
+	commandsTotal = Metrics.NewCounterVec("clips_commands_total",
+		"Commands handled, by subcommand and outcome.", "command", "outcome")
+	commandDuration = Metrics.NewHistogramVec("clips_command_duration_seconds",
+		"Time taken to run commands once a worker picks them up, by subcommand.", durationBuckets, "command")
+	twitchRequestsTotal = Metrics.NewCounterVec("clips_twitch_requests_total",
+		"Requests made to Twitch, by endpoint and response status.", "endpoint", "status")
+	twitchRequestDuration = Metrics.NewHistogramVec("clips_twitch_request_duration_seconds",
+		"Time taken by requests to Twitch, by endpoint.", durationBuckets, "endpoint")
+	searchPages = Metrics.NewHistogramVec("clips_search_pages",
+		"Pages of clips fetched from Twitch per search, 0 when the index answers it.", pageBuckets)
+	indexSearchesTotal = Metrics.NewCounterVec("clips_index_searches_total",
+		"Searches by whether the local index covered them.", "result")
+	rateLimitWait = Metrics.NewHistogramVec("clips_rate_limit_wait_seconds",
+		"How long rate limited users were asked to wait.", waitBuckets)
+	discordShardConnected = Metrics.NewGaugeVec("clips_discord_shard_connected",
+		"Whether each shard run by this process is connected to the Discord gateway.", "shard")
+	discordSendFailuresTotal = Metrics.NewCounterVec("clips_discord_send_failures_total",
+		"Messages that couldn't be sent to Discord, by response status.", "status")
+	apiRequestsTotal = Metrics.NewCounterVec("clips_api_requests_total",
+		"Requests to the HTTP JSON API, by endpoint and response status.", "endpoint", "status")
+)
+
+// MetricsRegistry holds metrics and writes them in the Prometheus text format. It is safe for concurrent use.
+type MetricsRegistry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// metric is a metric family that can write itself in the Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// NewMetricsRegistry returns an empty MetricsRegistry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{metrics: make(map[string]metric)}
+}
+
+func (r *MetricsRegistry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.metrics[name] = m
+}
+
+// NewCounterVec registers a counter partitioned by the given labels
+func (r *MetricsRegistry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, kind: "counter", labels: labels, series: make(map[string]*counterSeries)}
+	r.register(name, c)
+	return c
+}
+
+// NewGaugeVec registers a gauge partitioned by the given labels
+func (r *MetricsRegistry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{CounterVec{name: name, help: help, kind: "gauge", labels: labels, series: make(map[string]*counterSeries)}}
+	r.register(name, g)
+	return g
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds for its buckets, partitioned by the given
+// labels
+func (r *MetricsRegistry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(name, h)
+	return h
+}
+
+// NewCounterFunc registers a counter whose value is read from fn, for totals kept elsewhere
+func (r *MetricsRegistry) NewCounterFunc(name string, help string, fn func() float64) {
+	r.register(name, funcMetric{name: name, help: help, kind: "counter", fn: fn})
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn
+func (r *MetricsRegistry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(name, funcMetric{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics to Prometheus
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// CounterVec is a counter partitioned by labels. It is safe for concurrent use.
+type CounterVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+// Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds value to the counter with the given label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	series, ok := c.series[key]
+	if !ok {
+		series = &counterSeries{labelValues: labelValues}
+		c.series[key] = series
+	}
+	series.value += value
+}
+
+// Value returns the counter with the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if series, ok := c.series[strings.Join(labelValues, "\xff")]; ok {
+		return series.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, c.kind)
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := c.series[key]
+		writeSample(w, c.name, labelPairs(c.labels, series.labelValues), series.value)
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels, a value that can go up and down. It is safe for concurrent use.
+type GaugeVec struct {
+	CounterVec
+}
+
+// Set sets the gauge with the given label values to value
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	series, ok := g.series[key]
+	if !ok {
+		series = &counterSeries{labelValues: labelValues}
+		g.series[key] = series
+	}
+	series.value = value
+}
+
+// HistogramVec is a histogram partitioned by labels. It is safe for concurrent use.
+type HistogramVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	// counts holds how many observations fell in each bucket, and not in the ones before it
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe adds value to the histogram with the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.count++
+	series.sum += value
+}
+
+// ObserveDuration adds how long it's been since start, in seconds, to the histogram with the given label values
+func (h *HistogramVec) ObserveDuration(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		pairs := labelPairs(h.labels, series.labelValues)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			writeSample(w, h.name+"_bucket", append(pairs, "le", formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", append(pairs, "le", "+Inf"), float64(series.count))
+		writeSample(w, h.name+"_sum", pairs, series.sum)
+		writeSample(w, h.name+"_count", pairs, float64(series.count))
+	}
+}
+
+// funcMetric is a metric without labels whose value is read when it's written
+type funcMetric struct {
+	name string
+	help string
+	kind string
+	fn   func() float64
+}
+
+func (f funcMetric) write(w io.Writer) {
+	writeHeader(w, f.name, f.help, f.kind)
+	writeSample(w, f.name, nil, f.fn())
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	io.WriteString(w, "# HELP "+name+" "+strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)+"\n")
+	io.WriteString(w, "# TYPE "+name+" "+kind+"\n")
+}
+
+// writeSample writes a sample line, with pairs holding label names followed by their values
+func writeSample(w io.Writer, name string, pairs []string, value float64) {
+	line := name
+	if len(pairs) > 0 {
+		labels := make([]string, 0, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			labels = append(labels, pairs[i]+"=\""+escapeLabelValue(pairs[i+1])+"\"")
+		}
+		line += "{" + strings.Join(labels, ",") + "}"
+	}
+	io.WriteString(w, line+" "+formatFloat(value)+"\n")
+}
+
+// labelPairs interleaves label names and values, in a new slice that can be appended to
+func labelPairs(names []string, values []string) []string {
+	pairs := make([]string, 0, 2*len(names)+2)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name, value)
+	}
+	return pairs
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// instrumentedTransport is an http.RoundTripper that reports every request it makes to observe
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	observe func(req *http.Request, resp *http.Response, err error, start time.Time)
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	t.observe(req, resp, err, start)
+	return resp, err
+}
+
+// responseStatus is the status label of a response, "error" if the request failed without one
+func responseStatus(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// observeTwitchRequest counts a request to Twitch by its endpoint, like /helix/clips, and response status
+func observeTwitchRequest(req *http.Request, resp *http.Response, err error, start time.Time) {
+	twitchRequestsTotal.Inc(req.URL.Path, responseStatus(resp, err))
+	twitchRequestDuration.ObserveDuration(start, req.URL.Path)
+	if Health != nil {
+		Health.ObserveTwitchResponse(resp, err)
+	}
+}
+
+// observeDiscordRequest counts the messages Discord didn't accept. Other requests, like typing, aren't counted.
+func observeDiscordRequest(req *http.Request, resp *http.Response, err error, start time.Time) {
+	if req.Method != "POST" || !strings.HasSuffix(req.URL.Path, "/messages") {
+		return
+	}
+	if err != nil || resp == nil || resp.StatusCode >= 300 {
+		discordSendFailuresTotal.Inc(responseStatus(resp, err))
+	}
+}
+
+// registerRuntimeMetrics registers the metrics read from the Twitch cache and the work queue, once main has set
+// them up
+func registerRuntimeMetrics() {
+	Metrics.NewCounterFunc("clips_cache_hits_total", "Twitch responses answered from the cache.", func() float64 {
+		return float64(Twitch.Cache.Stats().Hits)
+	})
+	Metrics.NewCounterFunc("clips_cache_misses_total", "Twitch responses missing from the cache.", func() float64 {
+		return float64(Twitch.Cache.Stats().Misses)
+	})
+	Metrics.NewCounterFunc("clips_cache_evictions_total", "Entries evicted from the cache to make room.", func() float64 {
+		return float64(Twitch.Cache.Stats().Evictions)
+	})
+	Metrics.NewGaugeFunc("clips_cache_entries", "Entries in the cache.", func() float64 {
+		return float64(Twitch.Cache.Stats().Entries)
+	})
+	Metrics.NewGaugeFunc("clips_queue_queued", "Commands waiting for a worker.", func() float64 {
+		return float64(Queue.Stats().Queued)
+	})
+	Metrics.NewGaugeFunc("clips_queue_active", "Commands being run by a worker.", func() float64 {
+		return float64(Queue.Stats().Active)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRegistryWriteTo(t *testing.T) {
+	r := NewMetricsRegistry()
+	commands := r.NewCounterVec("test_commands_total", "Commands.", "command", "outcome")
+	durations := r.NewHistogramVec("test_duration_seconds", "Durations.", []float64{1, 5}, "command")
+	r.NewGaugeFunc("test_queued", "Queued.", func() float64 { return 3 })
+
+	commands.Inc("top", "ok")
+	commands.Inc("top", "ok")
+	commands.Inc("say \"hi\"", "denied")
+	durations.Observe(0.5, "top")
+	durations.Observe(2, "top")
+	durations.Observe(10, "top")
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	expected := `# HELP test_commands_total Commands.
+# TYPE test_commands_total counter
+test_commands_total{command="say \"hi\"",outcome="denied"} 1
+test_commands_total{command="top",outcome="ok"} 2
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{command="top",le="1"} 1
+test_duration_seconds_bucket{command="top",le="5"} 2
+test_duration_seconds_bucket{command="top",le="+Inf"} 3
+test_duration_seconds_sum{command="top"} 12.5
+test_duration_seconds_count{command="top"} 3
+# HELP test_queued Queued.
+# TYPE test_queued gauge
+test_queued 3
+`
+	if buf.String() != expected {
+		t.Errorf("Metrics not correctly written, expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestMetricsRegistryServeHTTP(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.NewCounterVec("test_total", "Test.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Metrics served with the wrong content type, got %s", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Errorf("Metrics not correctly served, got %s", rec.Body.String())
+	}
+}
+
+func TestTwitchRequestMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(pagedClipsHandler))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	requests := twitchRequestsTotal.Value("/helix/clips", "200")
+	pages := searchPages.series[""]
+	var searches uint64
+	if pages != nil {
+		searches = pages.count
+	}
+
+	twitch.FindMostPopularClips(Clip{BroadcasterID: "broadcaster"}, func(Clip, Clip) bool { return true }, 10)
+	if got := twitchRequestsTotal.Value("/helix/clips", "200") - requests; got != 2 {
+		t.Errorf("Twitch requests not correctly counted, expected 2 got %v", got)
+	}
+	if got := searchPages.series[""].count - searches; got != 1 {
+		t.Errorf("Search pages not correctly observed, expected 1 search got %v", got)
+	}
+	ts.Close()
+}
+
+func TestObserveDiscordRequest(t *testing.T) {
+	sent, _ := http.NewRequest("POST", "https://discord.com/api/v6/channels/1/messages", nil)
+	typing, _ := http.NewRequest("POST", "https://discord.com/api/v6/channels/1/typing", nil)
+	failures := discordSendFailuresTotal.Value("403")
+	errorFailures := discordSendFailuresTotal.Value("error")
+
+	observeDiscordRequest(sent, &http.Response{StatusCode: 200}, nil, time.Now())
+	observeDiscordRequest(sent, &http.Response{StatusCode: 403}, nil, time.Now())
+	observeDiscordRequest(typing, &http.Response{StatusCode: 403}, nil, time.Now())
+	observeDiscordRequest(sent, nil, errors.New("timeout"), time.Now())
+
+	if got := discordSendFailuresTotal.Value("403") - failures; got != 1 {
+		t.Errorf("Rejected messages not correctly counted, expected 1 got %v", got)
+	}
+	if got := discordSendFailuresTotal.Value("error") - errorFailures; got != 1 {
+		t.Errorf("Failed requests not correctly counted, expected 1 got %v", got)
+	}
+}
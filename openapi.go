@@ -0,0 +1,161 @@
+package main
+
+// openAPISpec describes the HTTP JSON API in OpenAPI 3.0, served at /v1/openapi.json
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Clips API",
+    "version": "1.0.0",
+    "description": "Search the Twitch clips the clips Discord bot finds. Every request but this description needs an API key, passed as a bearer token or in the X-API-Key header. Each key is charged the estimated number of Twitch requests of its searches, about one per day searched, against an hourly budget."
+  },
+  "servers": [{"url": "/v1"}],
+  "security": [{"bearer": []}, {"apiKey": []}],
+  "paths": {
+    "/clips/search": {
+      "get": {
+        "summary": "Search for clips by title and creator",
+        "description": "Clips are ranked by how well their title matches when the bot's index covers the period, and by views otherwise. Outside the index, a title and a creator together find the single clip they point to, like the bot does.",
+        "parameters": [
+          {"$ref": "#/components/parameters/q"},
+          {"$ref": "#/components/parameters/broadcaster"},
+          {"$ref": "#/components/parameters/title"},
+          {"$ref": "#/components/parameters/creator"},
+          {"$ref": "#/components/parameters/from"},
+          {"$ref": "#/components/parameters/to"},
+          {"$ref": "#/components/parameters/limit"},
+          {"$ref": "#/components/parameters/cursor"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/clips"},
+          "400": {"$ref": "#/components/responses/error"},
+          "401": {"$ref": "#/components/responses/error"},
+          "404": {"$ref": "#/components/responses/error"},
+          "429": {"$ref": "#/components/responses/rateLimited"},
+          "502": {"$ref": "#/components/responses/error"},
+          "504": {"$ref": "#/components/responses/error"}
+        }
+      }
+    },
+    "/clips/top": {
+      "get": {
+        "summary": "List the most viewed clips",
+        "parameters": [
+          {"$ref": "#/components/parameters/q"},
+          {"$ref": "#/components/parameters/broadcaster"},
+          {"$ref": "#/components/parameters/title"},
+          {"$ref": "#/components/parameters/creator"},
+          {"$ref": "#/components/parameters/from"},
+          {"$ref": "#/components/parameters/to"},
+          {"$ref": "#/components/parameters/limit"},
+          {"$ref": "#/components/parameters/cursor"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/clips"},
+          "400": {"$ref": "#/components/responses/error"},
+          "401": {"$ref": "#/components/responses/error"},
+          "404": {"$ref": "#/components/responses/error"},
+          "429": {"$ref": "#/components/responses/rateLimited"},
+          "502": {"$ref": "#/components/responses/error"},
+          "504": {"$ref": "#/components/responses/error"}
+        }
+      }
+    },
+    "/broadcasters/{login}": {
+      "get": {
+        "summary": "Look up a broadcaster by login",
+        "parameters": [
+          {"name": "login", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The broadcaster",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Broadcaster"}}}
+          },
+          "401": {"$ref": "#/components/responses/error"},
+          "404": {"$ref": "#/components/responses/error"},
+          "429": {"$ref": "#/components/responses/rateLimited"},
+          "502": {"$ref": "#/components/responses/error"},
+          "504": {"$ref": "#/components/responses/error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"},
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"}
+    },
+    "parameters": {
+      "q": {"name": "q", "in": "query", "description": "A search in the bot's command syntax, like streamer \"title\" creator 30d. The other parameters take precedence over it.", "schema": {"type": "string"}},
+      "broadcaster": {"name": "broadcaster", "in": "query", "description": "Login of the broadcaster whose clips are searched. Required unless given in q.", "schema": {"type": "string"}},
+      "title": {"name": "title", "in": "query", "description": "Words the title of the clips contains.", "schema": {"type": "string"}},
+      "creator": {"name": "creator", "in": "query", "description": "Name of the user who created the clips.", "schema": {"type": "string"}},
+      "from": {"name": "from", "in": "query", "description": "Look for clips created from this date or RFC 3339 time onwards. Defaults to a week ago.", "schema": {"type": "string"}},
+      "to": {"name": "to", "in": "query", "description": "Look for clips created before this date or RFC 3339 time. Must be after from.", "schema": {"type": "string"}},
+      "limit": {"name": "limit", "in": "query", "description": "Number of clips in the page.", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+      "cursor": {"name": "cursor", "in": "query", "description": "The cursor of the previous page, to get the next one with the same search parameters. Only the first 1000 clips can be paged through.", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "clips": {
+        "description": "A page of clips",
+        "content": {"application/json": {"schema": {
+          "type": "object",
+          "properties": {
+            "data": {"type": "array", "items": {"$ref": "#/components/schemas/Clip"}},
+            "pagination": {"type": "object", "properties": {"cursor": {"type": "string", "description": "Cursor of the next page, missing on the last one"}}}
+          }
+        }}}
+      },
+      "error": {
+        "description": "The request failed",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "rateLimited": {
+        "description": "The key is out of budget",
+        "headers": {"Retry-After": {"description": "Seconds until the request can be retried", "schema": {"type": "integer"}}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "Clip": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "title": {"type": "string"},
+          "broadcaster_id": {"type": "string"},
+          "broadcaster_name": {"type": "string"},
+          "creator_id": {"type": "string"},
+          "creator_name": {"type": "string"},
+          "view_count": {"type": "integer"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "duration": {"type": "number"},
+          "game_id": {"type": "string"},
+          "video_id": {"type": "string"},
+          "language": {"type": "string"},
+          "url": {"type": "string"},
+          "embed_url": {"type": "string"},
+          "thumbnail_url": {"type": "string"}
+        }
+      },
+      "Broadcaster": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "login": {"type": "string"},
+          "display_name": {"type": "string"},
+          "type": {"type": "string"},
+          "broadcaster_type": {"type": "string"},
+          "description": {"type": "string"},
+          "profile_image_url": {"type": "string"},
+          "offline_image_url": {"type": "string"},
+          "view_count": {"type": "integer"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {"error": {"type": "string"}}
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// clipFormats are the formats clips can be written in
+var clipFormats = []string{"table", "json", "csv"}
+
+// clipRecord holds the fields of a clip worth writing out, without the search window Clip carries around
+type clipRecord struct {
+	ID              string  `json:"id"`
+	Title           string  `json:"title"`
+	BroadcasterID   string  `json:"broadcaster_id"`
+	BroadcasterName string  `json:"broadcaster_name"`
+	CreatorID       string  `json:"creator_id"`
+	CreatorName     string  `json:"creator_name"`
+	ViewCount       int     `json:"view_count"`
+	CreatedAt       string  `json:"created_at"`
+	Duration        float64 `json:"duration"`
+	GameID          string  `json:"game_id"`
+	VideoID         string  `json:"video_id"`
+	Language        string  `json:"language"`
+	URL             string  `json:"url"`
+	EmbedURL        string  `json:"embed_url"`
+	ThumbnailURL    string  `json:"thumbnail_url"`
+}
+
+var clipColumns = []string{"id", "title", "broadcaster_id", "broadcaster_name", "creator_id", "creator_name", "view_count",
+	"created_at", "duration", "game_id", "video_id", "language", "url", "embed_url", "thumbnail_url"}
+
+func newClipRecord(clip Clip) clipRecord {
+	return clipRecord{
+		ID:              clip.ID,
+		Title:           clip.Title,
+		BroadcasterID:   clip.BroadcasterID,
+		BroadcasterName: clip.BroadcasterName,
+		CreatorID:       clip.CreatorID,
+		CreatorName:     clip.CreatorName,
+		ViewCount:       clip.ViewCount,
+		CreatedAt:       clip.CreatedAt,
+		Duration:        clip.Duration,
+		GameID:          clip.GameID,
+		VideoID:         clip.VideoID,
+		Language:        clip.Language,
+		URL:             clip.URL,
+		EmbedURL:        clip.EmbedURL,
+		ThumbnailURL:    clip.ThumbnailURL,
+	}
+}
+
+func (r clipRecord) values() []string {
+	return []string{r.ID, r.Title, r.BroadcasterID, r.BroadcasterName, r.CreatorID, r.CreatorName, strconv.Itoa(r.ViewCount),
+		r.CreatedAt, strconv.FormatFloat(r.Duration, 'f', -1, 64), r.GameID, r.VideoID, r.Language, r.URL, r.EmbedURL,
+		r.ThumbnailURL}
+}
+
+// WriteClips writes clips to w in format: an aligned table, JSON lines or CSV with a header
+func WriteClips(w io.Writer, format string, clips []Clip) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		io.WriteString(tw, "#\tTITLE\tCREATOR\tVIEWS\tCREATED\tURL\n")
+		for i, clip := range clips {
+			// Tabs and newlines in titles would break the columns
+			title := strings.Join(strings.Fields(clip.Title), " ")
+			io.WriteString(tw, strconv.Itoa(i+1)+"\t"+title+"\t"+clip.CreatorName+"\t"+strconv.Itoa(clip.ViewCount)+"\t"+clip.CreatedAt+"\t"+clip.URL+"\n")
+		}
+		return tw.Flush()
+	case "json":
+		encoder := json.NewEncoder(w)
+		for _, clip := range clips {
+			if err := encoder.Encode(newClipRecord(clip)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(clipColumns)
+		for _, clip := range clips {
+			cw.Write(newClipRecord(clip).values())
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return errors.New("output: unknown format \"" + format + "\", formats are " + strings.Join(clipFormats, ", "))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Who can use a subcommand
+const (
+	accessEveryone = "everyone"
+	accessRoles    = "roles"
+	accessManagers = "managers"
+)
+
+// permissionSubCommands are the subcommands whose permissions can be changed. "search" is the plain clip search.
+var permissionSubCommands = []string{"search", "top", "stats", "clip", "vod", "watch", "unwatch", "watches", "digest", "live", "help"}
+
+// managedSubCommands change what the bot posts in the server, so only members that can manage it use them
+// unless the guild opens them up
+var managedSubCommands = map[string]bool{
+	"watch":   true,
+	"unwatch": true,
+	"digest":  true,
+	"live":    true,
+}
+
+// Permission restricts who can use a subcommand and in which channels
+type Permission struct {
+	Access string   `json:"access"`
+	Roles  []string `json:"roles,omitempty"`
+	// Channels are the only channels the subcommand can be used in, or every channel if empty
+	Channels []string `json:"channels,omitempty"`
+	// DenyChannels are the channels the subcommand can't be used in
+	DenyChannels []string `json:"deny_channels,omitempty"`
+}
+
+// defaultPermission returns the permission of a subcommand the guild hasn't changed
+func defaultPermission(subCommand string) Permission {
+	if managedSubCommands[subCommand] {
+		return Permission{Access: accessManagers}
+	}
+	return Permission{Access: accessEveryone}
+}
+
+// Set changes a single rule of the permission: the roles that can use it, who can use it, or the channels it's
+// allowed or denied in. Roles and channels are given as their IDs.
+func (p *Permission) Set(key string, values []string) error {
+	switch key {
+	case "roles":
+		if len(values) == 0 {
+			return errors.New("permission: roles must be one or more role mentions")
+		}
+		p.Access, p.Roles = accessRoles, values
+	case "access":
+		if len(values) != 1 || (values[0] != accessEveryone && values[0] != accessManagers) {
+			return errors.New("permission: access must be \"everyone\" or \"managers\", or set roles instead")
+		}
+		p.Access, p.Roles = values[0], nil
+	case "channels":
+		if len(values) == 0 || values[0] == "all" {
+			p.Channels = nil
+			return nil
+		}
+		p.Channels = values
+	case "deny":
+		if len(values) == 0 || values[0] == "none" {
+			p.DenyChannels = nil
+			return nil
+		}
+		p.DenyChannels = values
+	default:
+		return errors.New("permission: unknown rule \"" + key + "\", rules are roles, access, channels and deny")
+	}
+	return nil
+}
+
+// Check returns why a member with roles can't use the subcommand in channelID, or nil if they can.
+// isManager is only called when the member's roles aren't enough.
+func (p Permission) Check(channelID string, roles []string, isManager func() bool) error {
+	if containsString(p.DenyChannels, channelID) {
+		return errors.New("permission: this command can't be used in this channel")
+	}
+	if len(p.Channels) > 0 && !containsString(p.Channels, channelID) {
+		return errors.New("permission: this command can only be used in " + mentionChannels(p.Channels))
+	}
+
+	switch p.Access {
+	case accessRoles:
+		for _, role := range roles {
+			if containsString(p.Roles, role) {
+				return nil
+			}
+		}
+		if isManager() {
+			return nil
+		}
+		return errors.New("permission: this command is only for " + mentionRoles(p.Roles))
+	case accessManagers:
+		if isManager() {
+			return nil
+		}
+		return errors.New("permission: this command is only for members with the Manage Server permission")
+	}
+	return nil
+}
+
+func (p Permission) String() string {
+	var rules []string
+	switch p.Access {
+	case accessRoles:
+		rules = append(rules, "for "+mentionRoles(p.Roles))
+	case accessManagers:
+		rules = append(rules, "for managers")
+	default:
+		rules = append(rules, "for everyone")
+	}
+	if len(p.Channels) > 0 {
+		rules = append(rules, "in "+mentionChannels(p.Channels))
+	}
+	if len(p.DenyChannels) > 0 {
+		rules = append(rules, "not in "+mentionChannels(p.DenyChannels))
+	}
+	return strings.Join(rules, ", ")
+}
+
+// Permission returns the permission of a subcommand in the guild
+func (gc GuildConfig) Permission(subCommand string) Permission {
+	if p, ok := gc.Permissions[subCommand]; ok {
+		return p
+	}
+	return defaultPermission(subCommand)
+}
+
+// SetPermission changes a single rule of a subcommand's permission in a guild, returning the updated permission
+func (cs *ConfigStore) SetPermission(guildID string, subCommand string, key string, values []string) (Permission, error) {
+	if !containsString(permissionSubCommands, subCommand) {
+		return Permission{}, errors.New("permission: unknown command \"" + subCommand + "\", commands are " + strings.Join(permissionSubCommands, ", "))
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	config, ok := cs.Guilds[guildID]
+	if !ok {
+		config = DefaultGuildConfig()
+	}
+	p := config.Permission(subCommand)
+	if err := p.Set(key, values); err != nil {
+		return Permission{}, err
+	}
+
+	// Configs handed out by Get share the map, so replace it instead of changing it
+	permissions := make(map[string]Permission, len(config.Permissions)+1)
+	for name, other := range config.Permissions {
+		permissions[name] = other
+	}
+	permissions[subCommand] = p
+	config.Permissions = permissions
+	cs.Guilds[guildID] = config
+	return p, cs.save()
+}
+
+// ResetPermission restores the default permission of a subcommand in a guild
+func (cs *ConfigStore) ResetPermission(guildID string, subCommand string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	config, ok := cs.Guilds[guildID]
+	if !ok {
+		return nil
+	}
+	if _, ok := config.Permissions[subCommand]; !ok {
+		return nil
+	}
+	permissions := make(map[string]Permission, len(config.Permissions))
+	for name, other := range config.Permissions {
+		if name != subCommand {
+			permissions[name] = other
+		}
+	}
+	config.Permissions = permissions
+	cs.Guilds[guildID] = config
+	return cs.save()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func mentionChannels(channelIDs []string) string {
+	return "<#" + strings.Join(channelIDs, ">, <#") + ">"
+}
+
+func mentionRoles(roleIDs []string) string {
+	return "<@&" + strings.Join(roleIDs, ">, <@&") + ">"
+}
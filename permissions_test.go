@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissionDefaults(t *testing.T) {
+	config := DefaultGuildConfig()
+	notManager := func() bool { return false }
+
+	if err := config.Permission("top").Check("channel", nil, notManager); err != nil {
+		t.Errorf("Searches should be open to everyone by default, got %s", err)
+	}
+	if err := config.Permission("watch").Check("channel", nil, notManager); err == nil {
+		t.Errorf("Watches should be only for managers by default")
+	}
+	if err := config.Permission("watch").Check("channel", nil, func() bool { return true }); err != nil {
+		t.Errorf("Managers should be able to add watches, got %s", err)
+	}
+}
+
+func TestPermissionCheck(t *testing.T) {
+	p := Permission{Access: accessEveryone}
+	p.Set("roles", []string{"mods", "vips"})
+	p.Set("channels", []string{"bot"})
+	p.Set("deny", []string{"general"})
+
+	managerCalled := false
+	notManager := func() bool {
+		managerCalled = true
+		return false
+	}
+
+	if err := p.Check("bot", []string{"members", "vips"}, notManager); err != nil {
+		t.Errorf("Members with an allowed role should be allowed, got %s", err)
+	}
+	if managerCalled {
+		t.Errorf("Members with an allowed role shouldn't be checked for being managers")
+	}
+	if err := p.Check("bot", []string{"members"}, notManager); err == nil {
+		t.Errorf("Members without an allowed role shouldn't be allowed")
+	}
+	if err := p.Check("bot", nil, func() bool { return true }); err != nil {
+		t.Errorf("Managers should be allowed without an allowed role, got %s", err)
+	}
+	if err := p.Check("general", []string{"mods"}, notManager); err == nil {
+		t.Errorf("Denied channels shouldn't be allowed")
+	}
+	if err := p.Check("memes", []string{"mods"}, notManager); err == nil {
+		t.Errorf("Channels outside the allowed ones shouldn't be allowed")
+	}
+
+	p.Set("channels", []string{"all"})
+	p.Set("deny", []string{"none"})
+	p.Set("access", []string{"everyone"})
+	if err := p.Check("general", nil, notManager); err != nil {
+		t.Errorf("Everyone should be allowed everywhere after clearing the rules, got %s", err)
+	}
+	if err := p.Set("access", []string{"nobody"}); err == nil {
+		t.Errorf("Setting access to an invalid value should fail")
+	}
+}
+
+func TestConfigStorePermissions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-permissions")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+
+	store, _ := OpenConfigStore(path)
+	before := store.Get("guild")
+	if _, err := store.SetPermission("guild", "stats", "roles", []string{"mods"}); err != nil {
+		t.Errorf("Got an error setting a permission: %s", err)
+	}
+	if _, err := store.SetPermission("guild", "unknown", "roles", []string{"mods"}); err == nil {
+		t.Errorf("Setting the permission of an unknown command should fail")
+	}
+	if before.Permission("stats").Access != accessEveryone {
+		t.Errorf("Settings already handed out shouldn't change")
+	}
+
+	reopened, _ := OpenConfigStore(path)
+	if p := reopened.Get("guild").Permission("stats"); p.Access != accessRoles || len(p.Roles) != 1 {
+		t.Errorf("Permission not correctly persisted, expected stats for mods got %v", p)
+	}
+
+	reopened.ResetPermission("guild", "stats")
+	if p := reopened.Get("guild").Permission("stats"); p.Access != accessEveryone {
+		t.Errorf("Permission not correctly reset, expected stats for everyone got %v", p)
+	}
+}
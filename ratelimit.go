@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// budgetWindow is how long it takes for a spent budget to fully refill
+const budgetWindow = time.Hour
+
+// ErrOverBudget is returned for commands that cost more than a whole budget, so waiting won't help
+var ErrOverBudget = errors.New("ratelimit: command costs more than the budget")
+
+// bucket tracks how much of its budget a user or guild has left
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	lastUsed time.Time
+}
+
+// RateLimiter enforces cooldowns between commands and hourly budgets of Twitch requests, both per user and per
+// guild. A zero cooldown or budget disables it. It is safe for concurrent use.
+type RateLimiter struct {
+	mu            sync.Mutex
+	userCooldown  time.Duration
+	guildCooldown time.Duration
+	userBudget    int
+	guildBudget   int
+	users         map[string]*bucket
+	guilds        map[string]*bucket
+	lastPrune     time.Time
+	now           func() time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with the given cooldowns and hourly budgets
+func NewRateLimiter(userCooldown time.Duration, guildCooldown time.Duration, userBudget int, guildBudget int) *RateLimiter {
+	return &RateLimiter{
+		userCooldown:  userCooldown,
+		guildCooldown: guildCooldown,
+		userBudget:    userBudget,
+		guildBudget:   guildBudget,
+		users:         make(map[string]*bucket),
+		guilds:        make(map[string]*bucket),
+		now:           time.Now,
+	}
+}
+
+// Allow charges a command of the given cost to the user and guild. If either of them is cooling down or can't
+// afford it yet, nothing is charged and Allow returns how long to wait instead. Commands that cost more than
+// a whole budget return ErrOverBudget.
+func (rl *RateLimiter) Allow(guildID string, userID string, cost int) (time.Duration, error) {
+	if (rl.userBudget > 0 && cost > rl.userBudget) || (rl.guildBudget > 0 && guildID != "" && cost > rl.guildBudget) {
+		return 0, ErrOverBudget
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.prune(now)
+
+	user := rl.bucket(rl.users, userID, rl.userBudget, now)
+	wait := user.wait(cost, rl.userCooldown, rl.userBudget, now)
+	var guild *bucket
+	if guildID != "" {
+		guild = rl.bucket(rl.guilds, guildID, rl.guildBudget, now)
+		if guildWait := guild.wait(cost, rl.guildCooldown, rl.guildBudget, now); guildWait > wait {
+			wait = guildWait
+		}
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	user.spend(cost, now)
+	if guild != nil {
+		guild.spend(cost, now)
+	}
+	return 0, nil
+}
+
+// Refund gives back the cost of a command that was charged but never ran. Cooldowns still apply.
+func (rl *RateLimiter) Refund(guildID string, userID string, cost int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if user, ok := rl.users[userID]; ok {
+		user.refund(cost, rl.userBudget, now)
+	}
+	if guild, ok := rl.guilds[guildID]; ok && guildID != "" {
+		guild.refund(cost, rl.guildBudget, now)
+	}
+}
+
+func (rl *RateLimiter) bucket(buckets map[string]*bucket, key string, budget int, now time.Time) *bucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(budget), updated: now}
+		buckets[key] = b
+	}
+	b.refill(budget, now)
+	return b
+}
+
+// prune forgets the buckets that have fully refilled and cooled down, as they're the same as new ones
+func (rl *RateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < budgetWindow {
+		return
+	}
+	rl.lastPrune = now
+	for key, b := range rl.users {
+		b.refill(rl.userBudget, now)
+		if b.tokens >= float64(rl.userBudget) && now.Sub(b.lastUsed) >= rl.userCooldown {
+			delete(rl.users, key)
+		}
+	}
+	for key, b := range rl.guilds {
+		b.refill(rl.guildBudget, now)
+		if b.tokens >= float64(rl.guildBudget) && now.Sub(b.lastUsed) >= rl.guildCooldown {
+			delete(rl.guilds, key)
+		}
+	}
+}
+
+func (b *bucket) refill(budget int, now time.Time) {
+	b.tokens += float64(budget) * now.Sub(b.updated).Seconds() / budgetWindow.Seconds()
+	if b.tokens > float64(budget) {
+		b.tokens = float64(budget)
+	}
+	b.updated = now
+}
+
+// wait returns how long until the bucket is out of its cooldown and can afford cost
+func (b *bucket) wait(cost int, cooldown time.Duration, budget int, now time.Time) time.Duration {
+	var wait time.Duration
+	if !b.lastUsed.IsZero() {
+		wait = b.lastUsed.Add(cooldown).Sub(now)
+	}
+	if budget > 0 && b.tokens < float64(cost) {
+		refill := time.Duration((float64(cost) - b.tokens) / float64(budget) * float64(budgetWindow))
+		if refill > wait {
+			wait = refill
+		}
+	}
+	return wait
+}
+
+func (b *bucket) spend(cost int, now time.Time) {
+	b.tokens -= float64(cost)
+	b.lastUsed = now
+}
+
+func (b *bucket) refund(cost int, budget int, now time.Time) {
+	b.refill(budget, now)
+	b.tokens += float64(cost)
+	if b.tokens > float64(budget) {
+		b.tokens = float64(budget)
+	}
+}
+
+// EstimateCost estimates how many Twitch requests a command takes. Searches are assumed to go through about a page
+// of clips per day they cover, starting at defaultStart when the command has no dates.
+func EstimateCost(c Command, defaultStart time.Time, now time.Time) int {
+	switch c.SubCommand {
+	case "help":
+		return 0
+	case "", "top", "stats":
+		startedAt, endedAt := c.StartedAt, c.EndedAt
+		if startedAt.IsZero() {
+			startedAt = defaultStart
+		}
+		if endedAt.IsZero() || endedAt.After(now) {
+			endedAt = now
+		}
+		days := math.Ceil(endedAt.Sub(startedAt).Hours() / 24)
+		if days < 0 {
+			days = 0
+		}
+		// One more request to look the broadcaster up
+		return 1 + int(days)
+	case "clip":
+		// The clip, its VOD and its game
+		return 3
+	case "vod":
+		// The video and the couple of days of clips after it was streamed
+		return 3
+	}
+	return 1
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterCooldown(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(5*time.Second, time.Second, 0, 0)
+	limiter.now = func() time.Time { return now }
+
+	if wait, err := limiter.Allow("guild", "user", 1); wait != 0 || err != nil {
+		t.Errorf("The first command should be allowed, got a wait of %s and error %v", wait, err)
+	}
+	now = now.Add(2 * time.Second)
+	if wait, _ := limiter.Allow("guild", "user", 1); wait != 3*time.Second {
+		t.Errorf("User cooldown not properly enforced: expected a wait of 3s got %s", wait)
+	}
+	if wait, _ := limiter.Allow("guild", "other-user", 1); wait != 0 {
+		t.Errorf("Other users should be allowed once the guild cooled down, got a wait of %s", wait)
+	}
+	if wait, _ := limiter.Allow("guild", "third-user", 1); wait != time.Second {
+		t.Errorf("Guild cooldown not properly enforced: expected a wait of 1s got %s", wait)
+	}
+	now = now.Add(3 * time.Second)
+	if wait, _ := limiter.Allow("guild", "user", 1); wait != 0 {
+		t.Errorf("The user should be allowed after the cooldown, got a wait of %s", wait)
+	}
+}
+
+func TestRateLimiterBudget(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(0, 0, 100, 150)
+	limiter.now = func() time.Time { return now }
+
+	if _, err := limiter.Allow("guild", "user", 101); err != ErrOverBudget {
+		t.Errorf("Commands over the user budget should be rejected, got %v", err)
+	}
+	if wait, _ := limiter.Allow("guild", "user", 80); wait != 0 {
+		t.Errorf("Commands within budget should be allowed, got a wait of %s", wait)
+	}
+	// 60 more needs 40 refilled, which at 100 per hour takes 24 minutes
+	if wait, _ := limiter.Allow("guild", "user", 60); wait != 24*time.Minute {
+		t.Errorf("User budget not properly enforced: expected a wait of 24m got %s", wait)
+	}
+	if wait, _ := limiter.Allow("guild", "other-user", 60); wait != 0 {
+		t.Errorf("Other users should have their own budget, got a wait of %s", wait)
+	}
+	// The guild has 10 left and 50 more takes 20 minutes to refill at 150 per hour
+	if wait, _ := limiter.Allow("guild", "third-user", 60); wait != 20*time.Minute {
+		t.Errorf("Guild budget not properly enforced: expected a wait of 20m got %s", wait)
+	}
+	if wait, _ := limiter.Allow("", "third-user", 60); wait != 0 {
+		t.Errorf("Direct messages shouldn't be charged to a guild, got a wait of %s", wait)
+	}
+
+	now = now.Add(24 * time.Minute)
+	if wait, _ := limiter.Allow("other-guild", "user", 60); wait != 0 {
+		t.Errorf("The user budget should refill over time, got a wait of %s", wait)
+	}
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(0, 0, 100, 100)
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("guild", "user", 80)
+	limiter.Refund("guild", "user", 80)
+	if wait, _ := limiter.Allow("guild", "user", 80); wait != 0 {
+		t.Errorf("Refunded cost should be available again, got a wait of %s", wait)
+	}
+	limiter.Refund("guild", "user", 500)
+	if wait, _ := limiter.Allow("guild", "user", 100); wait != 0 {
+		t.Errorf("Refunds should fill the budget back up, got a wait of %s", wait)
+	}
+	if wait, _ := limiter.Allow("guild", "user", 1); wait == 0 {
+		t.Errorf("Refunds should not go over the budget")
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	weekAgo := now.AddDate(0, 0, -7)
+
+	commands := map[int]Command{
+		0:   {SubCommand: "help"},
+		8:   {SubCommand: "top"},
+		31:  {SubCommand: "stats", StartedAt: now.AddDate(0, -1, 0), EndedAt: now.AddDate(0, 0, -1)},
+		367: {StartedAt: now.AddDate(-1, 0, 0)},
+		3:   {SubCommand: "clip"},
+		1:   {SubCommand: "watches"},
+	}
+	for expected, c := range commands {
+		if cost := EstimateCost(c, weekAgo, now); cost != expected {
+			t.Errorf("Cost of %v not properly estimated: expected %d got %d", c, expected, cost)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// identifyInterval is how long to wait between opening shards, as Discord only lets a bot identify once every
+// 5 seconds
+const identifyInterval = 5 * time.Second
+
+// ShardForGuild returns the shard Discord sends a guild's events to. Direct messages go to shard 0.
+func ShardForGuild(guildID string, count int) int {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil || count <= 1 {
+		return 0
+	}
+	return int((id >> 22) % uint64(count))
+}
+
+// ParseShardIDs parses a list of shard IDs and ranges, like "0,2,4-7", for a bot with count shards. An empty
+// list means every shard.
+func ParseShardIDs(list string, count int) ([]int, error) {
+	if count < 1 {
+		return nil, errors.New("shards: shard count must be at least 1")
+	}
+	if strings.TrimSpace(list) == "" {
+		ids := make([]int, count)
+		for i := range ids {
+			ids[i] = i
+		}
+		return ids, nil
+	}
+
+	seen := make(map[int]bool)
+	var ids []int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		first, last := part, part
+		if dash := strings.Index(part, "-"); dash > 0 {
+			first, last = part[:dash], part[dash+1:]
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, errors.New("shards: invalid shard \"" + part + "\"")
+		}
+		to, err := strconv.Atoi(last)
+		if err != nil || to < from {
+			return nil, errors.New("shards: invalid shard range \"" + part + "\"")
+		}
+		if from < 0 || to >= count {
+			return nil, errors.New("shards: shard \"" + part + "\" is out of range for " + strconv.Itoa(count) + " shards")
+		}
+		for id := from; id <= to; id++ {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// guildFilter reports whether this process handles a guild. A nil guildFilter handles every guild.
+type guildFilter func(guildID string) bool
+
+func (f guildFilter) handles(guildID string) bool {
+	return f == nil || f(guildID)
+}
+
+// ShardManager runs the Discord gateway shards assigned to this process, with a session each. Guilds whose
+// shard runs in another process are left to it.
+type ShardManager struct {
+	count    int
+	ids      []int
+	sessions map[int]*discordgo.Session
+}
+
+// ShardStatus describes the state of a shard
+type ShardStatus struct {
+	ID        int           `json:"id"`
+	Connected bool          `json:"connected"`
+	Guilds    int           `json:"guilds"`
+	Latency   time.Duration `json:"latency"`
+}
+
+// NewShardManager creates a session for each of the shard IDs of a bot with count shards. The sessions share
+// base's HTTP client and REST rate limits, and setup is called with each of them to add its handlers.
+func NewShardManager(base *discordgo.Session, count int, ids []int, setup func(*discordgo.Session)) (*ShardManager, error) {
+	sm := &ShardManager{count: count, ids: ids, sessions: make(map[int]*discordgo.Session)}
+	for _, id := range ids {
+		s, err := discordgo.New(base.Token)
+		if err != nil {
+			return nil, err
+		}
+		s.ShardID, s.ShardCount = id, count
+		s.Client = base.Client
+		s.Ratelimiter = base.Ratelimiter
+		setup(s)
+		sm.sessions[id] = s
+	}
+	return sm, nil
+}
+
+// Open connects every shard to the gateway, one at a time
+func (sm *ShardManager) Open() error {
+	for i, id := range sm.ids {
+		if i > 0 {
+			time.Sleep(identifyInterval)
+		}
+		Log.Info("Opening shard", "shard", id, "shards", sm.count)
+		if err := sm.sessions[id].Open(); err != nil {
+			return errors.New("shards: couldn't open shard " + strconv.Itoa(id) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Close disconnects every shard from the gateway
+func (sm *ShardManager) Close() {
+	for _, id := range sm.ids {
+		sm.sessions[id].Close()
+	}
+}
+
+// IDs returns the IDs of the shards run by this process
+func (sm *ShardManager) IDs() []int {
+	return sm.ids
+}
+
+// Owns reports whether a guild's shard runs in this process, so that each guild is handled by exactly one
+func (sm *ShardManager) Owns(guildID string) bool {
+	_, ok := sm.sessions[ShardForGuild(guildID, sm.count)]
+	return ok
+}
+
+// guildStore is a store of per-guild data
+type guildStore interface {
+	GuildIDs() []string
+}
+
+// ForeignGuilds returns the guilds the stores hold data for whose shard runs in another process, sorted. Each
+// process keeps its data in its own data dir, so changing the shard count or IDs leaves the data of the guilds that
+// moved where their new process can't see it.
+func (sm *ShardManager) ForeignGuilds(stores ...guildStore) []string {
+	seen := make(map[string]bool)
+	var foreign []string
+	for _, store := range stores {
+		for _, guildID := range store.GuildIDs() {
+			if !seen[guildID] && !sm.Owns(guildID) {
+				foreign = append(foreign, guildID)
+			}
+			seen[guildID] = true
+		}
+	}
+	sort.Strings(foreign)
+	return foreign
+}
+
+// Session returns the session of a guild's shard. The REST API isn't tied to shards, so for guilds owned by
+// another process it returns any session, which can still send messages.
+func (sm *ShardManager) Session(guildID string) *discordgo.Session {
+	if s, ok := sm.sessions[ShardForGuild(guildID, sm.count)]; ok {
+		return s
+	}
+	return sm.sessions[sm.ids[0]]
+}
+
+// Status returns the state of each shard run by this process
+func (sm *ShardManager) Status() []ShardStatus {
+	statuses := make([]ShardStatus, 0, len(sm.ids))
+	for _, id := range sm.ids {
+		s := sm.sessions[id]
+		s.RLock()
+		status := ShardStatus{ID: id, Connected: s.DataReady, Latency: s.HeartbeatLatency()}
+		s.RUnlock()
+		if s.State != nil {
+			s.State.RLock()
+			status.Guilds = len(s.State.Guilds)
+			s.State.RUnlock()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
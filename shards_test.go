@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestShardForGuild(t *testing.T) {
+	tests := []struct {
+		guildID  string
+		count    int
+		expected int
+	}{
+		{"81384788765712384", 16, 2},
+		{"41771983423143937", 16, 6},
+		{"41771983423143937", 1, 0},
+		{"", 16, 0},
+	}
+	for _, test := range tests {
+		if shard := ShardForGuild(test.guildID, test.count); shard != test.expected {
+			t.Errorf("ShardForGuild(%q, %d) expected %d got %d", test.guildID, test.count, test.expected, shard)
+		}
+	}
+}
+
+func TestParseShardIDs(t *testing.T) {
+	tests := []struct {
+		list     string
+		count    int
+		expected []int
+	}{
+		{"", 3, []int{0, 1, 2}},
+		{"0,2", 4, []int{0, 2}},
+		{"4-7, 1, 5", 8, []int{1, 4, 5, 6, 7}},
+	}
+	for _, test := range tests {
+		ids, err := ParseShardIDs(test.list, test.count)
+		if err != nil || !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("ParseShardIDs(%q, %d) expected %v got %v, %v", test.list, test.count, test.expected, ids, err)
+		}
+	}
+
+	for _, list := range []string{"a", "3-1", "0-4", "-1"} {
+		if _, err := ParseShardIDs(list, 4); err == nil {
+			t.Errorf("ParseShardIDs(%q, 4) should fail", list)
+		}
+	}
+	if _, err := ParseShardIDs("", 0); err == nil {
+		t.Errorf("ParseShardIDs should fail without shards")
+	}
+}
+
+func TestShardManager(t *testing.T) {
+	base, _ := discordgo.New("Bot token")
+	var setUp []int
+	sm, err := NewShardManager(base, 16, []int{2, 3}, func(s *discordgo.Session) {
+		setUp = append(setUp, s.ShardID)
+	})
+	if err != nil {
+		t.Fatalf("Got an error from NewShardManager: %s", err)
+	}
+	if !reflect.DeepEqual(setUp, []int{2, 3}) {
+		t.Errorf("Every shard should be set up, expected [2 3] got %v", setUp)
+	}
+
+	if !sm.Owns("81384788765712384") || sm.Owns("41771983423143937") {
+		t.Errorf("Shards should only own the guilds Discord sends them")
+	}
+	if s := sm.Session("81384788765712384"); s.ShardID != 2 || s.ShardCount != 16 || s.Client != base.Client {
+		t.Errorf("Session of a guild's shard not properly returned, got shard %d of %d", s.ShardID, s.ShardCount)
+	}
+	if s := sm.Session("41771983423143937"); s == nil {
+		t.Errorf("Guilds of other processes should still get a session to send messages with")
+	}
+
+	statuses := sm.Status()
+	if len(statuses) != 2 || statuses[0].ID != 2 || statuses[0].Connected {
+		t.Errorf("Status not properly reported, got %+v", statuses)
+	}
+}
+
+type guildIDs []string
+
+func (g guildIDs) GuildIDs() []string {
+	return g
+}
+
+func TestShardManagerForeignGuilds(t *testing.T) {
+	base, _ := discordgo.New("Bot token")
+	sm, err := NewShardManager(base, 16, []int{2, 3}, func(s *discordgo.Session) {})
+	if err != nil {
+		t.Fatalf("Got an error from NewShardManager: %s", err)
+	}
+
+	if foreign := sm.ForeignGuilds(guildIDs{"81384788765712384"}); len(foreign) != 0 {
+		t.Errorf("Guilds of this process' shards aren't foreign, got %v", foreign)
+	}
+	foreign := sm.ForeignGuilds(guildIDs{"81384788765712384", "41771983423143937"}, guildIDs{"41771983423143937"})
+	if !reflect.DeepEqual(foreign, []string{"41771983423143937"}) {
+		t.Errorf("Guilds of other processes' shards should be reported once, expected [41771983423143937] got %v", foreign)
+	}
+}
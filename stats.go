@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// statsSampleSize is how many view counts ClipStats keeps to estimate the median
+const statsSampleSize = 1000
+
+// ClipStats accumulates statistics over the clips of a broadcaster without keeping the clips themselves
+type ClipStats struct {
+	StartedAt  time.Time
+	EndedAt    time.Time
+	TotalClips int
+	TotalViews int
+	// views is a uniform random sample of the view counts added, so memory stays bounded however many clips
+	// there are
+	views     []int
+	days      map[string]int
+	games     map[string]int
+	languages map[string]int
+}
+
+// StatCount is a value along with the number of clips it was seen in
+type StatCount struct {
+	Value string
+	Count int
+}
+
+// NewClipStats returns an empty ClipStats for the given date range
+func NewClipStats(startedAt time.Time, endedAt time.Time) *ClipStats {
+	return &ClipStats{
+		StartedAt: startedAt,
+		EndedAt:   endedAt,
+		days:      make(map[string]int),
+		games:     make(map[string]int),
+		languages: make(map[string]int),
+	}
+}
+
+// Add includes a clip in the statistics
+func (cs *ClipStats) Add(clip Clip) {
+	cs.TotalClips++
+	cs.TotalViews += clip.ViewCount
+	// Reservoir sampling: the nth clip replaces a random sampled one with probability statsSampleSize/n
+	if len(cs.views) < statsSampleSize {
+		cs.views = append(cs.views, clip.ViewCount)
+	} else if i := rand.Intn(cs.TotalClips); i < statsSampleSize {
+		cs.views[i] = clip.ViewCount
+	}
+
+	if createdAt, err := time.Parse(time.RFC3339, clip.CreatedAt); err == nil {
+		// Days are counted in the timezone the stats were requested in
+		cs.days[createdAt.In(cs.StartedAt.Location()).Format("2006-01-02")]++
+	}
+	if clip.GameID != "" {
+		cs.games[clip.GameID]++
+	}
+	if clip.Language != "" {
+		cs.languages[clip.Language]++
+	}
+}
+
+// MedianViews returns the median view count of the clips added. It is exact up to statsSampleSize clips and
+// estimated from a random sample of them past that, see MedianApproximate.
+func (cs *ClipStats) MedianViews() float64 {
+	if len(cs.views) == 0 {
+		return 0
+	}
+	sort.Ints(cs.views)
+
+	middle := len(cs.views) / 2
+	if len(cs.views)%2 == 0 {
+		return float64(cs.views[middle-1]+cs.views[middle]) / 2
+	}
+	return float64(cs.views[middle])
+}
+
+// MedianApproximate reports whether MedianViews is estimated from a sample instead of every clip
+func (cs *ClipStats) MedianApproximate() bool {
+	return cs.TotalClips > statsSampleSize
+}
+
+// ClipsPerDay returns the average number of clips created per day in the date range
+func (cs *ClipStats) ClipsPerDay() float64 {
+	days := cs.EndedAt.Sub(cs.StartedAt).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	return float64(cs.TotalClips) / days
+}
+
+// BusiestDay returns the day, formatted as YYYY-MM-DD, with the most clips created
+func (cs *ClipStats) BusiestDay() StatCount {
+	busiest := sortCounts(cs.days)
+	if len(busiest) == 0 {
+		return StatCount{}
+	}
+	return busiest[0]
+}
+
+// TopGames returns up to n game IDs with the most clips
+func (cs *ClipStats) TopGames(n int) []StatCount {
+	return truncateCounts(sortCounts(cs.games), n)
+}
+
+// TopLanguages returns up to n languages with the most clips
+func (cs *ClipStats) TopLanguages(n int) []StatCount {
+	return truncateCounts(sortCounts(cs.languages), n)
+}
+
+func sortCounts(m map[string]int) []StatCount {
+	counts := make([]StatCount, 0, len(m))
+	for value, count := range m {
+		counts = append(counts, StatCount{Value: value, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count == counts[j].Count {
+			return counts[i].Value < counts[j].Value
+		}
+		return counts[i].Count > counts[j].Count
+	})
+	return counts
+}
+
+func truncateCounts(counts []StatCount, n int) []StatCount {
+	if len(counts) > n {
+		return counts[:n]
+	}
+	return counts
+}
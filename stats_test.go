@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClipStats(t *testing.T) {
+	started, _ := time.Parse("2006-01-02", "2020-06-01")
+	ended, _ := time.Parse("2006-01-02", "2020-06-11")
+	stats := NewClipStats(started, ended)
+
+	clips := []Clip{
+		{ViewCount: 10, CreatedAt: "2020-06-02T10:00:00Z", GameID: "1", Language: "en"},
+		{ViewCount: 30, CreatedAt: "2020-06-02T12:00:00Z", GameID: "2", Language: "en"},
+		{ViewCount: 20, CreatedAt: "2020-06-05T10:00:00Z", GameID: "1", Language: "es"},
+		{ViewCount: 40, CreatedAt: "2020-06-06T10:00:00Z", GameID: "1", Language: "en"},
+	}
+	for _, clip := range clips {
+		stats.Add(clip)
+	}
+
+	if stats.TotalClips != 4 {
+		t.Errorf("TotalClips not properly computed: expected 4 got %d", stats.TotalClips)
+	}
+	if stats.TotalViews != 100 {
+		t.Errorf("TotalViews not properly computed: expected 100 got %d", stats.TotalViews)
+	}
+	if stats.MedianViews() != 25 {
+		t.Errorf("MedianViews not properly computed: expected 25 got %f", stats.MedianViews())
+	}
+	if stats.ClipsPerDay() != 0.4 {
+		t.Errorf("ClipsPerDay not properly computed: expected 0.4 got %f", stats.ClipsPerDay())
+	}
+	if busiest := stats.BusiestDay(); busiest.Value != "2020-06-02" || busiest.Count != 2 {
+		t.Errorf("BusiestDay not properly computed: expected 2020-06-02 with 2 clips got %v", busiest)
+	}
+
+	games := stats.TopGames(1)
+	if len(games) != 1 || games[0].Value != "1" || games[0].Count != 3 {
+		t.Errorf("TopGames not properly computed: expected [{1 3}] got %v", games)
+	}
+	languages := stats.TopLanguages(5)
+	if len(languages) != 2 || languages[0].Value != "en" || languages[1].Value != "es" {
+		t.Errorf("TopLanguages not properly computed: expected [{en 3} {es 1}] got %v", languages)
+	}
+}
+
+func TestClipStatsEmpty(t *testing.T) {
+	stats := NewClipStats(time.Now().AddDate(0, 0, -7), time.Now())
+
+	if stats.MedianViews() != 0 {
+		t.Errorf("MedianViews not properly computed: expected 0 got %f", stats.MedianViews())
+	}
+	if busiest := stats.BusiestDay(); busiest.Value != "" {
+		t.Errorf("BusiestDay not properly computed: expected \"\" got %s", busiest.Value)
+	}
+}
+
+func TestClipStatsMedianSample(t *testing.T) {
+	stats := NewClipStats(time.Now().AddDate(0, 0, -7), time.Now())
+	for views := 1; views <= 100*statsSampleSize; views++ {
+		stats.Add(Clip{ViewCount: views})
+	}
+
+	if len(stats.views) != statsSampleSize {
+		t.Errorf("Expected at most %d sampled view counts, got %d", statsSampleSize, len(stats.views))
+	}
+	if !stats.MedianApproximate() {
+		t.Errorf("Expected the median to be reported as approximate")
+	}
+	// The sampled median is well within 10% of the true one, 50000.5
+	if median := stats.MedianViews(); median < 40000 || median > 60000 {
+		t.Errorf("MedianViews not properly estimated: expected about 50000 got %f", median)
+	}
+}
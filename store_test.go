@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndLoadJSON(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-store")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nested", "state.json")
+
+	if err := saveJSON(path, map[string]int{"views": 42}); err != nil {
+		t.Errorf("Got an error saving JSON: %s", err)
+	}
+
+	loaded := map[string]int{}
+	if err := loadJSON(path, &loaded); err != nil {
+		t.Errorf("Got an error loading JSON: %s", err)
+	}
+	if loaded["views"] != 42 {
+		t.Errorf("JSON not properly loaded: expected 42 got %d", loaded["views"])
+	}
+
+	missing := map[string]int{"views": 1}
+	if err := loadJSON(filepath.Join(dir, "missing.json"), &missing); err != nil {
+		t.Errorf("Loading a missing file should not return an error, got: %s", err)
+	}
+	if missing["views"] != 1 {
+		t.Errorf("Loading a missing file should leave the value untouched, got %v", missing)
+	}
+}
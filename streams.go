@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// maxStreamsPerRequest is the maximum number of user IDs Twitch accepts in a single Get Streams request
+const maxStreamsPerRequest = 100
+
+// StreamsResponse represents a response from a request to Twitch's Get Streams
+type StreamsResponse struct {
+	Data []Stream `json:"data"`
+}
+
+// Stream represents a live Twitch stream
+type Stream struct {
+	ID           string `json:"id"`
+	UserID       string `json:"user_id"`
+	UserLogin    string `json:"user_login"`
+	UserName     string `json:"user_name"`
+	GameID       string `json:"game_id"`
+	GameName     string `json:"game_name"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	ViewerCount  int    `json:"viewer_count"`
+	StartedAt    string `json:"started_at"`
+	Language     string `json:"language"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// Thumbnail returns the URL of the stream's thumbnail with the given dimensions
+func (s Stream) Thumbnail(width int, height int) string {
+	r := strings.NewReplacer("{width}", strconv.Itoa(width), "{height}", strconv.Itoa(height))
+	return r.Replace(s.ThumbnailURL)
+}
+
+// GetStreamsByUserID returns the live streams of the given broadcasters. Offline broadcasters are left out.
+func (t TwitchAPI) GetStreamsByUserID(userIDs []string) ([]Stream, error) {
+	var streams []Stream
+	for len(userIDs) > 0 {
+		batch := userIDs
+		if len(batch) > maxStreamsPerRequest {
+			batch = batch[:maxStreamsPerRequest]
+		}
+		userIDs = userIDs[len(batch):]
+
+		found, err := t.requestStreams(batch)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, found...)
+	}
+
+	return streams, nil
+}
+
+func (t TwitchAPI) requestStreams(userIDs []string) ([]Stream, error) {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/streams"
+
+	q := endpoint.Query()
+	for _, userID := range userIDs {
+		q.Add("user_id", userID)
+	}
+	q.Set("first", strconv.Itoa(maxStreamsPerRequest))
+	endpoint.RawQuery = q.Encode()
+
+	req := t.prepareRequest("GET", endpoint.String())
+	Log.Debug("Twitch request", "method", req.Method, "url", req.URL)
+
+	jsonResponse, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer jsonResponse.Body.Close()
+	if err := checkResponse(jsonResponse); err != nil {
+		return nil, err
+	}
+
+	resp := StreamsResponse{}
+	json.NewDecoder(jsonResponse.Body).Decode(&resp)
+	return resp.Data, nil
+}
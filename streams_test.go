@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestGetStreamsByUserID(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		res := StreamsResponse{}
+		for _, id := range r.URL.Query()["user_id"] {
+			// Only even broadcasters are live
+			if n, _ := strconv.Atoi(id); n%2 == 0 {
+				res.Data = append(res.Data, Stream{ID: "stream-" + id, UserID: id})
+			}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	var userIDs []string
+	for i := 0; i < 150; i++ {
+		userIDs = append(userIDs, strconv.Itoa(i))
+	}
+	streams, err := twitch.GetStreamsByUserID(userIDs)
+	if err != nil {
+		t.Errorf("Got an error from GetStreamsByUserID: %s", err)
+	}
+	if requests != 2 {
+		t.Errorf("Streams not requested in batches of 100, expected 2 requests got %d", requests)
+	}
+	if len(streams) != 75 {
+		t.Errorf("Streams not correctly returned by GetStreamsByUserID, expected 75 got %d", len(streams))
+	}
+}
+
+func TestStreamThumbnail(t *testing.T) {
+	stream := Stream{ThumbnailURL: "https://some.fancy/live_user_streamer-{width}x{height}.jpg"}
+
+	expected := "https://some.fancy/live_user_streamer-1280x720.jpg"
+	if thumbnail := stream.Thumbnail(1280, 720); thumbnail != expected {
+		t.Errorf("Thumbnail URL not properly built: expected \"%s\" got %s", expected, thumbnail)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// prefixMatchWeight scales the score of query tokens that only match the start of an indexed token, so whole
+// word matches rank first
+const prefixMatchWeight = 0.1
+
+// TextIndex is an inverted index over the titles and creator names of clips.
+// It is not safe for concurrent use, ClipIndex guards it with its own lock.
+type TextIndex struct {
+	titles   *fieldIndex
+	creators *fieldIndex
+}
+
+// TextMatch is a clip ID along with its relevance to a query
+type TextMatch struct {
+	ClipID string
+	Score  float64
+}
+
+// fieldIndex maps the tokens of a single clip field to the clips they appear in
+type fieldIndex struct {
+	postings  map[string]map[string]int
+	docTokens map[string][]string
+	tokens    []string
+	sorted    bool
+}
+
+// NewTextIndex returns an empty TextIndex
+func NewTextIndex() *TextIndex {
+	return &TextIndex{titles: newFieldIndex(), creators: newFieldIndex()}
+}
+
+// Add indexes the title and creator name of clip, replacing any previous version of it
+func (ti *TextIndex) Add(clip Clip) {
+	ti.titles.add(clip.ID, tokenize(clip.Title))
+	ti.creators.add(clip.ID, tokenize(clip.CreatorName))
+}
+
+// Len returns the number of clips indexed
+func (ti *TextIndex) Len() int {
+	return len(ti.titles.docTokens)
+}
+
+// Search returns the clips whose title matches every token in titleQuery and whose creator matches every
+// token in creatorQuery, most relevant first. Query tokens also match indexed tokens they are a prefix of.
+// An empty query matches every clip.
+func (ti *TextIndex) Search(titleQuery string, creatorQuery string) []TextMatch {
+	titleScores := ti.titles.search(tokenize(titleQuery))
+	creatorScores := ti.creators.search(tokenize(creatorQuery))
+
+	var matches []TextMatch
+	for clipID, score := range titleScores {
+		if creatorScore, ok := creatorScores[clipID]; ok {
+			matches = append(matches, TextMatch{ClipID: clipID, Score: score + creatorScore})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].ClipID < matches[j].ClipID
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+func newFieldIndex() *fieldIndex {
+	return &fieldIndex{
+		postings:  make(map[string]map[string]int),
+		docTokens: make(map[string][]string),
+	}
+}
+
+func (fi *fieldIndex) add(clipID string, tokens []string) {
+	fi.remove(clipID)
+	fi.docTokens[clipID] = tokens
+
+	for _, token := range tokens {
+		if _, ok := fi.postings[token]; !ok {
+			fi.postings[token] = make(map[string]int)
+			fi.sorted = false
+		}
+		fi.postings[token][clipID]++
+	}
+}
+
+func (fi *fieldIndex) remove(clipID string) {
+	for _, token := range fi.docTokens[clipID] {
+		delete(fi.postings[token], clipID)
+		if len(fi.postings[token]) == 0 {
+			delete(fi.postings, token)
+			fi.sorted = false
+		}
+	}
+	delete(fi.docTokens, clipID)
+}
+
+// search scores every clip containing all query tokens using TF-IDF, or every clip with a zero score
+// when there are no query tokens
+func (fi *fieldIndex) search(queryTokens []string) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(queryTokens) == 0 {
+		for clipID := range fi.docTokens {
+			scores[clipID] = 0
+		}
+		return scores
+	}
+
+	for i, queryToken := range queryTokens {
+		tokenScores := make(map[string]float64)
+		for _, token := range fi.expand(queryToken) {
+			weight := 1.0
+			if token != queryToken {
+				weight = prefixMatchWeight
+			}
+			idf := math.Log(1 + float64(len(fi.docTokens))/float64(len(fi.postings[token])))
+			for clipID, frequency := range fi.postings[token] {
+				tokenScores[clipID] += weight * idf * float64(frequency) / math.Sqrt(float64(len(fi.docTokens[clipID])))
+			}
+		}
+
+		if i == 0 {
+			scores = tokenScores
+			continue
+		}
+		for clipID := range scores {
+			if score, ok := tokenScores[clipID]; ok {
+				scores[clipID] += score
+			} else {
+				delete(scores, clipID)
+			}
+		}
+	}
+	return scores
+}
+
+// expand returns every indexed token that starts with prefix
+func (fi *fieldIndex) expand(prefix string) []string {
+	if !fi.sorted {
+		fi.tokens = fi.tokens[:0]
+		for token := range fi.postings {
+			fi.tokens = append(fi.tokens, token)
+		}
+		sort.Strings(fi.tokens)
+		fi.sorted = true
+	}
+
+	var tokens []string
+	for i := sort.SearchStrings(fi.tokens, prefix); i < len(fi.tokens) && strings.HasPrefix(fi.tokens[i], prefix); i++ {
+		tokens = append(tokens, fi.tokens[i])
+	}
+	return tokens
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("Super FUNNY clip!! (part 2) ¡Qué gol!")
+	expected := []string{"super", "funny", "clip", "part", "2", "qué", "gol"}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("Text not properly tokenized: expected %v got %v", expected, tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("Text not properly tokenized: expected %v got %v", expected, tokens)
+		}
+	}
+}
+
+func TestTextIndexSearch(t *testing.T) {
+	index := NewTextIndex()
+	index.Add(Clip{ID: "funny", Title: "Super funny clip", CreatorName: "Creator"})
+	index.Add(Clip{ID: "funniest", Title: "Funniest moment ever", CreatorName: "Someone"})
+	index.Add(Clip{ID: "sad", Title: "Sad clip", CreatorName: "Creator"})
+
+	matches := index.Search("funny", "")
+	if len(matches) != 1 || matches[0].ClipID != "funny" {
+		t.Errorf("Exact token not properly matched: expected [funny] got %v", matches)
+	}
+
+	matches = index.Search("fun", "")
+	if len(matches) != 2 {
+		t.Errorf("Prefix query not properly matched: expected 2 matches got %v", matches)
+	}
+
+	matches = index.Search("clip", "creator")
+	if len(matches) != 2 {
+		t.Errorf("Title and creator queries not properly matched: expected 2 matches got %v", matches)
+	}
+
+	matches = index.Search("funny clip", "someone")
+	if len(matches) != 0 {
+		t.Errorf("Every query token should match: expected no matches got %v", matches)
+	}
+
+	matches = index.Search("", "")
+	if len(matches) != 3 {
+		t.Errorf("Empty queries should match every clip: expected 3 matches got %v", matches)
+	}
+}
+
+func TestTextIndexRanking(t *testing.T) {
+	index := NewTextIndex()
+	index.Add(Clip{ID: "long", Title: "That time the streamer did a clutch play in ranked"})
+	index.Add(Clip{ID: "short", Title: "Clutch play"})
+	index.Add(Clip{ID: "prefix", Title: "Clutching"})
+
+	matches := index.Search("clutch", "")
+	if len(matches) != 3 || matches[0].ClipID != "short" || matches[1].ClipID != "long" || matches[2].ClipID != "prefix" {
+		t.Errorf("Matches not properly ranked: expected [short long prefix] got %v", matches)
+	}
+}
+
+func TestTextIndexReplace(t *testing.T) {
+	index := NewTextIndex()
+	index.Add(Clip{ID: "clip", Title: "Old title"})
+	index.Add(Clip{ID: "clip", Title: "New title"})
+
+	if matches := index.Search("old", ""); len(matches) != 0 {
+		t.Errorf("Replaced clip should not match its old title, got %v", matches)
+	}
+	if matches := index.Search("new", ""); len(matches) != 1 {
+		t.Errorf("Replaced clip should match its new title, got %v", matches)
+	}
+	if index.Len() != 1 {
+		t.Errorf("Replaced clip should only be indexed once, got %d clips", index.Len())
+	}
+}
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"net/url"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Errors returned for Twitch responses callers may handle differently
+var (
+	// ErrUnauthorized means Twitch rejected the client credentials or access token
+	ErrUnauthorized = errors.New("twitch: credentials rejected")
+	// ErrNoBroadcasters means none of the broadcasters looked up exist
+	ErrNoBroadcasters = errors.New("twitch: no broadcasters found")
+	// ErrNoClips means none of the clips looked up exist
+	ErrNoClips = errors.New("twitch: no clips found")
+	// ErrNoVideos means none of the videos looked up exist
+	ErrNoVideos = errors.New("twitch: no videos found")
+)
+
 // ClipsResponse represents a response from a request to Twitch's Get Clips
 type ClipsResponse struct {
 	Data       []Clip `json:"data"`
@@ -29,12 +42,14 @@ type Clip struct {
 	CreatorID       string    `json:"creator_id"`
 	CreatorName     string    `json:"creator_name"`
 	VideoID         string    `json:"video_id"`
+	VODOffset       *int      `json:"vod_offset"`
 	GameID          string    `json:"game_id"`
 	Language        string    `json:"language"`
 	Title           string    `json:"title"`
 	ViewCount       int       `json:"view_count"`
 	CreatedAt       string    `json:"created_at"`
 	ThumbnailURL    string    `json:"thumbnail_url"`
+	Duration        float64   `json:"duration"`
 	StartedAt       time.Time `json:",omitempty"`
 	EndedAt         time.Time `json:",omitempty"`
 }
@@ -58,7 +73,8 @@ type Broadcaster struct {
 	Email           string `json:"email"`
 }
 
-// TwitchAPI holds all configuration needed for a Twitch API connection
+// TwitchAPI holds all configuration needed for a Twitch API connection. Once its access token is set it is safe
+// for concurrent use, as its cache and index guard themselves.
 type TwitchAPI struct {
 	ClientID     string
 	ClientSecret string
@@ -66,23 +82,45 @@ type TwitchAPI struct {
 	BaseURL      url.URL
 	AuthURL      url.URL
 	Client       *http.Client
+	Cache        *TTLCache
+	Index        *ClipIndex
+	// ctx cancels the requests made through this TwitchAPI, if set
+	ctx context.Context
 }
 
-// NewTwitchAPI returns a new TwitchAPI after setting the access token
-func NewTwitchAPI(clientID string, clientSecret string, setAuth bool) TwitchAPI {
+// Cached responses live longer the less likely they are to change
+const (
+	usersTTL           = time.Hour
+	recentClipsTTL     = time.Minute
+	historicalClipsTTL = 6 * time.Hour
+	maxCacheEntries    = 10000
+)
+
+// requestTimeout bounds how long a single request to Twitch can hold up a command
+const requestTimeout = 30 * time.Second
+
+// clipsSettleTime is how long after a window ends before its clip pages are considered historical
+const clipsSettleTime = 48 * time.Hour
+
+// NewTwitchAPI returns a new TwitchAPI, setting the access token if setAuth is true. It returns an error if
+// Twitch rejects the client credentials.
+func NewTwitchAPI(clientID string, clientSecret string, setAuth bool) (TwitchAPI, error) {
 	t := TwitchAPI{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		BaseURL:      url.URL{Scheme: "https", Host: "api.twitch.tv"},
 		AuthURL:      url.URL{Scheme: "https", Host: "id.twitch.tv", Path: "/oauth2/token"},
-		Client:       &http.Client{},
+		Client:       &http.Client{Timeout: requestTimeout, Transport: instrumentedTransport{observe: observeTwitchRequest}},
+		Cache:        NewTTLCache(recentClipsTTL, maxCacheEntries),
 	}
 
 	if setAuth == true {
-		t.SetAuthToken()
+		if err := t.SetAuthToken(); err != nil {
+			return t, err
+		}
 	}
 
-	return t
+	return t, nil
 }
 
 // GetBroadcastersByName finds a Broadcaster with given names
@@ -96,37 +134,69 @@ func (t TwitchAPI) GetBroadcastersByName(broadcasterNames []string) ([]Broadcast
 	}
 	endpoint.RawQuery = q.Encode()
 
+	cacheKey := "users:" + endpoint.RawQuery
+	if cached, ok := t.Cache.Get(cacheKey); ok {
+		return cached.([]Broadcaster), nil
+	}
+
 	req := t.prepareRequest("GET", endpoint.String())
 
-	log.Printf("Request: %v", req)
+	Log.Debug("Twitch request", "method", req.Method, "url", req.URL)
 	jsonResponse, err := t.Client.Do(req)
 	if err != nil {
-		log.Fatal("request failed: ", err)
+		return nil, err
+	}
+	defer jsonResponse.Body.Close()
+	if err := checkResponse(jsonResponse); err != nil {
+		return nil, err
 	}
 
 	resp := BroadcasterResponse{}
 	json.NewDecoder(jsonResponse.Body).Decode(&resp)
 
+	if len(resp.Data) > 0 {
+		t.Cache.SetWithTTL(cacheKey, resp.Data, usersTTL)
+	}
 	if len(resp.Data) == 0 {
-		return nil, errors.New("twitch: no broadcasters found")
+		return nil, ErrNoBroadcasters
 	}
 	return resp.Data, nil
 }
 
+// checkResponse returns an error for responses Twitch didn't answer successfully
+func checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case resp.StatusCode >= 300:
+		return errors.New("twitch: request failed with " + resp.Status)
+	}
+	return nil
+}
+
 func (t TwitchAPI) prepareRequest(method string, endpoint string) *http.Request {
 	req, err := http.NewRequest(method, endpoint, nil)
 	if err != nil {
-		log.Fatal("failed to create request: ", err)
+		Log.Fatal("Couldn't create Twitch request", "err", err)
 	}
 
 	req.Header.Add("Client-ID", t.ClientID)
 	req.Header.Add("Authorization", "Bearer "+t.AccessToken)
 
+	if t.ctx != nil {
+		req = req.WithContext(t.ctx)
+	}
 	return req
 }
 
-// GetClipsByBroadcasterID finds clips from a given broadcaster
-func (t TwitchAPI) GetClipsByBroadcasterID(broadcasterID string, after string, before string, endedAt time.Time, startedAt time.Time, first int) ([]Clip, string) {
+// WithContext returns a copy of t whose requests are cancelled once ctx is
+func (t TwitchAPI) WithContext(ctx context.Context) TwitchAPI {
+	t.ctx = ctx
+	return t
+}
+
+// GetClipsByBroadcasterID finds clips from a given broadcaster, returning the cursor of the next page
+func (t TwitchAPI) GetClipsByBroadcasterID(broadcasterID string, after string, before string, endedAt time.Time, startedAt time.Time, first int) ([]Clip, string, error) {
 	endpoint := t.BaseURL
 	endpoint.Path = "/helix/clips"
 
@@ -148,79 +218,190 @@ func (t TwitchAPI) GetClipsByBroadcasterID(broadcasterID string, after string, b
 	q := endpoint.Query()
 	endpoint.RawQuery = prepareQuery(q, m)
 
+	cacheKey := "clips:" + endpoint.RawQuery
+	if cached, ok := t.Cache.Get(cacheKey); ok {
+		resp := cached.(ClipsResponse)
+		return resp.Data, resp.Pagination.Cursor, nil
+	}
+
+	req := t.prepareRequest("GET", endpoint.String())
+	Log.Debug("Twitch request", "method", req.Method, "url", req.URL)
+
+	jsonResponse, err := t.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer jsonResponse.Body.Close()
+	if err := checkResponse(jsonResponse); err != nil {
+		return nil, "", err
+	}
+
+	resp := ClipsResponse{}
+	json.NewDecoder(jsonResponse.Body).Decode(&resp)
+
+	ttl := recentClipsTTL
+	if !endedAt.IsZero() && time.Since(endedAt) > clipsSettleTime {
+		ttl = historicalClipsTTL
+	}
+	t.Cache.SetWithTTL(cacheKey, resp, ttl)
+
+	return resp.Data, resp.Pagination.Cursor, nil
+}
+
+// GetClipsByID finds clips with the given IDs
+func (t TwitchAPI) GetClipsByID(clipIDs []string) ([]Clip, error) {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/clips"
+
+	q := endpoint.Query()
+	for _, clipID := range clipIDs {
+		q.Add("id", clipID)
+	}
+	endpoint.RawQuery = q.Encode()
+
 	req := t.prepareRequest("GET", endpoint.String())
-	log.Printf("Request: %v", req)
+	Log.Debug("Twitch request", "method", req.Method, "url", req.URL)
 
 	jsonResponse, err := t.Client.Do(req)
 	if err != nil {
-		log.Fatal("request failed: ", err)
+		return nil, err
+	}
+	defer jsonResponse.Body.Close()
+	if err := checkResponse(jsonResponse); err != nil {
+		return nil, err
 	}
 
 	resp := ClipsResponse{}
 	json.NewDecoder(jsonResponse.Body).Decode(&resp)
 
-	return resp.Data, resp.Pagination.Cursor
+	if len(resp.Data) == 0 {
+		return nil, ErrNoClips
+	}
+	return resp.Data, nil
 }
 
-// FindClip compares Twitch clips to targetClip using matchFunc
-func (t TwitchAPI) FindClip(targetClip Clip, matchFunc func(Clip, Clip) bool) Clip {
-	clips, cursor := t.GetClipsByBroadcasterID(targetClip.BroadcasterID, "", "", targetClip.EndedAt, targetClip.StartedAt, 100)
+// WalkClips pages through clips from a given broadcaster, calling walkFunc with each page until it returns false.
+// It stops at the first page Twitch fails to return, returning the error.
+func (t TwitchAPI) WalkClips(broadcasterID string, startedAt time.Time, endedAt time.Time, walkFunc func([]Clip) bool) error {
+	clips, cursor, err := t.GetClipsByBroadcasterID(broadcasterID, "", "", endedAt, startedAt, 100)
 
-	for {
-		if len(clips) == 0 || cursor == "" {
-			// return the same clip passed if nothing is found?
-			return targetClip
+	for err == nil && len(clips) > 0 {
+		if !walkFunc(clips) || cursor == "" {
+			return nil
 		}
 
+		clips, cursor, err = t.GetClipsByBroadcasterID(broadcasterID, cursor, "", endedAt, startedAt, 100)
+	}
+	return err
+}
+
+// FindClip compares Twitch clips to targetClip using matchFunc, returning targetClip if none match
+func (t TwitchAPI) FindClip(targetClip Clip, matchFunc func(Clip, Clip) bool) (Clip, error) {
+	// return the same clip passed if nothing is found
+	found := targetClip
+
+	err := t.walkTargetClips(targetClip, func(clips []Clip) bool {
 		for _, clip := range clips {
 			if matchFunc(clip, targetClip) {
-				return clip
+				found = clip
+				return false
 			}
 		}
+		return true
+	})
 
-		clips, cursor = t.GetClipsByBroadcasterID(targetClip.BroadcasterID, cursor, "", targetClip.EndedAt, targetClip.StartedAt, 100)
-	}
+	return found, err
 }
 
-// FindMostPopularClip compares Twitch clips to targetClip using matchFunc and returns only the most popular
-func (t TwitchAPI) FindMostPopularClip(targetClip Clip, matchFunc func(Clip, Clip) bool) Clip {
-	clips, cursor := t.GetClipsByBroadcasterID(targetClip.BroadcasterID, "", "", targetClip.EndedAt, targetClip.StartedAt, 100)
+// FindMostPopularClip compares Twitch clips to targetClip using matchFunc and returns only the most popular,
+// or targetClip if none match
+func (t TwitchAPI) FindMostPopularClip(targetClip Clip, matchFunc func(Clip, Clip) bool) (Clip, error) {
 	mostPopular := targetClip
 
-	for {
-		if len(clips) == 0 || cursor == "" {
-			return mostPopular
-		}
-
+	err := t.walkTargetClips(targetClip, func(clips []Clip) bool {
 		for _, clip := range clips {
 			if clip.ViewCount > mostPopular.ViewCount && matchFunc(clip, targetClip) {
 				mostPopular = clip
 			}
 		}
+		return true
+	})
 
-		clips, cursor = t.GetClipsByBroadcasterID(targetClip.BroadcasterID, cursor, "", targetClip.EndedAt, targetClip.StartedAt, 100)
-	}
+	return mostPopular, err
 }
 
-// FindMostPopularClips compares Twitch clips to targetClip using matchFunc and returns the top most popular clips
-func (t TwitchAPI) FindMostPopularClips(targetClip Clip, matchFunc func(Clip, Clip) bool, top int) []Clip {
-	clips, cursor := t.GetClipsByBroadcasterID(targetClip.BroadcasterID, "", "", targetClip.EndedAt, targetClip.StartedAt, 100)
+// FindMostPopularClips compares Twitch clips to targetClip using matchFunc and returns the top most popular clips.
+// If Twitch fails partway, the top of the clips walked so far is returned with the error.
+func (t TwitchAPI) FindMostPopularClips(targetClip Clip, matchFunc func(Clip, Clip) bool, top int) ([]Clip, error) {
+	if top <= 0 {
+		return nil, nil
+	}
 	var clipsSorted []Clip
 
-	for {
-		if len(clips) == 0 || cursor == "" {
-			sort.Slice(clipsSorted, func(i, j int) bool { return clipsSorted[i].ViewCount > clipsSorted[j].ViewCount })
-			return clipsSorted[:top]
-		}
-
+	err := t.walkTargetClips(targetClip, func(clips []Clip) bool {
 		for _, clip := range clips {
 			if matchFunc(clip, targetClip) {
 				clipsSorted = append(clipsSorted, clip)
 			}
 		}
+		return true
+	})
 
-		clips, cursor = t.GetClipsByBroadcasterID(targetClip.BroadcasterID, cursor, "", targetClip.EndedAt, targetClip.StartedAt, 100)
+	sort.Slice(clipsSorted, func(i, j int) bool { return clipsSorted[i].ViewCount > clipsSorted[j].ViewCount })
+	if len(clipsSorted) > top {
+		return clipsSorted[:top], err
 	}
+	return clipsSorted, err
+}
+
+// SearchClips ranks the clips in targetClip's window by how well their title and creator match targetClip's.
+// Only the index is searched, so it reports false when the index doesn't cover the window.
+func (t TwitchAPI) SearchClips(targetClip Clip) ([]Clip, bool) {
+	if t.Index == nil || !t.Index.Covers(targetClip.BroadcasterID, targetClip.StartedAt, targetClip.EndedAt) {
+		return nil, false
+	}
+	return t.Index.Search(targetClip.BroadcasterID, targetClip.StartedAt, targetClip.EndedAt, targetClip.Title, targetClip.CreatorName), true
+}
+
+// FindBestClip finds the clip that best matches targetClip's title and creator, returning targetClip if none
+// match. The index ranks title matches when it covers the window, otherwise the most popular match wins unless
+// both a title and a creator point to a specific clip.
+func (t TwitchAPI) FindBestClip(targetClip Clip) (Clip, error) {
+	if results, ok := t.SearchClips(targetClip); ok && targetClip.Title != "" {
+		if len(results) > 0 {
+			return results[0], nil
+		}
+		return targetClip, nil
+	}
+
+	matchFunc := matchMany(matchTitle, matchCreator)
+	if targetClip.Title == "" || targetClip.CreatorName == "" {
+		// There may be many clips with the same creator or title, so we look for the most popular one
+		return t.FindMostPopularClip(targetClip, matchFunc)
+	}
+	// Otherwise, we're looking for a specific clip
+	return t.FindClip(targetClip, matchFunc)
+}
+
+// walkTargetClips walks the clips in targetClip's window, answering from the index when it covers the window
+func (t TwitchAPI) walkTargetClips(targetClip Clip, walkFunc func([]Clip) bool) error {
+	if t.Index != nil {
+		if t.Index.Covers(targetClip.BroadcasterID, targetClip.StartedAt, targetClip.EndedAt) {
+			indexSearchesTotal.Inc("hit")
+			searchPages.Observe(0)
+			walkFunc(t.Index.Clips(targetClip.BroadcasterID, targetClip.StartedAt, targetClip.EndedAt))
+			return nil
+		}
+		indexSearchesTotal.Inc("miss")
+	}
+
+	pages := 0
+	err := t.WalkClips(targetClip.BroadcasterID, targetClip.StartedAt, targetClip.EndedAt, func(clips []Clip) bool {
+		pages++
+		return walkFunc(clips)
+	})
+	searchPages.Observe(float64(pages))
+	return err
 }
 
 // TokenResponse represents a response from the auth endpoint containing an access token
@@ -232,8 +413,8 @@ type TokenResponse struct {
 	TokenType    string   `json:"token_type"`
 }
 
-// SetAuthToken sets the AccessToken in TwitchAPI
-func (t *TwitchAPI) SetAuthToken() {
+// SetAuthToken sets the AccessToken in TwitchAPI, returning an error if Twitch rejects the client credentials
+func (t *TwitchAPI) SetAuthToken() error {
 	endpoint := t.AuthURL
 
 	q := endpoint.Query()
@@ -242,18 +423,38 @@ func (t *TwitchAPI) SetAuthToken() {
 	m["client_secret"] = t.ClientSecret
 	m["grant_type"] = "client_credentials"
 
-	endpoint.RawQuery = prepareQuery(q, m)
-	req := t.prepareRequest("POST", endpoint.String())
+	// The credentials go in the body, as query strings end up in access logs
+	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(prepareQuery(q, m)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	jsonResponse, err := t.Client.Do(req)
 	if err != nil {
-		log.Fatal("request failed: ", err)
+		return err
+	}
+	defer jsonResponse.Body.Close()
+	switch jsonResponse.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	default:
+		return errors.New("twitch: couldn't get an access token, got status " + strconv.Itoa(jsonResponse.StatusCode))
 	}
 
 	resp := TokenResponse{}
-	json.NewDecoder(jsonResponse.Body).Decode(&resp)
+	if err := json.NewDecoder(jsonResponse.Body).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.AccessToken == "" {
+		return errors.New("twitch: no access token returned")
+	}
 
 	t.AccessToken = resp.AccessToken
+	// Every token is kept out of the logs, not just the one fetched at startup
+	Log.Redact(t.AccessToken)
+	return nil
 }
 
 func prepareQuery(query url.Values, m map[string]string) string {
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestNewTwitchAPI(t *testing.T) {
-	twitch := NewTwitchAPI("client-id", "client-secret", false)
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
 
 	if twitch.ClientID != "client-id" {
 		t.Errorf("ClientID not properly set, expected \"client-id\" got %s", twitch.ClientID)
@@ -47,7 +52,7 @@ func TestPrepareQuery(t *testing.T) {
 }
 
 func TestPrepareRequest(t *testing.T) {
-	twitch := NewTwitchAPI("client-id", "client-secret", false)
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
 	twitch.AccessToken = "some-token"
 	req := twitch.prepareRequest("GET", "https://some.fancy/url")
 
@@ -68,14 +73,24 @@ func TestPrepareRequest(t *testing.T) {
 func TestSetAuthToken(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(authHandler))
 
-	twitch := NewTwitchAPI("client-id", "client-secret", false)
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
 	mockURL, _ := url.Parse(ts.URL)
 	twitch.AuthURL = *mockURL
 
-	twitch.SetAuthToken()
+	var logs bytes.Buffer
+	Log.Configure(&logs, LevelInfo, "logfmt")
+	defer Log.Configure(os.Stderr, LevelInfo, "logfmt")
+
+	if err := twitch.SetAuthToken(); err != nil {
+		t.Errorf("Got an error from SetAuthToken: %s", err)
+	}
 	if twitch.AccessToken != "my-test-token" {
 		t.Errorf("AccessToken not properly set by SetAuthToken, expected \"my-test-token\" got %s", twitch.AccessToken)
 	}
+	Log.Info("Got a token: " + twitch.AccessToken)
+	if strings.Contains(logs.String(), "my-test-token") {
+		t.Errorf("Access tokens should be redacted from the logs, got %s", logs.String())
+	}
 	ts.Close()
 }
 
@@ -87,7 +102,7 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 func TestGetBroadcastersByName(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(broadcastersHandler))
 
-	twitch := NewTwitchAPI("client-id", "client-secret", false)
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
 	mockURL, _ := url.Parse(ts.URL)
 	twitch.BaseURL = *mockURL
 
@@ -98,6 +113,32 @@ func TestGetBroadcastersByName(t *testing.T) {
 	ts.Close()
 }
 
+func TestTwitchAPIWithContext(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		broadcastersHandler(w, r)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := twitch.WithContext(ctx).GetBroadcastersByName([]string{"test-login"}); err == nil {
+		t.Errorf("Expected an error from a TwitchAPI with a cancelled context")
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests to reach Twitch once the context is cancelled, got %d", requests)
+	}
+
+	if _, err := twitch.GetBroadcastersByName([]string{"test-login"}); err != nil {
+		t.Errorf("Expected the original TwitchAPI to keep working, got %v", err)
+	}
+}
+
 func broadcastersHandler(w http.ResponseWriter, r *http.Request) {
 	b := Broadcaster{ID: "test-id"}
 	res := BroadcasterResponse{Data: []Broadcaster{b}}
@@ -107,11 +148,11 @@ func broadcastersHandler(w http.ResponseWriter, r *http.Request) {
 func TestGetClipsByBroadcasterId(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(clipsHandler))
 
-	twitch := NewTwitchAPI("client-id", "client-secret", false)
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
 	mockURL, _ := url.Parse(ts.URL)
 	twitch.BaseURL = *mockURL
 
-	clips, _ := twitch.GetClipsByBroadcasterID("broadcaster", "", "", time.Time{}, time.Time{}, 100)
+	clips, _, _ := twitch.GetClipsByBroadcasterID("broadcaster", "", "", time.Time{}, time.Time{}, 100)
 	if clips[0].ID != "test-id" {
 		t.Errorf("Clip.Id not correctly returned by GetBroadcastersByName, expected \"test-id\" got %s", clips[0].ID)
 	}
@@ -126,3 +167,248 @@ func clipsHandler(w http.ResponseWriter, r *http.Request) {
 	res := ClipsResponse{Data: []Clip{b}}
 	json.NewEncoder(w).Encode(res)
 }
+
+func TestWalkClips(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(pagedClipsHandler))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	var ids []string
+	twitch.WalkClips("broadcaster", time.Time{}, time.Time{}, func(clips []Clip) bool {
+		for _, clip := range clips {
+			ids = append(ids, clip.ID)
+		}
+		return true
+	})
+	if len(ids) != 2 || ids[0] != "page-1" || ids[1] != "page-2" {
+		t.Errorf("Clips not correctly walked by WalkClips, expected [page-1 page-2] got %v", ids)
+	}
+
+	pages := 0
+	twitch.WalkClips("broadcaster", time.Time{}, time.Time{}, func(clips []Clip) bool {
+		pages++
+		return false
+	})
+	if pages != 1 {
+		t.Errorf("WalkClips did not stop when walkFunc returned false, expected 1 page got %d", pages)
+	}
+	ts.Close()
+}
+
+func pagedClipsHandler(w http.ResponseWriter, r *http.Request) {
+	res := ClipsResponse{}
+	if r.URL.Query().Get("after") == "" {
+		res.Data = []Clip{{ID: "page-1"}}
+		res.Pagination.Cursor = "next"
+	} else {
+		res.Data = []Clip{{ID: "page-2"}}
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestGetClipsByID(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(clipsByIDHandler))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	clips, err := twitch.GetClipsByID([]string{"clip-1", "clip-2"})
+	if err != nil {
+		t.Errorf("Got an error from GetClipsByID: %s", err)
+	}
+	if len(clips) != 2 || clips[0].ID != "clip-1" || clips[1].ID != "clip-2" {
+		t.Errorf("Clips not correctly returned by GetClipsByID, expected [clip-1 clip-2] got %v", clips)
+	}
+
+	_, err = twitch.GetClipsByID([]string{})
+	if err != ErrNoClips {
+		t.Errorf("GetClipsByID should have returned ErrNoClips when no clips are found, got %v", err)
+	}
+	ts.Close()
+}
+
+func TestGetClipsByIDUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	if _, err := twitch.GetClipsByID([]string{"clip-1"}); err != ErrUnauthorized {
+		t.Errorf("Expected ErrUnauthorized from GetClipsByID, got %v", err)
+	}
+	ts.Close()
+}
+
+func clipsByIDHandler(w http.ResponseWriter, r *http.Request) {
+	res := ClipsResponse{}
+	for _, id := range r.URL.Query()["id"] {
+		res.Data = append(res.Data, Clip{ID: id})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestGetClipsByBroadcasterIDCache(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		clipsHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+	now := time.Now()
+	twitch.Cache.now = func() time.Time { return now }
+
+	recent := time.Now().AddDate(0, 0, -1)
+	historical := time.Now().AddDate(-1, 0, 0)
+	twitch.GetClipsByBroadcasterID("broadcaster", "", "", time.Time{}, recent, 100)
+	twitch.GetClipsByBroadcasterID("broadcaster", "", "", historical.AddDate(0, 1, 0), historical, 100)
+	twitch.GetClipsByBroadcasterID("broadcaster", "", "", time.Time{}, recent, 100)
+	twitch.GetClipsByBroadcasterID("broadcaster", "", "", historical.AddDate(0, 1, 0), historical, 100)
+	if requests != 2 {
+		t.Errorf("Cached clip pages should not be requested again, expected 2 requests got %d", requests)
+	}
+
+	now = now.Add(time.Hour)
+	twitch.GetClipsByBroadcasterID("broadcaster", "", "", time.Time{}, recent, 100)
+	twitch.GetClipsByBroadcasterID("broadcaster", "", "", historical.AddDate(0, 1, 0), historical, 100)
+	if requests != 3 {
+		t.Errorf("Only recent clip pages should have expired, expected 3 requests got %d", requests)
+	}
+	ts.Close()
+}
+
+func TestGetBroadcastersByNameCache(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		broadcastersHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	twitch.GetBroadcastersByName([]string{"test-login"})
+	broadcasters, _ := twitch.GetBroadcastersByName([]string{"test-login"})
+	if requests != 1 {
+		t.Errorf("Cached broadcasters should not be requested again, expected 1 request got %d", requests)
+	}
+	if broadcasters[0].ID != "test-id" {
+		t.Errorf("Broadcaster.Id not correctly returned from cache, expected \"test-id\" got %s", broadcasters[0].ID)
+	}
+	ts.Close()
+}
+
+func TestTwitchAPIConcurrentUse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/helix/users" {
+			broadcastersHandler(w, r)
+		} else {
+			pagedClipsHandler(w, r)
+		}
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			twitch.GetBroadcastersByName([]string{"test-login"})
+			clips := 0
+			twitch.WalkClips("broadcaster", time.Time{}, time.Time{}, func(page []Clip) bool {
+				clips += len(page)
+				return true
+			})
+			if clips != 2 {
+				t.Errorf("Clips not correctly walked concurrently, expected 2 clips got %d", clips)
+			}
+		}()
+	}
+	wg.Wait()
+	ts.Close()
+}
+
+func TestFindMostPopularClipsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/helix/users" || r.URL.Query().Get("after") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		pagedClipsHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	clips, err := twitch.FindMostPopularClips(Clip{BroadcasterID: "broadcaster"}, matchMany(), 5)
+	if err == nil {
+		t.Errorf("Expected an error when Twitch fails partway")
+	}
+	if len(clips) != 1 {
+		t.Errorf("Expected the clips walked before the error, got %d", len(clips))
+	}
+	if _, err := twitch.GetBroadcastersByName([]string{"test-login"}); err == nil {
+		t.Errorf("Expected an error from GetBroadcastersByName when Twitch fails")
+	}
+	ts.Close()
+}
+
+func TestFindMostPopularClipsNoTop(t *testing.T) {
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	clips, err := twitch.FindMostPopularClips(Clip{BroadcasterID: "broadcaster"}, matchMany(), -5)
+	if err != nil || len(clips) != 0 {
+		t.Errorf("Expected no clips and no error for a negative top, got %v, %v", clips, err)
+	}
+}
+
+func TestSetAuthTokenInvalidCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"status":400,"message":"invalid client secret"}`, http.StatusBadRequest)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "wrong-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.AuthURL = *mockURL
+
+	if err := twitch.SetAuthToken(); err == nil {
+		t.Errorf("SetAuthToken should fail when Twitch rejects the credentials")
+	}
+	if twitch.AccessToken != "" {
+		t.Errorf("AccessToken should not be set when Twitch rejects the credentials, got %s", twitch.AccessToken)
+	}
+	ts.Close()
+}
+
+func TestSetAuthTokenSendsCredentialsInBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("client_secret") != "" {
+			t.Errorf("client_secret should not be sent in the query string, got %s", r.URL.RawQuery)
+		}
+		if r.PostFormValue("client_secret") != "client-secret" {
+			t.Errorf("client_secret not sent in the form body, expected \"client-secret\" got %s", r.PostFormValue("client_secret"))
+		}
+		authHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.AuthURL = *mockURL
+
+	if err := twitch.SetAuthToken(); err != nil {
+		t.Errorf("Got an error from SetAuthToken: %s", err)
+	}
+	ts.Close()
+}
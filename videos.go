@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultClipDuration is used when Twitch doesn't report how long a clip is
+const defaultClipDuration = 30 * time.Second
+
+// vodRetention is how long Twitch keeps past broadcasts at most, so clips can be taken from a VOD until then
+const vodRetention = 60 * 24 * time.Hour
+
+// VideosResponse represents a response from a request to Twitch's Get Videos
+type VideosResponse struct {
+	Data []Video `json:"data"`
+}
+
+// Video represents a Twitch video, used for past broadcasts (VODs)
+type Video struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	UserLogin   string `json:"user_login"`
+	UserName    string `json:"user_name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+	PublishedAt string `json:"published_at"`
+	URL         string `json:"url"`
+	Viewable    string `json:"viewable"`
+	ViewCount   int    `json:"view_count"`
+	Language    string `json:"language"`
+	Type        string `json:"type"`
+	Duration    string `json:"duration"`
+}
+
+// GetVideosByID finds videos with the given IDs
+func (t TwitchAPI) GetVideosByID(videoIDs []string) ([]Video, error) {
+	endpoint := t.BaseURL
+	endpoint.Path = "/helix/videos"
+
+	q := endpoint.Query()
+	for _, videoID := range videoIDs {
+		q.Add("id", videoID)
+	}
+	endpoint.RawQuery = q.Encode()
+
+	req := t.prepareRequest("GET", endpoint.String())
+	Log.Debug("Twitch request", "method", req.Method, "url", req.URL)
+
+	jsonResponse, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer jsonResponse.Body.Close()
+	if err := checkResponse(jsonResponse); err != nil {
+		return nil, err
+	}
+
+	resp := VideosResponse{}
+	json.NewDecoder(jsonResponse.Body).Decode(&resp)
+
+	if len(resp.Data) == 0 {
+		return nil, ErrNoVideos
+	}
+	return resp.Data, nil
+}
+
+// FindVODClips returns the clips taken from video sorted by their position in it
+func (t TwitchAPI) FindVODClips(video Video) ([]Clip, error) {
+	startedAt, err := time.Parse(time.RFC3339, video.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// Clips can be created from a VOD for as long as it's available, not just while it airs
+	endedAt := startedAt.Add(vodRetention)
+	if endedAt.After(time.Now()) {
+		endedAt = time.Time{}
+	}
+
+	var vodClips []Clip
+	err = t.WalkClips(video.UserID, startedAt, endedAt, func(clips []Clip) bool {
+		for _, clip := range clips {
+			if clip.VideoID == video.ID {
+				vodClips = append(vodClips, clip)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(vodClips, func(i, j int) bool { return VODOffset(vodClips[i], video) < VODOffset(vodClips[j], video) })
+	return vodClips, nil
+}
+
+// Length returns how long the video is
+func (v Video) Length() time.Duration {
+	length, err := time.ParseDuration(v.Duration)
+	if err != nil {
+		return 0
+	}
+	return length
+}
+
+// VODOffset returns the position in video where clip starts. Twitch reports it once the VOD is available,
+// otherwise it's estimated from when the clip was created.
+func VODOffset(clip Clip, video Video) time.Duration {
+	if clip.VODOffset != nil {
+		return time.Duration(*clip.VODOffset) * time.Second
+	}
+
+	clipCreatedAt, err := time.Parse(time.RFC3339, clip.CreatedAt)
+	if err != nil {
+		return 0
+	}
+	videoCreatedAt, err := time.Parse(time.RFC3339, video.CreatedAt)
+	if err != nil {
+		return 0
+	}
+
+	clipDuration := time.Duration(clip.Duration * float64(time.Second))
+	if clipDuration == 0 {
+		clipDuration = defaultClipDuration
+	}
+
+	// A clip is created once its last frame has aired, so its start is one clip duration earlier
+	offset := clipCreatedAt.Sub(videoCreatedAt) - clipDuration
+	if offset < 0 {
+		return 0
+	}
+	if length := video.Length(); length > 0 && offset > length {
+		return length
+	}
+	return offset.Truncate(time.Second)
+}
+
+// VODURL returns a link to video that starts playing at offset
+func VODURL(video Video, offset time.Duration) string {
+	return "https://www.twitch.tv/videos/" + video.ID + "?t=" + formatOffset(offset)
+}
+
+func formatOffset(offset time.Duration) string {
+	offset = offset.Truncate(time.Second)
+	hours := int(offset.Hours())
+	minutes := int(offset.Minutes()) % 60
+	seconds := int(offset.Seconds()) % 60
+	return strconv.Itoa(hours) + "h" + strconv.Itoa(minutes) + "m" + strconv.Itoa(seconds) + "s"
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetVideosByID(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(videosHandler))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	videos, err := twitch.GetVideosByID([]string{"1234"})
+	if err != nil {
+		t.Errorf("Got an error from GetVideosByID: %s", err)
+	}
+	if videos[0].ID != "1234" {
+		t.Errorf("Video.ID not correctly returned by GetVideosByID, expected \"1234\" got %s", videos[0].ID)
+	}
+
+	if _, err := twitch.GetVideosByID([]string{}); err != ErrNoVideos {
+		t.Errorf("GetVideosByID should have returned ErrNoVideos when no videos are found, got %v", err)
+	}
+	ts.Close()
+}
+
+func videosHandler(w http.ResponseWriter, r *http.Request) {
+	res := VideosResponse{}
+	for _, id := range r.URL.Query()["id"] {
+		res.Data = append(res.Data, Video{ID: id, UserID: "broadcaster", CreatedAt: "2020-06-01T10:00:00Z", Duration: "2h0m0s"})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestFindVODClips(t *testing.T) {
+	var endedAt string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endedAt = r.URL.Query().Get("ended_at")
+		vodClipsHandler(w, r)
+	}))
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	video := Video{ID: "1234", UserID: "broadcaster", CreatedAt: "2020-06-01T10:00:00Z", Duration: "2h0m0s"}
+	clips, err := twitch.FindVODClips(video)
+	if err != nil {
+		t.Errorf("Got an error from FindVODClips: %s", err)
+	}
+	if len(clips) != 2 || clips[0].ID != "early" || clips[1].ID != "late" {
+		t.Errorf("Clips not correctly returned by FindVODClips, expected [early late] got %v", clips)
+	}
+	if endedAt != "2020-07-31T10:00:00Z" {
+		t.Errorf("Expected clips to be searched while the VOD is available, got ended_at %s", endedAt)
+	}
+	ts.Close()
+}
+
+func vodClipsHandler(w http.ResponseWriter, r *http.Request) {
+	res := ClipsResponse{Data: []Clip{
+		{ID: "late", VideoID: "1234", CreatedAt: "2020-06-01T11:30:00Z"},
+		{ID: "other-vod", VideoID: "5678", CreatedAt: "2020-06-01T10:30:00Z"},
+		{ID: "early", VideoID: "1234", CreatedAt: "2020-06-01T10:30:00Z"},
+	}}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestVODOffset(t *testing.T) {
+	video := Video{ID: "1234", CreatedAt: "2020-06-01T10:00:00Z", Duration: "1h0m0s"}
+
+	clip := Clip{CreatedAt: "2020-06-01T10:30:30Z", Duration: 30}
+	if offset := VODOffset(clip, video); offset != 30*time.Minute {
+		t.Errorf("VOD offset not properly computed: expected 30m0s got %s", offset)
+	}
+
+	clip = Clip{CreatedAt: "2020-06-01T10:00:10Z"}
+	if offset := VODOffset(clip, video); offset != 0 {
+		t.Errorf("VOD offset not properly computed for a clip at the start: expected 0s got %s", offset)
+	}
+
+	clip = Clip{CreatedAt: "2020-06-02T10:00:00Z", Duration: 30}
+	if offset := VODOffset(clip, video); offset != time.Hour {
+		t.Errorf("VOD offset not properly computed for a clip created after the VOD: expected 1h0m0s got %s", offset)
+	}
+
+	vodOffset := 754
+	clip = Clip{CreatedAt: "2020-06-02T10:00:00Z", Duration: 30, VODOffset: &vodOffset}
+	if offset := VODOffset(clip, video); offset != 12*time.Minute+34*time.Second {
+		t.Errorf("VOD offset reported by Twitch not used: expected 12m34s got %s", offset)
+	}
+}
+
+func TestVODURL(t *testing.T) {
+	video := Video{ID: "1234"}
+	vodURL := VODURL(video, time.Hour+2*time.Minute+3*time.Second)
+
+	expected := "https://www.twitch.tv/videos/1234?t=1h2m3s"
+	if vodURL != expected {
+		t.Errorf("VOD URL not properly built: expected \"%s\" got %s", expected, vodURL)
+	}
+}
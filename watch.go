@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// watchInterval is how often watched broadcasters are polled for new clips
+	watchInterval = 5 * time.Minute
+	// watchLookback is how long after its creation a clip can still cross a watch's view threshold and be posted
+	watchLookback = 48 * time.Hour
+)
+
+// Watch is a request to post the new clips of a broadcaster to a Discord channel
+type Watch struct {
+	GuildID         string    `json:"guild_id"`
+	ChannelID       string    `json:"channel_id"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name"`
+	MinViews        int       `json:"min_views"`
+	Since           time.Time `json:"since"`
+}
+
+// key identifies a watch, as a channel can only watch a broadcaster once
+func (w Watch) key() string {
+	return w.ChannelID + ":" + w.BroadcasterID
+}
+
+// WatchStore persists watches along with the clips already posted for each of them.
+// It is safe for concurrent use.
+type WatchStore struct {
+	mu      sync.Mutex
+	path    string
+	Watches []Watch                         `json:"watches"`
+	Posted  map[string]map[string]time.Time `json:"posted"`
+	owns    guildFilter
+	now     func() time.Time
+}
+
+// OpenWatchStore loads the watches stored in path
+func OpenWatchStore(path string) (*WatchStore, error) {
+	store := &WatchStore{path: path, now: time.Now}
+	if err := loadJSON(path, store); err != nil {
+		return nil, err
+	}
+	if store.Posted == nil {
+		store.Posted = make(map[string]map[string]time.Time)
+	}
+	return store, nil
+}
+
+// Add registers a watch, replacing the channel's existing watch of the same broadcaster
+func (ws *WatchStore) Add(watch Watch) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if watch.Since.IsZero() {
+		watch.Since = ws.now()
+	}
+	for i, w := range ws.Watches {
+		if w.key() == watch.key() {
+			// Keep the original start so clips posted before aren't posted again
+			watch.Since = w.Since
+			ws.Watches[i] = watch
+			return ws.save()
+		}
+	}
+	ws.Watches = append(ws.Watches, watch)
+	return ws.save()
+}
+
+// Remove unregisters the watch of a broadcaster in a channel, reporting whether there was one
+func (ws *WatchStore) Remove(channelID string, broadcasterName string) (bool, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for i, w := range ws.Watches {
+		if w.ChannelID == channelID && strings.EqualFold(w.BroadcasterName, broadcasterName) {
+			ws.Watches = append(ws.Watches[:i], ws.Watches[i+1:]...)
+			delete(ws.Posted, w.key())
+			return true, ws.save()
+		}
+	}
+	return false, nil
+}
+
+// List returns the watches registered in a guild
+func (ws *WatchStore) List(guildID string) []Watch {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var watches []Watch
+	for _, w := range ws.Watches {
+		if w.GuildID == guildID {
+			watches = append(watches, w)
+		}
+	}
+	sort.Slice(watches, func(i, j int) bool { return watches[i].BroadcasterName < watches[j].BroadcasterName })
+	return watches
+}
+
+// GuildIDs returns the guilds with watches
+func (ws *WatchStore) GuildIDs() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var guildIDs []string
+	for _, w := range ws.Watches {
+		guildIDs = append(guildIDs, w.GuildID)
+	}
+	return guildIDs
+}
+
+// BroadcasterIDs returns the broadcasters watched in the guilds this process handles
+func (ws *WatchStore) BroadcasterIDs() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var broadcasterIDs []string
+	for _, w := range ws.Watches {
+		if ws.owns.handles(w.GuildID) {
+			broadcasterIDs = append(broadcasterIDs, w.BroadcasterID)
+		}
+	}
+	return broadcasterIDs
+}
+
+// SetOwner limits polling to the watches of the guilds owns reports true for, like the ones whose shard runs in
+// this process
+func (ws *WatchStore) SetOwner(owns func(guildID string) bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.owns = owns
+}
+
+// Poll looks for new clips of every watched broadcaster and calls post once for each clip that crosses a
+// watch's view threshold
+func (ws *WatchStore) Poll(t TwitchAPI, post func(Watch, Clip) error) {
+	ws.mu.Lock()
+	byBroadcaster := make(map[string][]Watch)
+	for _, w := range ws.Watches {
+		if ws.owns.handles(w.GuildID) {
+			byBroadcaster[w.BroadcasterID] = append(byBroadcaster[w.BroadcasterID], w)
+		}
+	}
+	now := ws.now()
+	ws.mu.Unlock()
+
+	for broadcasterID, watches := range byBroadcaster {
+		var clips []Clip
+		err := t.WalkClips(broadcasterID, now.Add(-watchLookback), time.Time{}, func(page []Clip) bool {
+			clips = append(clips, page...)
+			return true
+		})
+		if err != nil {
+			Log.Warn("Couldn't walk watched clips", "broadcaster", broadcasterID, "err", err)
+		}
+
+		for _, w := range watches {
+			for _, clip := range clips {
+				if clip.ViewCount < w.MinViews || !inWindow(clip, w.Since, time.Time{}) || ws.posted(w, clip) {
+					continue
+				}
+				if err := post(w, clip); err != nil {
+					Log.Error("Couldn't post watched clip", "clip", clip.ID, "guild", w.GuildID, "channel", w.ChannelID, "err", err)
+					continue
+				}
+				ws.markPosted(w, clip)
+			}
+		}
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.prune(now)
+	if err := ws.save(); err != nil {
+		Log.Error("Couldn't save watches", "err", err)
+	}
+}
+
+// Run polls for new clips each interval until stop is closed
+func (ws *WatchStore) Run(t TwitchAPI, interval time.Duration, post func(Watch, Clip) error, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ws.Poll(t, post)
+		}
+	}
+}
+
+func (ws *WatchStore) posted(w Watch, clip Clip) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	_, ok := ws.Posted[w.key()][clip.ID]
+	return ok
+}
+
+func (ws *WatchStore) markPosted(w Watch, clip Clip) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.Posted[w.key()] == nil {
+		ws.Posted[w.key()] = make(map[string]time.Time)
+	}
+	ws.Posted[w.key()][clip.ID] = ws.now()
+}
+
+// prune forgets posted clips that are too old to be polled again
+func (ws *WatchStore) prune(now time.Time) {
+	for key, clips := range ws.Posted {
+		for clipID, postedAt := range clips {
+			if now.Sub(postedAt) > 2*watchLookback {
+				delete(clips, clipID)
+			}
+		}
+		if len(clips) == 0 {
+			delete(ws.Posted, key)
+		}
+	}
+}
+
+// Flush saves the clips posted so far, for when the bot stops in the middle of a poll
+func (ws *WatchStore) Flush() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.save()
+}
+
+func (ws *WatchStore) save() error {
+	return saveJSON(ws.path, ws)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-watches")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watches.json")
+
+	store, err := OpenWatchStore(path)
+	if err != nil {
+		t.Fatalf("Got an error opening the watch store: %s", err)
+	}
+	store.Add(Watch{GuildID: "guild", ChannelID: "channel", BroadcasterID: "1", BroadcasterName: "streamer"})
+	store.Add(Watch{GuildID: "guild", ChannelID: "channel", BroadcasterID: "1", BroadcasterName: "streamer", MinViews: 10})
+	store.Add(Watch{GuildID: "guild", ChannelID: "other", BroadcasterID: "2", BroadcasterName: "another"})
+	store.Add(Watch{GuildID: "other-guild", ChannelID: "elsewhere", BroadcasterID: "1", BroadcasterName: "streamer"})
+
+	watches := store.List("guild")
+	if len(watches) != 2 || watches[0].BroadcasterName != "another" || watches[1].MinViews != 10 {
+		t.Errorf("Watches not correctly listed, expected another and streamer with 10 min views got %v", watches)
+	}
+
+	reopened, _ := OpenWatchStore(path)
+	if watches := reopened.List("guild"); len(watches) != 2 {
+		t.Errorf("Watches not persisted, expected 2 watches got %v", watches)
+	}
+
+	removed, _ := reopened.Remove("channel", "Streamer")
+	if !removed {
+		t.Errorf("Watch should have been removed")
+	}
+	removed, _ = reopened.Remove("channel", "streamer")
+	if removed {
+		t.Errorf("Watch should not be removed twice")
+	}
+	if watches := reopened.List("guild"); len(watches) != 1 {
+		t.Errorf("Watch not correctly removed, expected 1 watch got %v", watches)
+	}
+}
+
+func TestWatchStorePoll(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "clips-watches")
+	defer os.RemoveAll(dir)
+
+	views := 5
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := ClipsResponse{Data: []Clip{
+			{ID: "before-watch", CreatedAt: "2020-06-01T09:00:00Z", ViewCount: 100},
+			{ID: "new", CreatedAt: "2020-06-01T11:00:00Z", ViewCount: views},
+		}}
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer ts.Close()
+
+	twitch, _ := NewTwitchAPI("client-id", "client-secret", false)
+	mockURL, _ := url.Parse(ts.URL)
+	twitch.BaseURL = *mockURL
+
+	store, _ := OpenWatchStore(filepath.Join(dir, "watches.json"))
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.Add(Watch{ChannelID: "channel", BroadcasterID: "1", MinViews: 10, Since: time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)})
+
+	var posted []string
+	post := func(w Watch, clip Clip) error {
+		posted = append(posted, clip.ID)
+		return nil
+	}
+
+	store.Poll(twitch, post)
+	if len(posted) != 0 {
+		t.Errorf("Clips under the view threshold or created before the watch should not be posted, got %v", posted)
+	}
+
+	views = 20
+	now = now.Add(watchInterval)
+	store.Poll(twitch, post)
+	now = now.Add(watchInterval)
+	store.Poll(twitch, post)
+	if len(posted) != 1 || posted[0] != "new" {
+		t.Errorf("Clip crossing the view threshold should be posted once, expected [new] got %v", posted)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// WorkQueue runs jobs on a bounded number of workers. Jobs are queued per guild and workers take turns between
+// guilds, so a guild sending many commands can't keep the others waiting. It is safe for concurrent use.
+type WorkQueue struct {
+	mu            sync.Mutex
+	cond          *sync.Cond
+	workers       int
+	maxQueued     int
+	maxGuildQueue int
+	queues        map[string][]queuedJob
+	// order holds the guilds with queued jobs, in the order workers will serve them
+	order   []string
+	queued  int
+	active  int
+	running map[*queuedJob]bool
+	closed  bool
+	stats   QueueStats
+	wg      sync.WaitGroup
+	now     func() time.Time
+}
+
+type queuedJob struct {
+	run      func()
+	cancel   func()
+	queuedAt time.Time
+}
+
+// QueueStats describes the state of a WorkQueue
+type QueueStats struct {
+	Workers   int `json:"workers"`
+	Queued    int `json:"queued"`
+	Active    int `json:"active"`
+	Completed int `json:"completed"`
+	Rejected  int `json:"rejected"`
+	// MaxWait is the longest a job waited in the queue before running
+	MaxWait time.Duration `json:"max_wait"`
+	// LastCompleted is when a job last finished running, or when the queue started if none has
+	LastCompleted time.Time `json:"last_completed"`
+}
+
+// NewWorkQueue starts a WorkQueue with the given number of workers, which holds up to maxQueued jobs waiting
+// to run and up to maxGuildQueue of them from a single guild
+func NewWorkQueue(workers int, maxQueued int, maxGuildQueue int) *WorkQueue {
+	q := &WorkQueue{
+		workers:       workers,
+		maxQueued:     maxQueued,
+		maxGuildQueue: maxGuildQueue,
+		queues:        make(map[string][]queuedJob),
+		running:       make(map[*queuedJob]bool),
+		now:           time.Now,
+	}
+	q.stats.LastCompleted = q.now()
+	q.cond = sync.NewCond(&q.mu)
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues a job for a guild, reporting false if the queue or the guild's share of it is full, or the
+// queue was closed
+func (q *WorkQueue) Submit(guildID string, job func()) bool {
+	return q.SubmitCancelable(guildID, job, nil)
+}
+
+// SubmitCancelable queues a job like Submit, with a cancel func that's called instead if Shutdown gives up on
+// the job before it finishes
+func (q *WorkQueue) SubmitCancelable(guildID string, job func(), cancel func()) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.queued >= q.maxQueued || len(q.queues[guildID]) >= q.maxGuildQueue {
+		q.stats.Rejected++
+		return false
+	}
+	if len(q.queues[guildID]) == 0 {
+		q.order = append(q.order, guildID)
+	}
+	q.queues[guildID] = append(q.queues[guildID], queuedJob{run: job, cancel: cancel, queuedAt: q.now()})
+	q.queued++
+	q.cond.Signal()
+	return true
+}
+
+// Stats returns the current state of the queue
+func (q *WorkQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Workers, stats.Queued, stats.Active = q.workers, q.queued, q.active
+	return stats
+}
+
+// Closed reports whether the queue stopped accepting jobs
+func (q *WorkQueue) Closed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
+
+// Close stops accepting jobs and waits for the queued and running ones to finish
+func (q *WorkQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// Shutdown stops accepting jobs and waits up to grace for the queued and running ones to finish. The jobs that
+// don't finish in time are cancelled, and Shutdown returns how many were. Jobs already running aren't stopped,
+// so their cancel func should tell them to stop and tell whoever's waiting on them that they won't hear back.
+func (q *WorkQueue) Shutdown(grace time.Duration) int {
+	done := make(chan struct{})
+	go func() {
+		q.Close()
+		close(done)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+	}
+
+	q.mu.Lock()
+	var cancels []func()
+	for _, guildID := range q.order {
+		for _, job := range q.queues[guildID] {
+			cancels = append(cancels, job.cancel)
+		}
+		delete(q.queues, guildID)
+	}
+	q.order = nil
+	q.queued = 0
+	for job := range q.running {
+		cancels = append(cancels, job.cancel)
+		delete(q.running, job)
+	}
+	q.mu.Unlock()
+
+	for _, cancel := range cancels {
+		if cancel != nil {
+			cancel()
+		}
+	}
+	return len(cancels)
+}
+
+func (q *WorkQueue) work() {
+	defer q.wg.Done()
+
+	for {
+		job, ok := q.next()
+		if !ok {
+			return
+		}
+		q.runJob(job)
+
+		q.mu.Lock()
+		delete(q.running, job)
+		q.active--
+		q.stats.Completed++
+		q.stats.LastCompleted = q.now()
+		q.mu.Unlock()
+	}
+}
+
+// runJob runs a job, recovering and logging a panic so that it doesn't take the worker down with it
+func (q *WorkQueue) runJob(job *queuedJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			Log.Error("Recovered from a panic in a queued job", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+	job.run()
+}
+
+// next waits for a job, taking it from the guild whose turn it is. It returns false once the queue is closed
+// and empty.
+func (q *WorkQueue) next() (*queuedJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.queued == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+
+	guildID := q.order[0]
+	q.order = q.order[1:]
+	job := q.queues[guildID][0]
+	if len(q.queues[guildID]) > 1 {
+		q.queues[guildID] = q.queues[guildID][1:]
+		// Go to the back of the line for the guild's next job
+		q.order = append(q.order, guildID)
+	} else {
+		delete(q.queues, guildID)
+	}
+	q.queued--
+	q.active++
+	q.running[&job] = true
+
+	if wait := q.now().Sub(job.queuedAt); wait > q.stats.MaxWait {
+		q.stats.MaxWait = wait
+	}
+	return &job, true
+}
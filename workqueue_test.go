@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueFairness(t *testing.T) {
+	queue := NewWorkQueue(1, 10, 5)
+
+	// Block the only worker so the next jobs wait in the queue
+	started, release := make(chan struct{}), make(chan struct{})
+	queue.Submit("busy", func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+	for _, name := range []string{"busy-1", "busy-2", "busy-3"} {
+		queue.Submit("busy", record(name))
+	}
+	queue.Submit("quiet", record("quiet-1"))
+
+	if stats := queue.Stats(); stats.Queued != 4 || stats.Active != 1 {
+		t.Errorf("Stats not properly reported: expected 4 queued and 1 active got %+v", stats)
+	}
+	close(release)
+	queue.Close()
+
+	expected := []string{"busy-1", "quiet-1", "busy-2", "busy-3"}
+	if len(order) != len(expected) {
+		t.Fatalf("Jobs not properly run: expected %v got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Errorf("Guilds should take turns: expected %v got %v", expected, order)
+			break
+		}
+	}
+	if stats := queue.Stats(); stats.Completed != 5 || stats.Queued != 0 {
+		t.Errorf("Stats not properly reported: expected 5 completed got %+v", stats)
+	}
+}
+
+func TestWorkQueueLimits(t *testing.T) {
+	queue := NewWorkQueue(1, 3, 2)
+	started, release := make(chan struct{}), make(chan struct{})
+	queue.Submit("guild", func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	if !queue.Submit("guild", func() {}) || !queue.Submit("guild", func() {}) {
+		t.Errorf("Jobs within the guild's share of the queue should be accepted")
+	}
+	if queue.Submit("guild", func() {}) {
+		t.Errorf("Jobs over the guild's share of the queue should be rejected")
+	}
+	if !queue.Submit("other-guild", func() {}) {
+		t.Errorf("Other guilds should still be able to queue jobs")
+	}
+	if queue.Submit("third-guild", func() {}) {
+		t.Errorf("Jobs over the size of the queue should be rejected")
+	}
+
+	close(release)
+	queue.Close()
+	if queue.Submit("guild", func() {}) {
+		t.Errorf("Jobs submitted after closing the queue should be rejected")
+	}
+	if stats := queue.Stats(); stats.Rejected != 3 {
+		t.Errorf("Rejected jobs not properly counted: expected 3 got %d", stats.Rejected)
+	}
+}
+
+func TestWorkQueueShutdown(t *testing.T) {
+	queue := NewWorkQueue(1, 10, 10)
+	started, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var cancelled []string
+	cancel := func(name string) func() {
+		return func() {
+			mu.Lock()
+			cancelled = append(cancelled, name)
+			mu.Unlock()
+		}
+	}
+	queue.SubmitCancelable("guild", func() {
+		close(started)
+		<-release
+	}, cancel("running"))
+	<-started
+	queue.SubmitCancelable("guild", func() {
+		t.Errorf("Queued jobs should not run after being cancelled")
+	}, cancel("queued"))
+
+	if n := queue.Shutdown(10 * time.Millisecond); n != 2 {
+		t.Errorf("Shutdown should cancel the running and queued jobs, expected 2 got %d", n)
+	}
+	if len(cancelled) != 2 || cancelled[0] != "queued" || cancelled[1] != "running" {
+		t.Errorf("Cancel funcs not properly called, got %v", cancelled)
+	}
+	if !queue.Closed() || queue.Submit("guild", func() {}) {
+		t.Errorf("Jobs submitted after shutting down should be rejected")
+	}
+	close(release)
+}
+
+func TestWorkQueueShutdownDrains(t *testing.T) {
+	queue := NewWorkQueue(2, 10, 10)
+	var mu sync.Mutex
+	ran := 0
+	for i := 0; i < 5; i++ {
+		queue.SubmitCancelable("guild", func() {
+			mu.Lock()
+			ran++
+			mu.Unlock()
+		}, func() {
+			t.Errorf("Jobs finishing within the grace period should not be cancelled")
+		})
+	}
+
+	if n := queue.Shutdown(time.Second); n != 0 || ran != 5 {
+		t.Errorf("Shutdown should let jobs finish within the grace period, got %d cancelled and %d run", n, ran)
+	}
+}
+
+func TestWorkQueuePanic(t *testing.T) {
+	queue := NewWorkQueue(1, 10, 10)
+	done := make(chan struct{})
+	queue.Submit("guild", func() {
+		panic("broken job")
+	})
+	queue.Submit("guild", func() {
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("The worker should keep running jobs after one panics")
+	}
+	if n := queue.Shutdown(time.Second); n != 0 {
+		t.Errorf("No jobs should be left to cancel, got %d", n)
+	}
+	if stats := queue.Stats(); stats.Completed != 2 || stats.Active != 0 {
+		t.Errorf("Panicking jobs not properly counted: expected 2 completed and 0 active got %+v", stats)
+	}
+}